@@ -0,0 +1,62 @@
+package lockers
+
+// The thresholds and current state of a size's capacity alarm. See Inventory.SetAlarm.
+type capacityAlarm struct {
+	low, high int
+	raised bool
+}
+
+// Sets a capacity alarm on a size of locker. The alarm is raised when the size's
+// VirtualCapacity drops to low or below, and cleared only once it rises above high.
+// Capacity moving back and forth between the two thresholds therefore does not raise
+// the alarm again, so high should be somewhat above low. Each change of state is
+// reported to OnCapacityAlarm. Setting a new alarm on a size replaces any existing
+// one, and is immediately raised if the size's capacity is already at or below low.
+func (inv *Inventory) SetAlarm(size LockerSize, low, high int) {
+	if inv.alarms == nil {
+		inv.alarms = make(map[LockerSize]*capacityAlarm)
+	}
+	inv.alarms[size] = &capacityAlarm{low: low, high: high}
+	inv.checkAlarm(size)
+}
+
+// Removes the capacity alarm on a size of locker, if there is one. It is not reported
+// as cleared, even if it was raised.
+func (inv *Inventory) RemoveAlarm(size LockerSize) {
+	delete(inv.alarms, size)
+}
+
+// Returns true if a size has a capacity alarm that is currently raised.
+func (inv *Inventory) AlarmRaised(size LockerSize) bool {
+	alarm, ok := inv.alarms[size]
+	return ok && alarm.raised
+}
+
+// compares a size's capacity against its alarm thresholds, if it has an alarm,
+// and raises or clears the alarm as needed.
+func (inv *Inventory) checkAlarm(size LockerSize) {
+	alarm, ok := inv.alarms[size]
+	if !ok { return }
+	ctrl, ok := inv.Control[size]
+	if !ok { return }
+
+	if !alarm.raised && ctrl.VirtualCapacity <= alarm.low {
+		alarm.raised = true
+	} else if alarm.raised && ctrl.VirtualCapacity > alarm.high {
+		alarm.raised = false
+	} else {
+		return
+	}
+
+	inv.logf("lockers: capacity alarm for size %v raised: %t (%d available)", ctrl.Size, alarm.raised, ctrl.VirtualCapacity)
+	if inv.OnCapacityAlarm != nil {
+		inv.OnCapacityAlarm(size, alarm.raised, ctrl.VirtualCapacity)
+	}
+}
+
+// checks the alarm of every size.
+func (inv *Inventory) checkAlarms() {
+	for size := range inv.alarms {
+		inv.checkAlarm(size)
+	}
+}
@@ -0,0 +1,86 @@
+package lockers
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_Inventory_SetAlarm(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{
+		SizeSpec{1,1,1}: 2,
+		SizeSpec{2,2,2}: 3,
+	})
+	inv.Clock = ticking_clock()
+	small := inv.Sizes[SizeSpec{1,1,1}]
+
+	var events []string
+	inv.OnCapacityAlarm = func(size LockerSize, raised bool, capacity int) {
+		if size != small {
+			t.Errorf("Unexpected alarm for size %d", size)
+		}
+		events = append(events, fmt.Sprintf("%t %d", raised, capacity))
+	}
+	inv.SetAlarm(small, 1, 3)
+	if len(events) != 0 || inv.AlarmRaised(small) {
+		t.Fatalf("Alarm raised with plenty of capacity")
+	}
+
+	type X struct {
+		deposit bool
+		expected []string
+	}
+
+	// capacity starts at 5; each step deposits or retrieves one package.
+	steps := []X{
+		X{true, nil},                                       // 4
+		X{true, nil},                                       // 3
+		X{true, nil},                                       // 2
+		X{true, []string{"true 1"}},                        // 1, raised
+		X{false, []string{"true 1"}},                       // 2
+		X{true, []string{"true 1"}},                        // 1, still raised, no repeat
+		X{true, []string{"true 1"}},                        // 0
+		X{false, []string{"true 1"}},                       // 1
+		X{false, []string{"true 1"}},                       // 2
+		X{false, []string{"true 1"}},                       // 3, not above high
+		X{false, []string{"true 1", "false 4"}},            // 4, cleared
+		X{true, []string{"true 1", "false 4"}},             // 3
+		X{true, []string{"true 1", "false 4"}},             // 2
+		X{true, []string{"true 1", "false 4", "true 1"}},   // 1, raised again
+	}
+
+	var stored []PackageID
+	for i, step := range steps {
+		if step.deposit {
+			pkg := &Package{Id: PackageID(fmt.Sprint(i)), Size: SizeSpec{1,1,1}}
+			if _, err := inv.DepositPackageAllowDirty(pkg); err != nil {
+				t.Fatalf("Step %d: unexpected error: %v", i, err)
+			}
+			stored = append(stored, pkg.Id)
+		} else {
+			if _, err := inv.RetrievePackageById(stored[len(stored) - 1]); err != nil {
+				t.Fatalf("Step %d: unexpected error: %v", i, err)
+			}
+			stored = stored[:len(stored) - 1]
+		}
+		if !reflect.DeepEqual(events, step.expected) {
+			t.Fatalf("Step %d: unexpected alarms: got %v, expected %v", i, events, step.expected)
+		}
+		if inv.AlarmRaised(small) != (len(events) % 2 == 1) {
+			t.Errorf("Step %d: AlarmRaised disagrees with reported alarms", i)
+		}
+	}
+
+	reported := len(events)
+	c := inv.clone()
+	c.RetrievePackageById(stored[0])
+	c.RetrievePackageById(stored[1])
+	if len(events) != reported {
+		t.Errorf("Changes to a copy were reported")
+	}
+
+	inv.RemoveAlarm(small)
+	if inv.AlarmRaised(small) {
+		t.Errorf("Removed alarm still raised")
+	}
+}
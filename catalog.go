@@ -0,0 +1,143 @@
+package lockers
+
+import (
+	"math/big"
+	"sort"
+)
+
+// a group of package sizes which would share one locker size in a catalog. Volumes
+// are kept exactly, since the sizes being designed for may be large enough for them
+// to overflow an int64 (see SizeSpec.VolumeBig).
+type catalogCluster struct {
+	// the smallest size containing every package in the group.
+	box SizeSpec
+	count int64
+	volume *big.Int
+}
+
+// the volume wasted by storing every package in the group in a locker of its box.
+func (c catalogCluster) waste() *big.Int {
+	w := c.box.VolumeBig()
+	w.Mul(w, big.NewInt(c.count))
+	return w.Sub(w, c.volume)
+}
+
+// merges two groups, growing the box to contain both.
+func (c catalogCluster) merge(o catalogCluster) catalogCluster {
+	box := c.box
+	if o.box.Length > box.Length { box.Length = o.box.Length }
+	if o.box.Width > box.Width { box.Width = o.box.Width }
+	if o.box.Height > box.Height { box.Height = o.box.Height }
+	return catalogCluster{box, c.count + o.count, new(big.Int).Add(c.volume, o.volume)}
+}
+
+// Proposes up to maxSizes locker sizes which between them can hold every package in
+// demand, with as little wasted volume as it can manage. This is a design tool for
+// choosing the sizes to build into a new facility, given a sample of package sizes.
+// Sizes in demand may appear any number of times, and count once per appearance.
+// The result is normalized, ordered from smallest to largest, and empty if demand is
+// empty or maxSizes is less than one.
+//
+// Finding the best catalog is a hard covering problem, so a greedy agglomerative
+// heuristic is used instead: every distinct package size starts out as a locker size
+// of its own, and then the two sizes whose merger adds the least wasted volume are
+// repeatedly replaced with the smallest size containing both, until no more than
+// maxSizes remain. Each package ends up in exactly one size which contains it, but
+// not necessarily the tightest one in the result. Runs in O(n^3) for n distinct sizes.
+func MinimalCatalog(demand []SizeSpec, maxSizes int) []SizeSpec {
+	if maxSizes < 1 {
+		return nil
+	}
+
+	counts := make(map[SizeSpec]int64, len(demand))
+	for _, size := range demand {
+		counts[size.Normalize()] += 1
+	}
+
+	clusters := make([]catalogCluster, 0, len(counts))
+	for size, count := range counts {
+		clusters = append(clusters, catalogCluster{size, count, size.VolumeBig().Mul(size.VolumeBig(), big.NewInt(count))})
+	}
+	// start from a consistent order, so that ties are always broken the same way
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].box.tighterThan(clusters[j].box) })
+
+	for len(clusters) > maxSizes {
+		best_i, best_j := -1, -1
+		var best_cost *big.Int
+		for i := range clusters {
+			for j := i + 1; j < len(clusters); j++ {
+				cost := clusters[i].merge(clusters[j]).waste()
+				cost.Sub(cost, clusters[i].waste())
+				cost.Sub(cost, clusters[j].waste())
+				if best_i < 0 || cost.Cmp(best_cost) < 0 {
+					best_i, best_j, best_cost = i, j, cost
+				}
+			}
+		}
+
+		clusters[best_i] = clusters[best_i].merge(clusters[best_j])
+		clusters = append(clusters[:best_j], clusters[best_j + 1:]...)
+	}
+
+	result := make([]SizeSpec, 0, len(clusters))
+	for _, c := range clusters {
+		result = append(result, c.box)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].tighterThan(result[j]) })
+	return result
+}
+
+// Records that a package of the given size could not be placed, such as when
+// GetMostSuitableLockerSize fails for it, so that SuggestNewSizes can recommend
+// lockers for the demand which went unmet. Sizes are normalized, so a package counts
+// the same in any orientation.
+func (inv *Inventory) RecordRejection(size SizeSpec) {
+	if inv.rejections == nil {
+		inv.rejections = make(map[SizeSpec]int)
+	}
+	inv.rejections[size.Normalize()] += 1
+}
+
+// Suggests up to budget sizes of locker to add, as guidance for purchasing, based on
+// the rejections recorded with RecordRejection. Each suggestion is the rejected size
+// which would have held the most rejected packages not held by an earlier suggestion,
+// counting every smaller rejected size it contains, so the first suggestion would have
+// absorbed the most rejections. Sizes which would absorb the same number are chosen
+// between by the smaller one, to waste less space. Fewer sizes are suggested if fewer
+// are needed to hold every rejected package, and none if budget is less than one.
+func (inv *Inventory) SuggestNewSizes(budget int) []SizeSpec {
+	candidates := make([]SizeSpec, 0, len(inv.rejections))
+	for size := range inv.rejections {
+		candidates = append(candidates, size)
+	}
+	// start from a consistent order, so that ties are always broken the same way
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].tighterThan(candidates[j]) })
+
+	var suggested []SizeSpec
+	absorbed := make(map[SizeSpec]bool, len(candidates))
+	for len(suggested) < budget {
+		best, best_count := SizeSpec{}, 0
+		for _, size := range candidates {
+			count := 0
+			for _, other := range candidates {
+				if !absorbed[other] && size.Contains(other) {
+					count += inv.rejections[other]
+				}
+			}
+			if count > best_count {
+				best, best_count = size, count
+			}
+		}
+		if best_count == 0 {
+			break
+		}
+
+		suggested = append(suggested, best)
+		for _, other := range candidates {
+			if best.Contains(other) {
+				absorbed[other] = true
+			}
+		}
+	}
+	return suggested
+}
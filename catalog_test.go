@@ -0,0 +1,122 @@
+package lockers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_MinimalCatalog(t *testing.T) {
+	type X struct {
+		demand []SizeSpec
+		max int
+		expected []SizeSpec
+	}
+
+	tests := map[string]X{
+		"empty":       X{nil, 4, []SizeSpec{}},
+		"no sizes":    X{[]SizeSpec{SizeSpec{1,1,1}}, 0, nil},
+		"one each":    X{[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{2,2,2}}, 2, []SizeSpec{SizeSpec{1,1,1}, SizeSpec{2,2,2}}},
+		"normalized":  X{[]SizeSpec{SizeSpec{1,2,3}, SizeSpec{3,2,1}}, 4, []SizeSpec{SizeSpec{3,2,1}}},
+		"one size":    X{[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{3,1,1}, SizeSpec{2,2,1}}, 1, []SizeSpec{SizeSpec{3,2,1}}},
+		// merging the two small sizes wastes 2, less than folding either into the big one
+		"cheapest merge": X{
+			[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{2,1,1}, SizeSpec{4,4,4}},
+			2,
+			[]SizeSpec{SizeSpec{2,1,1}, SizeSpec{4,4,4}},
+		},
+		// a popular size is kept to itself, and the rare one merged into the large size
+		"weighted": X{
+			[]SizeSpec{SizeSpec{2,2,2}, SizeSpec{2,2,2}, SizeSpec{2,2,2}, SizeSpec{3,3,2}, SizeSpec{3,3,3}},
+			2,
+			[]SizeSpec{SizeSpec{2,2,2}, SizeSpec{3,3,3}},
+		},
+		// the waste of folding anything into the huge box is too big for an int64
+		"huge": X{
+			[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{2097152,2097152,1}, SizeSpec{4194304,2097152,2097152}},
+			2,
+			[]SizeSpec{SizeSpec{2097152,2097152,1}, SizeSpec{4194304,2097152,2097152}},
+		},
+		// the box fits in an int64, but the waste of two packages in it does not
+		"huge waste": X{
+			[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{2097152,2097152,1}, SizeSpec{2097152,2097152,2097151}},
+			2,
+			[]SizeSpec{SizeSpec{2097152,2097152,1}, SizeSpec{2097152,2097152,2097151}},
+		},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			result := MinimalCatalog(v.demand, v.max)
+			if !reflect.DeepEqual(result, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, result)
+			}
+			for _, size := range v.demand {
+				fits := false
+				for _, s := range result {
+					fits = fits || s.Contains(size.Normalize())
+				}
+				if !fits && v.max > 0 {
+					t.Errorf("No size in %v holds %v", result, size)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_SuggestNewSizes(t *testing.T) {
+	type X struct {
+		rejected []SizeSpec
+		budget int
+		expected []SizeSpec
+	}
+
+	tests := map[string]X{
+		"nothing rejected": X{nil, 3, nil},
+		"no budget":        X{[]SizeSpec{SizeSpec{1,1,1}}, 0, nil},
+		"normalized":       X{[]SizeSpec{SizeSpec{1,2,3}, SizeSpec{3,2,1}}, 1, []SizeSpec{SizeSpec{3,2,1}}},
+		// the bigger size also takes the smaller packages
+		"containment": X{
+			[]SizeSpec{SizeSpec{1,1,1}, SizeSpec{1,1,1}, SizeSpec{2,2,2}},
+			2,
+			[]SizeSpec{SizeSpec{2,2,2}},
+		},
+		// neither long size contains the other, so the more rejected one comes first
+		"disjoint": X{
+			[]SizeSpec{SizeSpec{5,1,1}, SizeSpec{3,3,1}, SizeSpec{3,3,1}, SizeSpec{1,1,1}},
+			3,
+			[]SizeSpec{SizeSpec{3,3,1}, SizeSpec{5,1,1}},
+		},
+		"over budget": X{
+			[]SizeSpec{SizeSpec{5,1,1}, SizeSpec{3,3,1}, SizeSpec{3,3,1}},
+			1,
+			[]SizeSpec{SizeSpec{3,3,1}},
+		},
+		// sizes absorbing the same number go to the smaller one
+		"tie": X{
+			[]SizeSpec{SizeSpec{5,1,1}, SizeSpec{4,2,1}},
+			2,
+			[]SizeSpec{SizeSpec{5,1,1}, SizeSpec{4,2,1}},
+		},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+			for _, size := range v.rejected {
+				inv.RecordRejection(size)
+			}
+			if suggested := inv.SuggestNewSizes(v.budget); !reflect.DeepEqual(suggested, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, suggested)
+			}
+		})
+	}
+
+	// copies keep their own history
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+	inv.RecordRejection(SizeSpec{2,2,2})
+	c := inv.DeepCopy()
+	c.RecordRejection(SizeSpec{3,3,3})
+	if suggested := inv.SuggestNewSizes(1); !reflect.DeepEqual(suggested, []SizeSpec{SizeSpec{2,2,2}}) {
+		t.Errorf("Rejection on a copy changed the original: %v", suggested)
+	}
+}
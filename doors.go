@@ -0,0 +1,154 @@
+package lockers
+
+import (
+	"errors"
+)
+
+// A type which identifies a door group.
+type DoorGroupID string
+
+// The members of a door group, and whether they are currently held together.
+type doorGroup struct {
+	members []int
+	held bool
+}
+
+// Declares that several lockers are slots behind one physical door, which must be
+// opened together. Anyone who opens the door can reach every slot behind it, so the
+// slots of a door group are never handed out to different customers at once: as soon
+// as a package is deposited into any of them, the rest are held as well, and the
+// whole group only becomes available again once every slot is empty. Held slots are
+// counted as allocated, so a group in use removes all of its lockers from the
+// inventory's capacity, not just the ones with packages in them.
+//
+// Returns an error without changing anything if the id is already in use, or any of
+// the lockers is unknown or already in a door group. If any of the lockers already
+// holds a package, the rest of the group is held immediately.
+func (inv *Inventory) AddDoorGroup(id DoorGroupID, lockers []LockerID) error {
+	if _, ok := inv.doorGroups[id]; ok {
+		return errors.New("Door group ID already in use")
+	}
+	if len(lockers) == 0 {
+		return errors.New("Door group has no lockers")
+	}
+
+	g := &doorGroup{members: make([]int, 0, len(lockers))}
+	for _, locker_id := range lockers {
+		index, ok := inv.LockersById[locker_id]
+		if !ok {
+			return ErrUnknownLockerID
+		}
+		if inv.Lockers[index].DoorGroup != "" {
+			return errors.New("Locker is already in a door group")
+		}
+		for _, x := range g.members {
+			if x == index {
+				return errors.New("Locker is already in a door group")
+			}
+		}
+		g.members = append(g.members, index)
+	}
+
+	if inv.doorGroups == nil {
+		inv.doorGroups = make(map[DoorGroupID]*doorGroup)
+	}
+	inv.doorGroups[id] = g
+	for _, index := range g.members {
+		inv.Lockers[index].DoorGroup = id
+	}
+	for _, index := range g.members {
+		if inv.Lockers[index].Contents != nil {
+			inv.holdGroup(index)
+			break
+		}
+	}
+	return nil
+}
+
+// Allocates every locker in a door group at once, such as for one customer who needs
+// the whole door. Returns an error without changing anything if the group is unknown,
+// or any of its lockers is not available.
+func (inv *Inventory) AllocateDoorGroup(id DoorGroupID) error {
+	g, ok := inv.doorGroups[id]
+	if !ok {
+		return errors.New("Door group ID not known")
+	}
+	for _, index := range g.members {
+		if inv.availablePosition(index) < 0 {
+			return errors.New("Door group is not available")
+		}
+	}
+
+	for _, index := range g.members {
+		inv.allocateAt(inv.Lockers[index].SizeId, inv.availablePosition(index))
+	}
+	g.held = true
+	return nil
+}
+
+// Returns every locker in a door group to the pool of available lockers after it was
+// allocated with AllocateDoorGroup. Returns an error without changing anything if the
+// group is unknown, is not allocated, or any of its lockers still holds a package.
+// A group held because of deposits into it is released automatically when its last
+// package is retrieved.
+func (inv *Inventory) DeallocateDoorGroup(id DoorGroupID) error {
+	g, ok := inv.doorGroups[id]
+	if !ok {
+		return errors.New("Door group ID not known")
+	}
+	if !g.held {
+		return errors.New("Door group is not allocated")
+	}
+	for _, index := range g.members {
+		if inv.Lockers[index].Contents != nil {
+			return errors.New("Door group is not empty")
+		}
+	}
+
+	inv.releaseGroup(g)
+	return nil
+}
+
+// called after a locker has been allocated for a package. If it is in a door group,
+// the rest of the group's available lockers are allocated along with it.
+func (inv *Inventory) holdGroup(locker_index int) {
+	g, ok := inv.doorGroups[inv.Lockers[locker_index].DoorGroup]
+	if !ok || g.held { return }
+
+	for _, index := range g.members {
+		if position := inv.availablePosition(index); position >= 0 {
+			inv.allocateAt(inv.Lockers[index].SizeId, position)
+		}
+	}
+	g.held = true
+}
+
+// called after a package has been taken out of a locker, in place of DeallocateLocker.
+// A locker in a door group stays allocated until every locker in the group is empty,
+// at which point they are all returned together. Lockers out of service stay allocated.
+func (inv *Inventory) release(locker_index int) {
+	g, ok := inv.doorGroups[inv.Lockers[locker_index].DoorGroup]
+	if !ok {
+		if !inv.Lockers[locker_index].OutOfService {
+			inv.DeallocateLocker(locker_index)
+		}
+		return
+	}
+
+	for _, index := range g.members {
+		if inv.Lockers[index].Contents != nil { return }
+	}
+	inv.releaseGroup(g)
+}
+
+// returns every unavailable locker in a group to the pool of available lockers,
+// except ones which are out of service.
+func (inv *Inventory) releaseGroup(g *doorGroup) {
+	for _, index := range g.members {
+		if inv.Lockers[index].OutOfService { continue }
+		if inv.availablePosition(index) < 0 {
+			inv.DeallocateLocker(index)
+		}
+	}
+	g.held = false
+}
@@ -0,0 +1,150 @@
+package lockers
+
+import (
+	"testing"
+)
+
+// three lockers of one size, two of which ("g1" and "g2") share a door.
+func door_fixture(t *testing.T) (*Inventory, LockerSize) {
+	t.Helper()
+
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"x", "g1", "g2"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.Clock = ticking_clock()
+	if err := inv.AddDoorGroup("door", []LockerID{"g1", "g2"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return inv, inv.Sizes[SizeSpec{1,1,1}]
+}
+
+func Test_Inventory_AddDoorGroup(t *testing.T) {
+	type X struct {
+		id DoorGroupID
+		lockers []LockerID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"ok":             X{"other", []LockerID{"x"}, false},
+		"duplicate id":   X{"door", []LockerID{"x"}, true},
+		"unknown locker": X{"other", []LockerID{"y"}, true},
+		"already used":   X{"other", []LockerID{"x", "g1"}, true},
+		"repeated":       X{"other", []LockerID{"x", "x"}, true},
+		"empty":          X{"other", nil, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := door_fixture(t)
+			err := inv.AddDoorGroup(v.id, v.lockers)
+			if (err != nil) != v.is_error {
+				t.Errorf("Unexpected error result: %v", err)
+			}
+			if v.is_error && inv.Lockers[inv.LockersById["x"]].DoorGroup != "" {
+				t.Errorf("Failed call changed the inventory")
+			}
+		})
+	}
+}
+
+func Test_Inventory_DoorGroup_Deposit(t *testing.T) {
+	inv, size_id := door_fixture(t)
+	ctrl := inv.Control[size_id]
+
+	grouped := PackageID("")
+	for _, id := range []PackageID{"a", "b"} {
+		locker_id, err := inv.DepositPackage(&Package{Id: id, Size: SizeSpec{1,1,1}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if locker_id != "x" {
+			grouped = id
+		}
+	}
+	if grouped == "" {
+		t.Fatalf("No package was deposited into the door group")
+	}
+
+	// the empty slot behind the shared door must not go to anyone else
+	if ctrl.VirtualCapacity != 0 || len(ctrl.Lockers) != 0 {
+		t.Errorf("Door group lockers still available: capacity %d, free %v", ctrl.VirtualCapacity, ctrl.Lockers)
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "c", Size: SizeSpec{1,1,1}}); err == nil {
+		t.Errorf("Expected deposit into a held door group to fail")
+	}
+	if err := inv.DeallocateDoorGroup("door"); err == nil {
+		t.Errorf("Expected deallocating an occupied door group to fail")
+	}
+
+	if _, err := inv.RetrievePackageById(grouped); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if ctrl.VirtualCapacity != 2 || len(ctrl.Lockers) != 2 {
+		t.Errorf("Door group not released: capacity %d, free %v", ctrl.VirtualCapacity, ctrl.Lockers)
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func Test_Inventory_AllocateDoorGroup(t *testing.T) {
+	inv, size_id := door_fixture(t)
+	ctrl := inv.Control[size_id]
+
+	if err := inv.DeallocateDoorGroup("door"); err == nil {
+		t.Errorf("Expected deallocating an unallocated door group to fail")
+	}
+	if err := inv.AllocateDoorGroup("nope"); err == nil {
+		t.Errorf("Expected allocating an unknown door group to fail")
+	}
+
+	if err := inv.AllocateDoorGroup("door"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if ctrl.VirtualCapacity != 1 || len(ctrl.Lockers) != 1 {
+		t.Errorf("Door group not allocated: capacity %d, free %v", ctrl.VirtualCapacity, ctrl.Lockers)
+	}
+	if err := inv.AllocateDoorGroup("door"); err == nil {
+		t.Errorf("Expected allocating a held door group to fail")
+	}
+
+	if err := inv.DeallocateDoorGroup("door"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if ctrl.VirtualCapacity != 3 || len(ctrl.Lockers) != 3 {
+		t.Errorf("Door group not deallocated: capacity %d, free %v", ctrl.VirtualCapacity, ctrl.Lockers)
+	}
+}
+
+func Test_Inventory_DoorGroup_Merge(t *testing.T) {
+	other, _ := door_fixture(t)
+	if err := other.AllocateDoorGroup("door"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	inv := basic(t)
+	if err := inv.Merge(other); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if inv.Control[100].VirtualCapacity != 7 {
+		t.Errorf("Held door group counted as available: capacity %d", inv.Control[100].VirtualCapacity)
+	}
+	if err := inv.DeallocateDoorGroup("door"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if inv.Control[100].VirtualCapacity != 9 {
+		t.Errorf("Door group not deallocated: capacity %d", inv.Control[100].VirtualCapacity)
+	}
+
+	again, _ := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"y"}})
+	if err := again.AddDoorGroup("door", []LockerID{"y"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.Merge(again); err == nil {
+		t.Errorf("Expected merging a duplicate door group to fail")
+	}
+}
@@ -0,0 +1,152 @@
+package lockers
+
+import (
+	"errors"
+	"sort"
+)
+
+// The dimensions of a locker or package, as they appear in an InventoryDTO.
+type SizeDTO struct {
+	Length int `json:"length"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+}
+
+// A size of locker in an InventoryDTO, and how many lockers there are of it.
+type SizeCountDTO struct {
+	SizeDTO
+	Count int `json:"count"`
+}
+
+// A stored package in an InventoryDTO.
+type PackageDTO struct {
+	Id PackageID `json:"id"`
+	Size SizeDTO `json:"size"`
+	Margin int `json:"margin,omitempty"`
+}
+
+// A locker in an InventoryDTO, and the package stored in it, if any.
+type LockerDTO struct {
+	Id LockerID `json:"id"`
+	Size SizeDTO `json:"size"`
+	Package *PackageDTO `json:"package,omitempty"`
+
+	// packages stacked on top of Package in a bulk locker, if any.
+	Stacked []PackageDTO `json:"stacked,omitempty"`
+}
+
+// A representation of an inventory which is safe to encode as JSON and hand to
+// outside consumers, such as over HTTP. Unlike Inventory, it has no indices or
+// pointers, and its layout is a stable contract which does not follow changes to
+// the inventory's internals. It describes only the lockers and what is stored in
+// them; settings such as purposes, door groups, timestamps and reserves are not
+// included.
+type InventoryDTO struct {
+	// every size of locker, from smallest to largest.
+	Sizes []SizeCountDTO `json:"sizes"`
+
+	// every locker, in inventory order.
+	Lockers []LockerDTO `json:"lockers"`
+}
+
+func sizeDTO(s SizeSpec) SizeDTO {
+	return SizeDTO{s.Length, s.Width, s.Height}
+}
+
+func (s SizeDTO) spec() SizeSpec {
+	return SizeSpec{s.Length, s.Width, s.Height}
+}
+
+// Converts the inventory into its external representation. See InventoryDTO.
+func (inv *Inventory) ToDTO() InventoryDTO {
+	counts := make(map[LockerSize]int, len(inv.Control))
+	dto := InventoryDTO{
+		Sizes: make([]SizeCountDTO, 0, len(inv.Control)),
+		Lockers: make([]LockerDTO, 0, len(inv.Lockers)),
+	}
+
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		counts[l.SizeId] += 1
+
+		x := LockerDTO{Id: l.Id, Size: sizeDTO(inv.Control[l.SizeId].Size)}
+		if l.Contents != nil {
+			x.Package = &PackageDTO{Id: l.Contents.Id, Size: sizeDTO(l.Contents.Size), Margin: l.Contents.Margin}
+		}
+		for _, p := range l.Stacked {
+			x.Stacked = append(x.Stacked, PackageDTO{Id: p.Id, Size: sizeDTO(p.Size), Margin: p.Margin})
+		}
+		dto.Lockers = append(dto.Lockers, x)
+	}
+
+	for size_id, ctrl := range inv.Control {
+		dto.Sizes = append(dto.Sizes, SizeCountDTO{sizeDTO(ctrl.Size), counts[size_id]})
+	}
+	sort.Slice(dto.Sizes, func(i, j int) bool { return dto.Sizes[i].spec().tighterThan(dto.Sizes[j].spec()) })
+	return dto
+}
+
+// Reconstructs an inventory from its external representation, with the same locker
+// IDs and stored packages. Returns an error if the representation is inconsistent:
+// if a locker's size is not listed, the lockers of a size do not match its count,
+// or a locker or package ID appears more than once, or a package does not fit in
+// its locker.
+func FromDTO(dto InventoryDTO) (*Inventory, error) {
+	inv := newInventory(len(dto.Sizes), len(dto.Lockers))
+
+	counts := make(map[LockerSize]int, len(dto.Sizes))
+	for _, s := range dto.Sizes {
+		size_id := inv.addSize(s.spec(), s.Count)
+		if _, ok := counts[size_id]; ok {
+			return nil, errors.New("Duplicate locker size")
+		}
+		counts[size_id] = s.Count
+	}
+
+	for _, l := range dto.Lockers {
+		size_id, ok := inv.Sizes[l.Size.spec().Normalize()]
+		if !ok {
+			return nil, ErrUnknownLockerSize
+		}
+		if _, ok := inv.LockersById[l.Id]; ok {
+			return nil, errors.New("Duplicate locker ID")
+		}
+		counts[size_id] -= 1
+		index := inv.addLocker(size_id, l.Id)
+		if l.Package == nil {
+			if len(l.Stacked) != 0 {
+				return nil, errors.New("Stacked packages in empty locker")
+			}
+			continue
+		}
+
+		for i, p := range append([]PackageDTO{*l.Package}, l.Stacked...) {
+			if _, ok := inv.LockersByPackageId[p.Id]; ok {
+				return nil, ErrDuplicatePackageID
+			}
+			pkg := &Package{Id: p.Id, Size: p.Size.spec(), Margin: p.Margin}
+			if !inv.Control[size_id].Size.Contains(pkg.Size.Normalize()) {
+				return nil, errors.New("Package does not fit in locker")
+			}
+			if i == 0 {
+				inv.Lockers[index].Put(pkg)
+			} else {
+				inv.Lockers[index].Stacked = append(inv.Lockers[index].Stacked, pkg)
+				inv.Lockers[index].VolumeUsed += pkg.Size.Normalize().Volume()
+			}
+			inv.LockersByPackageId[p.Id] = index
+		}
+		inv.allocateAt(size_id, inv.availablePosition(index))
+	}
+
+	for _, count := range counts {
+		if count != 0 {
+			return nil, errors.New("Locker count does not match size")
+		}
+	}
+
+	inv.RepairBackPointers()
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return inv, nil
+}
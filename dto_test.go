@@ -0,0 +1,136 @@
+package lockers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_Inventory_ToDTO(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"1", "2"},
+		SizeSpec{5,1,1}: []LockerID{"3", "4", "locker"},
+		SizeSpec{1,3,3}: []LockerID{"5", "6"},
+		SizeSpec{5,5,5}: []LockerID{"7", "8"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "locker", &Package{Id: "abc", Size: SizeSpec{1,1,1}})
+	dto := inv.ToDTO()
+
+	expected_sizes := []SizeCountDTO{
+		SizeCountDTO{SizeDTO{1,1,1}, 2},
+		SizeCountDTO{SizeDTO{5,1,1}, 3},
+		SizeCountDTO{SizeDTO{3,3,1}, 2},
+		SizeCountDTO{SizeDTO{5,5,5}, 2},
+	}
+	if !reflect.DeepEqual(dto.Sizes, expected_sizes) {
+		t.Errorf("Wrong sizes: expected %v, got %v", expected_sizes, dto.Sizes)
+	}
+
+	occupied := 0
+	for _, l := range dto.Lockers {
+		if l.Package == nil { continue }
+		occupied += 1
+		if l.Id != "locker" || l.Package.Id != "abc" || l.Package.Size != (SizeDTO{1,1,1}) {
+			t.Errorf("Wrong occupied locker: %+v %+v", l, *l.Package)
+		}
+	}
+	if occupied != 1 || len(dto.Lockers) != len(inv.Lockers) {
+		t.Errorf("Wrong lockers: %+v", dto.Lockers)
+	}
+
+	// the round trip goes through JSON, as it would over the wire
+	data, err := json.Marshal(dto)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	var decoded InventoryDTO
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	restored, err := FromDTO(decoded)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := restored.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if ok, reason := CompareInventories(t, inv, restored); !ok {
+		t.Errorf("Restored inventory differs: %s", reason)
+	}
+}
+
+func Test_FromDTO(t *testing.T) {
+	small := SizeDTO{1,1,1}
+	big := SizeDTO{2,2,2}
+
+	type X struct {
+		dto InventoryDTO
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"empty": X{InventoryDTO{}, false},
+		"no lockers": X{InventoryDTO{Sizes: []SizeCountDTO{SizeCountDTO{small, 0}}}, false},
+		"ok": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 1}, SizeCountDTO{big, 1}},
+			Lockers: []LockerDTO{
+				LockerDTO{Id: "1", Size: small},
+				LockerDTO{Id: "2", Size: big, Package: &PackageDTO{Id: "a", Size: SizeDTO{1,2,1}}},
+			},
+		}, false},
+		"unknown size": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 1}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: big}},
+		}, true},
+		"wrong count": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 2}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: small}},
+		}, true},
+		"duplicate size": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 0}, SizeCountDTO{small, 0}},
+		}, true},
+		"duplicate locker": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 2}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: small}, LockerDTO{Id: "1", Size: small}},
+		}, true},
+		"duplicate package": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 2}},
+			Lockers: []LockerDTO{
+				LockerDTO{Id: "1", Size: small, Package: &PackageDTO{Id: "a", Size: small}},
+				LockerDTO{Id: "2", Size: small, Package: &PackageDTO{Id: "a", Size: small}},
+			},
+		}, true},
+		"stacked": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{big, 1}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: big, Package: &PackageDTO{Id: "a", Size: small}, Stacked: []PackageDTO{PackageDTO{Id: "b", Size: small}}}},
+		}, false},
+		"stacked in empty": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{big, 1}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: big, Stacked: []PackageDTO{PackageDTO{Id: "b", Size: small}}}},
+		}, true},
+		"too big": X{InventoryDTO{
+			Sizes: []SizeCountDTO{SizeCountDTO{small, 1}},
+			Lockers: []LockerDTO{LockerDTO{Id: "1", Size: small, Package: &PackageDTO{Id: "a", Size: big}}},
+		}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := FromDTO(v.dto)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if err != nil { return }
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(inv.ToDTO().Lockers, v.dto.Lockers) && len(v.dto.Lockers) != 0 {
+				t.Errorf("Lockers not preserved: expected %+v, got %+v", v.dto.Lockers, inv.ToDTO().Lockers)
+			}
+		})
+	}
+}
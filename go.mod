@@ -2,4 +2,4 @@ module github.com/thewug/lockers
 
 go 1.16
 
-require github.com/google/uuid v1.2.0 // indirect
+require github.com/google/uuid v1.2.0
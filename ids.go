@@ -0,0 +1,16 @@
+package lockers
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Returns a locker ID generator for NewInventoryWithIDGen which yields "prefix-1",
+// "prefix-2", and so on, as a readable alternative to UUIDs. The generator is safe to
+// call concurrently. IDs from two generators with the same prefix will collide.
+func SequentialIDGen(prefix string) func() LockerID {
+	var n uint64
+	return func() LockerID {
+		return LockerID(prefix + "-" + strconv.FormatUint(atomic.AddUint64(&n, 1), 10))
+	}
+}
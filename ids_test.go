@@ -0,0 +1,114 @@
+package lockers
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func Test_SequentialIDGen(t *testing.T) {
+	gen := SequentialIDGen("A")
+	for _, expected := range []LockerID{"A-1", "A-2", "A-3"} {
+		if id := gen(); id != expected {
+			t.Errorf("Expected %s, got %s", expected, id)
+		}
+	}
+
+	// concurrent callers never see the same ID
+	gen = SequentialIDGen("B")
+	ids := make(chan LockerID, 1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ids <- gen()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[LockerID]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("Duplicate ID %s", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 1000 {
+		t.Errorf("Expected 1000 IDs, got %d", len(seen))
+	}
+}
+
+func Test_NewInventoryWithIDGen(t *testing.T) {
+	counts := map[SizeSpec]int{
+		SizeSpec{2,2,2}: 1,
+		SizeSpec{1,1,1}: 2,
+		SizeSpec{1,1,2}: 1,
+	}
+
+	// smallest sizes first
+	sizes := map[LockerID]SizeSpec{
+		"L-1": SizeSpec{1,1,1},
+		"L-2": SizeSpec{1,1,1},
+		"L-3": SizeSpec{2,1,1},
+		"L-4": SizeSpec{2,2,2},
+	}
+
+	inv := NewInventoryWithIDGen(counts, SequentialIDGen("L"))
+	ids := make([]LockerID, 0, len(inv.Lockers))
+	for _, l := range inv.Lockers {
+		ids = append(ids, l.Id)
+		if inv.Control[l.SizeId].Size != sizes[l.Id] {
+			t.Errorf("Locker %s has the wrong size %v", l.Id, inv.Control[l.SizeId].Size)
+		}
+	}
+	if !reflect.DeepEqual(ids, []LockerID{"L-1", "L-2", "L-3", "L-4"}) {
+		t.Errorf("Unexpected IDs: %v", ids)
+	}
+
+	if ok, reason := CompareInventories(t, inv, NewInventory(counts)); !ok {
+		t.Errorf("Inventory differs from NewInventory: %s", reason)
+	}
+}
+
+func Test_NewInventoryWithOptions(t *testing.T) {
+	counts := map[SizeSpec]int{
+		SizeSpec{2,2,2}: 1,
+		SizeSpec{1,1,1}: 2,
+	}
+
+	calls := 0
+	gen := SequentialIDGen("A")
+	inv := NewInventoryWithOptions(counts, WithIDGenerator(func() LockerID {
+		calls += 1
+		return gen()
+	}))
+	ids := make([]LockerID, 0, len(inv.Lockers))
+	for _, l := range inv.Lockers {
+		ids = append(ids, l.Id)
+	}
+	if !reflect.DeepEqual(ids, []LockerID{"A-1", "A-2", "A-3"}) || calls != 3 {
+		t.Errorf("Unexpected IDs: %v (%d calls)", ids, calls)
+	}
+	if ok, reason := CompareInventories(t, inv, NewInventory(counts)); !ok {
+		t.Errorf("Inventory differs from NewInventory: %s", reason)
+	}
+
+	// lockers added later use the same generator.
+	added := inv.AddLockers(SizeSpec{3,3,3}, 2)
+	if !reflect.DeepEqual(added, []LockerID{"A-4", "A-5"}) {
+		t.Errorf("Unexpected IDs for added lockers: %v", added)
+	}
+
+	// without options, IDs are random as in NewInventory.
+	plain := NewInventoryWithOptions(counts)
+	if len(plain.Lockers) != 3 || plain.Lockers[0].Id == plain.Lockers[1].Id || plain.Lockers[0].Id == "A-1" {
+		t.Errorf("Unexpected IDs without options: %v", plain.Lockers)
+	}
+	if ok, reason := CompareInventories(t, plain, NewInventory(counts)); !ok {
+		t.Errorf("Inventory differs from NewInventory: %s", reason)
+	}
+}
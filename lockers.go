@@ -5,7 +5,16 @@
 package lockers
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -19,6 +28,52 @@ type PackageID string
 // defines IDs for identifying lockers. Expected to be unique, in an inventory.
 type LockerID string
 
+// returned when a package cannot be placed because no size of locker which could hold
+// it has a usable locker available.
+var ErrNoLockerFits = errors.New("No available lockers which can fit package")
+
+// the original name of ErrNoLockerFits, kept for existing callers. They are the same
+// error, so either may be used with errors.Is.
+var ErrNoSuitableLocker = ErrNoLockerFits
+
+// returned when putting a package into a locker which already holds one, or changing
+// a locker which must be empty for the change.
+var ErrLockerNotEmpty = errors.New("Locker is not empty")
+
+// returned when putting a package into a locker which is already stored in one.
+var ErrPackageAlreadyStored = errors.New("Package already in locker")
+
+// returned when fetching from a locker which holds no package.
+var ErrLockerEmpty = errors.New("Tried to fetch from empty locker")
+
+// returned when a package is deposited or loaded with the ID of one already stored.
+var ErrDuplicatePackageID = errors.New("Duplicate package ID")
+
+// returned when a package ID is not stored in the inventory.
+var ErrUnknownPackageID = errors.New("Package ID not known")
+
+// returned when a locker ID is not part of the inventory.
+var ErrUnknownLockerID = errors.New("Locker ID not known")
+
+// returned when a locker size is not part of the inventory.
+var ErrUnknownLockerSize = errors.New("Locker size not known")
+
+// returned when a locker of some size is needed, but none of that size are available.
+var ErrNoLockerAvailable = errors.New("No lockers of size available")
+
+// returned when a package is not placed because the inventory is as full as its
+// MaxOccupancy allows, even though a locker for it may be available.
+var ErrFacilityAtCapacity = errors.New("Inventory is at its maximum occupancy")
+
+// an opaque, stable reference to a locker in an inventory. Lockers are stored by index
+// in Inventory.Lockers, and those indices (as found in LockerControlSpec.Lockers and
+// the Inventory's lookup maps, or returned by AllocateLocker) are only meaningful until
+// lockers are added, removed or rearranged. A LockerRef keeps referring to the same
+// locker for as long as it remains in the inventory. The zero LockerRef is never valid.
+type LockerRef struct {
+	n uint64
+}
+
 // defines a limited interface by which LockerSize objects might access Inventory
 // functionality when determining relative priority for storing new objects.
 type IControlSpec interface{
@@ -27,38 +82,42 @@ type IControlSpec interface{
 
 // Performs an inventory aware comparison of 2 locker sizes. the "earliest"
 // locker size is the one with the largest number of available spaces for
-// items of this size, and then the one with the smallest volume.
+// items of this size (its SelectionCapacity), and then the one with the
+// smallest volume. Sizes which tie on both are not before each other; selection
+// breaks such ties by the order it passes candidates to the strategy in, which
+// never depends on map iteration (see GetMostSuitableLockerSize).
 func (id LockerSize) Before(other_id LockerSize, inv IControlSpec) bool {
 	self, other := inv.ControlSpec(id), inv.ControlSpec(other_id)
-	if self.VirtualCapacity > other.VirtualCapacity {
+	self_capacity, other_capacity := self.SelectionCapacity(), other.SelectionCapacity()
+	if self_capacity > other_capacity {
 		return true
-	} else if self.VirtualCapacity == other.VirtualCapacity && self.Size.Volume() < other.Size.Volume() {
+	} else if self_capacity == other_capacity && self.Size.compareVolume(other.Size) < 0 {
 		return true
 	}
 
 	return false
 }
 
+// The mirror of Before: reports whether a locker size comes strictly after another,
+// by the same ordering. At most one of Before and After is true for any two sizes,
+// and sizes which tie are neither before nor after each other.
+func (id LockerSize) After(other_id LockerSize, inv IControlSpec) bool {
+	return other_id.Before(id, inv)
+}
+
 // a 3 dimensional vector, concretely representing the dimensions of a locker or package.
 type SizeSpec struct {
 	Length, Width, Height int
 }
 
 // Normalizes a SizeSpec by making any negative dimensions positive, and then sorting
-// dimensions in descending order.
+// dimensions in descending order. The most negative int has no positive counterpart,
+// so it becomes zero. See NormalizeChecked.
 func (spec SizeSpec) Normalize() SizeSpec {
 	// make sure all values are positive or zero
-	// this doesn't actually completely work, because MIN_INT = -MIN_INT, but
-	// I'm ignoring that case because it is not practically useful to consider it
-	if spec.Length < 0 {
-		spec.Length = 0 - spec.Length
-	}
-	if spec.Width < 0 {
-		spec.Width = 0 - spec.Width
-	}
-	if spec.Height < 0 {
-		spec.Height = 0 - spec.Height
-	}
+	spec.Length = absDimension(spec.Length)
+	spec.Width = absDimension(spec.Width)
+	spec.Height = absDimension(spec.Height)
 
 	// hard coded 3 element bubble sort
 	if spec.Height > spec.Width {
@@ -73,19 +132,195 @@ func (spec SizeSpec) Normalize() SizeSpec {
 	return spec
 }
 
-// Computes the 3D volume of a SizeSpec, length * width * height.
+// Normalizes a SizeSpec as Normalize, but returns an error instead of a clamped value
+// if a dimension has no positive counterpart. The most negative int is its own
+// negation, so Normalize clamps it to zero to keep the result positive; use this to
+// reject such sizes from untrusted input instead.
+func (spec SizeSpec) NormalizeChecked() (SizeSpec, error) {
+	for _, x := range []int{spec.Length, spec.Width, spec.Height} {
+		if x < 0 && -x < 0 {
+			return SizeSpec{}, errors.New("Size dimension out of range")
+		}
+	}
+	return spec.Normalize(), nil
+}
+
+// returns the absolute value of a dimension, or zero for the most negative int, which
+// cannot be negated.
+func absDimension(x int) int {
+	if x < 0 {
+		x = -x
+	}
+	if x < 0 {
+		return 0
+	}
+	return x
+}
+
+// Computes the 3D volume of a SizeSpec, length * width * height. A volume which does
+// not fit in an int64, which takes dimensions of about two million each, is clamped to
+// the largest (or, for a negative volume, the smallest) int64 rather than wrapping
+// around; see VolumeBig for the exact volume of sizes which may be that large.
 func (spec SizeSpec) Volume() int64 {
-	return int64(spec.Length) * int64(spec.Width) * int64(spec.Height)
+	return mulSaturated(mulSaturated(int64(spec.Length), int64(spec.Width)), int64(spec.Height))
+}
+
+// Computes the 3D volume of a SizeSpec exactly, however large its dimensions are.
+func (spec SizeSpec) VolumeBig() *big.Int {
+	v := big.NewInt(int64(spec.Length))
+	v.Mul(v, big.NewInt(int64(spec.Width)))
+	return v.Mul(v, big.NewInt(int64(spec.Height)))
+}
+
+// computes the volume of a SizeSpec, and reports whether it fits in an int64.
+func (spec SizeSpec) volumeChecked() (int64, bool) {
+	v, ok := mulChecked(int64(spec.Length), int64(spec.Width))
+	if !ok {
+		return 0, false
+	}
+	return mulChecked(v, int64(spec.Height))
+}
+
+// multiplies two int64s, and reports whether the product fits in an int64.
+func mulChecked(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	if p / b != a || (a == -1 && b == -1 << 63) || (b == -1 && a == -1 << 63) {
+		return 0, false
+	}
+	return p, true
+}
+
+// multiplies two int64s, clamping a product which does not fit in an int64 to the
+// largest or smallest int64, whichever has its sign.
+func mulSaturated(a, b int64) int64 {
+	if p, ok := mulChecked(a, b); ok {
+		return p
+	}
+	if (a < 0) != (b < 0) {
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// compares the volumes of two SizeSpecs without overflowing, returning -1, 0 or 1 as
+// the first is smaller than, the same as, or larger than the second.
+func (spec SizeSpec) compareVolume(other SizeSpec) int {
+	a, a_ok := spec.volumeChecked()
+	b, b_ok := other.volumeChecked()
+	if a_ok && b_ok {
+		switch {
+		case a < b: return -1
+		case a > b: return 1
+		}
+		return 0
+	}
+	return spec.VolumeBig().Cmp(other.VolumeBig())
 }
 
 // Checks if a SizeSpec fully contains another.  You MUST normalize both SizeSpecs
-// before using this function, or it will produce inaccurate results.
+// before using this function, or it will produce inaccurate results. Once normalized,
+// the longest sides are compared, then the middle ones, then the shortest, which
+// allows for any rotation of the contained box; see ContainsOriented for a check
+// which does not.
 func (spec SizeSpec) Contains(other SizeSpec) bool {
+	return spec.ContainsOriented(other)
+}
+
+// Checks if a SizeSpec fully contains another without turning it: Length, Width and
+// Height are compared with each other's Length, Width and Height, and neither SizeSpec
+// is normalized, so this is strict where Contains is rotation-agnostic. Both should be
+// given in the same frame, such as a locker as installed (see
+// LockerControlSpec.Oriented) and a package as it must be kept.
+func (spec SizeSpec) ContainsOriented(other SizeSpec) bool {
 	return spec.Length >= other.Length &&
 	       spec.Width  >= other.Width  &&
 	       spec.Height >= other.Height
 }
 
+// Checks if two SizeSpecs describe the same box, in any orientation.
+func (spec SizeSpec) Equal(other SizeSpec) bool {
+	return spec.Normalize() == other.Normalize()
+}
+
+// Hashes a SizeSpec, independent of its orientation, so that SizeSpecs which are
+// Equal have the same hash. The hash is the 64 bit FNV-1a hash of the normalized
+// Length, Width and Height, each encoded as a little endian int64, in that order.
+// It is stable across runs, processes and platforms.
+func (spec SizeSpec) Hash() uint64 {
+	spec = spec.Normalize()
+
+	var buf [24]byte
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(int64(spec.Length)))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(int64(spec.Width)))
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(int64(spec.Height)))
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+// Parses a SizeSpec written as its Length, Width and Height separated by an x, such as
+// "10x20x30", the format String produces. The separator may be upper or lower case,
+// and spaces around the dimensions are ignored. Anything else, such as a missing or
+// extra dimension or one which is not an integer, is an error. The result is not
+// normalized.
+func ParseSizeSpec(s string) (SizeSpec, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 3 {
+		return SizeSpec{}, errors.New("Size must have three dimensions")
+	}
+
+	var dims [3]int
+	for i, part := range parts {
+		x, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return SizeSpec{}, errors.New("Size dimension is not an integer")
+		}
+		dims[i] = x
+	}
+	return SizeSpec{dims[0], dims[1], dims[2]}, nil
+}
+
+// Writes a SizeSpec as its Length, Width and Height separated by an x, such as
+// "10x20x30". See ParseSizeSpec.
+func (spec SizeSpec) String() string {
+	return strconv.Itoa(spec.Length) + "x" + strconv.Itoa(spec.Width) + "x" + strconv.Itoa(spec.Height)
+}
+
+// reports whether a SizeSpec is a tighter fit than another: it has a smaller volume,
+// or the same volume and comes first when comparing Length, then Width, then Height.
+// This gives a total order over distinct normalized SizeSpecs.
+func (spec SizeSpec) tighterThan(other SizeSpec) bool {
+	if c := spec.compareVolume(other); c != 0 {
+		return c < 0
+	}
+	if spec.Length != other.Length {
+		return spec.Length < other.Length
+	}
+	if spec.Width != other.Width {
+		return spec.Width < other.Width
+	}
+	return spec.Height < other.Height
+}
+
+// returns the smallest gap left along any dimension when a package of the given size
+// is placed in a normalized SizeSpec, or a negative number if it does not fit. A
+// larger clearance leaves a less skewed space around the package.
+func (spec SizeSpec) clearance(package_size SizeSpec) int {
+	package_size = package_size.Normalize()
+	min := spec.Length - package_size.Length
+	if x := spec.Width - package_size.Width; x < min {
+		min = x
+	}
+	if x := spec.Height - package_size.Height; x < min {
+		min = x
+	}
+	return min
+}
+
 // An internal structure which represents a collection of lockers of a single size.
 // Contains lists of other locker sizes which are bigger/smaller, as well as
 // the combined total free capacity of all lockers which are equal or larger.
@@ -93,12 +328,47 @@ type LockerControlSpec struct {
 	SizeId LockerSize
 	Size SizeSpec
 
+	// the size as it was given when the size was added, before normalizing, taken as
+	// the orientation its lockers are installed in. If the same size is given in more
+	// than one orientation, the first is kept. Packages which may not be turned (see
+	// Package.NoRotate) are compared with this rather than Size. If zero, Size is used.
+	Oriented SizeSpec
+
 	BiggerThan []LockerSize
 	SmallerThan []LockerSize
 
 	Lockers []int
 
 	VirtualCapacity int
+
+	// the number of packages deposited into lockers of this size since the inventory
+	// was created, including stacked packages. See Inventory.TurnoverBySize.
+	Deposits int
+
+	// the number of available lockers of this size which operators want to keep in
+	// reserve. This is a target for reporting (see Headroom), not a limit on deposits.
+	MinReserve int
+
+	// if true, the size takes no new packages, and its available lockers do not count
+	// towards any size's VirtualCapacity. See Inventory.DeactivateSize.
+	Inactive bool
+
+	// added to VirtualCapacity when comparing sizes for selection, to steer deposits
+	// towards (or, if negative, away from) this size. See SelectionCapacity.
+	PriorityBias int
+
+	// the heaviest load a locker of this size is rated for, in the same units as
+	// Package.Weight. Packages heavier than a locker can bear are not placed in it,
+	// even if they fit, and for bulk lockers this limits all of a locker's packages
+	// together. Zero means no limit. See Inventory.SetMaxWeight.
+	MaxWeight int
+}
+
+// Returns the capacity a size is treated as having when choosing between sizes (see
+// LockerSize.Before): its VirtualCapacity plus its PriorityBias. This is not real
+// capacity; whether a package fits, and capacity reports, use VirtualCapacity alone.
+func (lcs LockerControlSpec) SelectionCapacity() int {
+	return lcs.VirtualCapacity + lcs.PriorityBias
 }
 
 // Returns true if a LockerControlSpec has no available lockers and false otherwise.
@@ -106,12 +376,82 @@ func (lcs LockerControlSpec) Full() bool {
 	return len(lcs.Lockers) == 0
 }
 
+// The kind of deposits a locker may be used for. Deposits travel in one of two
+// directions: outbound packages awaiting pickup, and inbound returns awaiting
+// collection. Lockers may be set aside for just one of them.
+type LockerPurpose int
+
+const (
+	// usable for deposits in either direction. This is the default.
+	PurposeBoth LockerPurpose = iota
+	// only usable for outbound deposits.
+	PurposeDeposit
+	// only usable for inbound returns.
+	PurposeReturn
+)
+
+// Checks if a locker with this purpose may hold a deposit travelling in the given
+// direction. A direction of PurposeBoth is accepted by every locker.
+func (p LockerPurpose) Accepts(direction LockerPurpose) bool {
+	return p == PurposeBoth || direction == PurposeBoth || p == direction
+}
+
 // A structure which represents a locker. Lockers come in discrete sizes.
 type Locker struct {
 	Id LockerID
 	SizeId LockerSize
 
 	Contents *Package
+
+	// packages stacked on top of Contents in a bulk locker (see
+	// Inventory.StackPackage), in the order they were added. The locker stays occupied
+	// until all of them have been fetched, along with Contents.
+	Stacked []*Package
+
+	// the total volume of the packages in the locker.
+	VolumeUsed int64
+
+	Purpose LockerPurpose
+
+	// when the locker was last cleaned, and when a package was last taken out of it.
+	// A locker which has been emptied since it was cleaned is dirty, and is skipped
+	// by deposits until it is cleaned again.
+	LastCleaned time.Time
+	LastEmptied time.Time
+
+	// when the package in the locker was deposited. A package moved between lockers
+	// keeps its original deposit time.
+	LastFilled time.Time
+
+	// the tightest size which had a usable locker when the package in the locker was
+	// deposited, whichever size was actually chosen. See OverprovisionedPackages.
+	TightestOffered LockerSize
+
+	// the door group the locker belongs to, if any. See Inventory.AddDoorGroup.
+	DoorGroup DoorGroupID
+
+	// the physical bank or area the locker is in, if known. See Inventory.RebalanceHint.
+	Zone string
+
+	// if true, the locker is out of service, such as for repair, and is not available
+	// for use. A package already in it can still be retrieved, but the locker is not
+	// used again until it returns to service. See Inventory.SetLockerStatus and
+	// Inventory.TakeSizeOutOfService.
+	OutOfService bool
+
+	// the sizes an adjustable locker can be set to. See Inventory.ReconfigureLocker.
+	Configurable []SizeSpec
+
+	ref LockerRef
+
+	// the package whose retrieval last left the locker empty, until something else
+	// is put in it. See Inventory.ReturnToSameLocker.
+	lastRetrieved *Package
+}
+
+// Checks whether a locker has been emptied since it was last cleaned.
+func (l *Locker) Dirty() bool {
+	return l.LastEmptied.After(l.LastCleaned)
 }
 
 // A structure which represents a package. Packages can come in any size.
@@ -119,9 +459,57 @@ type Package struct {
 	Id PackageID
 	Size SizeSpec
 
+	// padding a fragile package needs, added to each of its dimensions when choosing a
+	// locker for it. See RequiredSize.
+	Margin int
+
+	// whether the package should go in the tightest fitting locker available, using
+	// TightestFitStrategy instead of the default strategy, so that it never takes a
+	// larger locker just because larger lockers are less scarce.
+	PreferTight bool
+
+	// whether the package must be stored in the orientation its Size is given in, such
+	// as a fragile parcel which must be kept this side up. Such a package only goes in
+	// lockers which contain it with SizeSpec.ContainsOriented, in the orientation their
+	// size was given in (see LockerControlSpec.Oriented), so its Length, Width and
+	// Height are compared with the locker's Length, Width and Height as installed.
+	NoRotate bool
+
+	// how heavy the package is, in whatever units the lockers' weight limits use. See
+	// LockerControlSpec.MaxWeight.
+	Weight int
+
 	StoredIn *Locker
 }
 
+// Returns the normalized size of a package with its margin added to every dimension:
+// the size a locker must be able to hold for the package to go into it. Pass this to
+// GetMostSuitableLockerSize to account for the margin. A negative margin is ignored.
+func (pkg *Package) RequiredSize() SizeSpec {
+	size := pkg.Size.Normalize()
+	if pkg.Margin > 0 {
+		size.Length += pkg.Margin
+		size.Width += pkg.Margin
+		size.Height += pkg.Margin
+	}
+	return size
+}
+
+// returns the size of a package with its margin added, as RequiredSize, but in the
+// orientation its Size is given in, for packages which may not be turned.
+func (pkg *Package) requiredOriented() SizeSpec {
+	size := pkg.Size
+	size.Length = absDimension(size.Length)
+	size.Width = absDimension(size.Width)
+	size.Height = absDimension(size.Height)
+	if pkg.Margin > 0 {
+		size.Length += pkg.Margin
+		size.Width += pkg.Margin
+		size.Height += pkg.Margin
+	}
+	return size
+}
+
 // The inventory structure manages what lockers are available and what packages
 // they contain. This provides the primary functionality of this module.
 type Inventory struct {
@@ -132,6 +520,112 @@ type Inventory struct {
 
 	LockersById map[LockerID]int
 	LockersByPackageId map[PackageID]int
+
+	refs map[LockerRef]int
+	last_ref uint64
+
+	// generates IDs for lockers added after the inventory is created. If nil, IDs
+	// are random UUIDs. See WithIDGenerator.
+	idGen func() LockerID
+
+	// If not nil, notable events such as deposits, retrievals, errors and sizes running
+	// out of available lockers are reported here.
+	Logger Logger
+
+	// The source of the current time, for timestamping lockers. If nil, time.Now is used.
+	Clock func() time.Time
+
+	// Controls whether a size's VirtualCapacity includes the free lockers of every
+	// larger size which can contain it (true, the default for new inventories), or
+	// only its own free lockers (false). Transitive capacity is what lets the selection
+	// strategy account for how scarce room for a package really is, but each capacity
+	// adjustment costs O(n) for n sizes and building it costs O(n^2). Direct capacity
+	// costs O(1) per adjustment, at the price of preferring sizes by their own free
+	// lockers only. Use SetTransitiveCapacity to change it on a live inventory.
+	TransitiveCapacity bool
+
+	// How much room packages stacked into a bulk locker take up beyond their own
+	// volume, to allow for the gaps between them: the total volume of a locker's
+	// packages times the factor may not exceed its volume. See StackPackage. If zero,
+	// 1 is used, meaning no allowance.
+	PackingFactor float64
+
+	// If true, depositing a package which is already stored in this inventory moves it:
+	// it is taken out of its current locker and placed again, as if newly deposited,
+	// instead of failing with "Duplicate package ID". The package is only taken out
+	// once a locker for it is known to be available, so a failed move leaves it where
+	// it was. Packages stored in a different inventory are still rejected.
+	MoveIfStored bool
+
+	// The fraction of lockers, between 0 and 1, which may be in use at once. A deposit
+	// which would take a locker beyond it fails with ErrFacilityAtCapacity, keeping the
+	// rest free for emergencies (see DepositOverride). Lockers count as in use if they
+	// are not available, whether they hold a package, are held by a door group or are
+	// out of service. If zero, there is no limit.
+	MaxOccupancy float64
+
+	// Chooses which size of locker each package goes into, from the sizes which could
+	// hold it, for DepositPackage, GetMostSuitableLockerSize and the like. If nil,
+	// ScarcityStrategy is used. Deposits which are given a strategy of their own, such
+	// as DepositPackageChain, or packages with PreferTight set, do not use it.
+	Strategy PlacementStrategy
+
+	// If not nil, called whenever a capacity alarm set with SetAlarm is raised or
+	// cleared, with the size, whether the alarm is now raised, and the size's
+	// VirtualCapacity at the time.
+	OnCapacityAlarm func(size LockerSize, raised bool, capacity int)
+
+	// If not nil, called after each package is deposited, with the package and the ID
+	// of the locker it went into, such as to keep an audit trail. This includes packages
+	// stacked into bulk lockers, and packages moved by MoveIfStored, which are reported
+	// in their new locker. Packages moved by staff, such as with TransferPackage or
+	// Defragment, are not reported.
+	OnDeposit func(pkg *Package, locker LockerID)
+
+	// If not nil, called after each package is retrieved, with the package and the ID
+	// of the locker it was taken from. Packages moved by MoveIfStored are not reported
+	// as retrieved.
+	OnRetrieve func(pkg *Package, locker LockerID)
+
+	alarms map[LockerSize]*capacityAlarm
+	doorGroups map[DoorGroupID]*doorGroup
+
+	// idempotency keys of stored packages, and the reverse. See DepositPackageIdempotent.
+	packagesByKey map[string]PackageID
+	keysByPackage map[PackageID]string
+
+	// windowed reservations, and the last token handed out. See ReserveWindow.
+	reservations map[ReservationToken]*reservation
+	lastReservation ReservationToken
+
+	// how many deposits of each normalized size have been rejected. See RecordRejection.
+	rejections map[SizeSpec]int
+
+	// the locker each package was last retrieved from, while it can still be put back
+	// there with ReturnToSameLocker. See Locker.lastRetrieved.
+	retrievedFrom map[PackageID]LockerID
+}
+
+// A minimal logging interface, through which an inventory reports notable events.
+// *log.Logger satisfies it, and most logging libraries can be adapted to it with a
+// one line wrapper.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// reports an event to the inventory's logger, if it has one.
+func (inv *Inventory) logf(format string, v ...interface{}) {
+	if inv.Logger != nil {
+		inv.Logger.Printf(format, v...)
+	}
+}
+
+// returns the current time, according to the inventory's clock.
+func (inv *Inventory) now() time.Time {
+	if inv.Clock == nil {
+		return time.Now()
+	}
+	return inv.Clock()
 }
 
 // Fetches the locker control group of requested size, or nil if none exists.
@@ -140,189 +634,1256 @@ func (inv Inventory) ControlSpec(size_id LockerSize) *LockerControlSpec {
 	return inv.Control[size_id]
 }
 
+// returns the ids of every size class in the inventory, in ascending order.
+func (inv *Inventory) sizeIds() []LockerSize {
+	ids := make([]LockerSize, 0, len(inv.Control))
+	for size_id := range inv.Control {
+		ids = append(ids, size_id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Returns a stable reference to the locker with the given ID, and whether it exists.
+func (inv *Inventory) Ref(id LockerID) (LockerRef, bool) {
+	index, ok := inv.LockersById[id]
+	if !ok || inv.Lockers[index].ref == (LockerRef{}) {
+		return LockerRef{}, false
+	}
+	return inv.Lockers[index].ref, true
+}
+
+// Returns the current index in Lockers of the locker a reference refers to, and
+// whether the locker is still in this inventory.
+func (inv *Inventory) Index(ref LockerRef) (int, bool) {
+	index, ok := inv.refs[ref]
+	return index, ok
+}
+
+// Returns the locker a reference refers to, or nil and false if it is not in this inventory.
+func (inv *Inventory) Resolve(ref LockerRef) (*Locker, bool) {
+	index, ok := inv.refs[ref]
+	if !ok {
+		return nil, false
+	}
+	return &inv.Lockers[index], true
+}
+
 // Puts a package into a locker. Returns an error if there is a problem, such as
 // a locker which already has an item in it or a package which is already in a
 // locker, or nil if the operation completes normally.
 func (l *Locker) Put(pkg *Package) error {
 	if l.Contents != nil {
-		return errors.New("Locker is not empty")
+		return ErrLockerNotEmpty
 	} else if pkg.StoredIn != nil {
-		return errors.New("Package already in locker")
+		return ErrPackageAlreadyStored
 	}
 
 	l.Contents = pkg
+	l.VolumeUsed = pkg.Size.Normalize().Volume()
+	l.lastRetrieved = nil
 	pkg.StoredIn = l
 	return nil
 }
 
 // Fetches an item from a locker.  Returns nil and an error if the locker is
-// empty, or the package and nil otherwise.
+// empty, or the package and nil otherwise. If packages are stacked in the locker,
+// the one on top (the most recently stacked) is fetched, and the locker remains
+// occupied.
 func (l *Locker) Fetch() (*Package, error) {
 	if l.Contents == nil {
-		return nil, errors.New("Tried to fetch from empty locker")
+		return nil, ErrLockerEmpty
 	}
 
-	p := l.Contents
-	l.Contents = nil
+	var p *Package
+	if n := len(l.Stacked); n > 0 {
+		p = l.Stacked[n - 1]
+		l.Stacked[n - 1] = nil
+		l.Stacked = l.Stacked[:n - 1]
+	} else {
+		p = l.Contents
+		l.Contents = nil
+	}
+	l.VolumeUsed -= p.Size.Normalize().Volume()
 	p.StoredIn = nil
 	return p, nil
 }
 
+// rearranges a locker's packages so that the one with the given ID is on top, and
+// will be the next one fetched. Does nothing if it is not in the locker.
+func (l *Locker) toTop(id PackageID) {
+	n := len(l.Stacked)
+	if n == 0 { return }
+
+	if l.Contents.Id == id {
+		l.Contents, l.Stacked[n - 1] = l.Stacked[n - 1], l.Contents
+		return
+	}
+	for i, p := range l.Stacked {
+		if p.Id == id {
+			copy(l.Stacked[i:], l.Stacked[i + 1:])
+			l.Stacked[n - 1] = p
+			return
+		}
+	}
+}
+
+// reports whether a package is in the locker, either as its contents or stacked.
+func (l *Locker) holds(id PackageID) bool {
+	return l.find(id) != nil
+}
+
+// returns the combined weight of a locker's packages.
+func (l *Locker) weight() int {
+	if l.Contents == nil {
+		return 0
+	}
+	total := l.Contents.Weight
+	for _, p := range l.Stacked {
+		total += p.Weight
+	}
+	return total
+}
+
+// returns the package with the given ID from among a locker's packages, or nil.
+func (l *Locker) find(id PackageID) *Package {
+	if l.Contents == nil {
+		return nil
+	}
+	if l.Contents.Id == id {
+		return l.Contents
+	}
+	for _, p := range l.Stacked {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
+
 // Creates a new inventory.
 // Pass it a map, with desired locker dimensions as keys and locker counts as values.
 // Denormalized and even duplicate values are permitted and will be handled gracefully
 // (e.g. {{1,2,3}:5, {3,2,1}:5} is equivalent to {{3,2,1}:10}). Non-duplicate values do
 // carry the performance optimization of exactly sizing some data structures, so they
 // are preferred if possible.  Empty inventories are allowed, though they are not useful.
-// Adding lockers/locker sizes to an inventory on the fly is possible but unimplemented.
+// Lockers and locker sizes can be added to an inventory on the fly with AddLockers.
 // Removing lockers is not an easy prospect, but is possible by making some changes to
 // how available lockers are stored.
 func NewInventory(locker_counts_by_size map[SizeSpec]int) *Inventory {
-	total_locker_count := 0
-	for _, count := range locker_counts_by_size {
-		total_locker_count += count
+	return NewInventoryWithIDGen(locker_counts_by_size, newLockerId)
+}
+
+// generates a random ID for a new locker.
+func newLockerId() LockerID {
+	return LockerID(uuid.NewString())
+}
+
+// generates an ID for a locker being added to the inventory, with the inventory's
+// generator if it has one.
+func (inv *Inventory) nextLockerId() LockerID {
+	if inv.idGen != nil {
+		return inv.idGen()
 	}
+	return newLockerId()
+}
 
-	inv := &Inventory{
-		Control: make(map[LockerSize]*LockerControlSpec, len(locker_counts_by_size)),
-		Sizes: make(map[SizeSpec]LockerSize, len(locker_counts_by_size)),
+// an optional setting for NewInventoryWithOptions.
+type Option func(*inventoryOptions)
+
+// the settings NewInventoryWithOptions builds an inventory with.
+type inventoryOptions struct {
+	idGen func() LockerID
+}
+
+// Makes NewInventoryWithOptions take locker IDs from gen, as NewInventoryWithIDGen
+// does, instead of generating random UUIDs. See SequentialIDGen.
+func WithIDGenerator(gen func() LockerID) Option {
+	return func(o *inventoryOptions) {
+		o.idGen = gen
+	}
+}
 
-		LockersById: make(map[LockerID]int, total_locker_count),
-		LockersByPackageId: make(map[PackageID]int, total_locker_count),
+// Creates a new inventory as NewInventory does, with the given options applied in
+// order. With no options, the result is the same as from NewInventory.
+func NewInventoryWithOptions(locker_counts_by_size map[SizeSpec]int, opts ...Option) *Inventory {
+	o := inventoryOptions{idGen: newLockerId}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return NewInventoryWithIDGen(locker_counts_by_size, o.idGen)
+}
 
-		Lockers: make([]Locker, total_locker_count, total_locker_count),
+// Creates a new inventory exactly as NewInventory does, except that locker IDs come
+// from calling gen once per locker, rather than being random UUIDs. Sizes are created
+// from smallest to largest, and gen is called for each of a size's lockers in turn,
+// so a deterministic generator (such as SequentialIDGen) yields deterministic IDs. gen
+// must not return the same ID twice. It is kept for lockers added later, such as by
+// AddLockers.
+func NewInventoryWithIDGen(locker_counts_by_size map[SizeSpec]int, gen func() LockerID) *Inventory {
+	total_locker_count := 0
+	sizes := make([]SizeSpec, 0, len(locker_counts_by_size))
+	for size, count := range locker_counts_by_size {
+		total_locker_count += count
+		sizes = append(sizes, size)
 	}
+	orderSizes(sizes)
+
+	inv := newInventory(len(locker_counts_by_size), total_locker_count)
 
 	// normalize the sizes and allocate a LockerSize for each,
 	// and build the master locker list. Locker "pointers" are just
 	// indices into this array.
-	// O(n + L) for L lockers of n distinct sizes.
-	sizes := make([]SizeSpec, 0, len(locker_counts_by_size))
-	index := 0
-	for size, count := range locker_counts_by_size {
-		size = size.Normalize()
-		var size_id LockerSize
-		var ok bool
-		if size_id, ok = inv.Sizes[size]; !ok {
-			sizes = append(sizes, size)
-			size_id = LockerSize(len(inv.Sizes) + 1)
-			inv.Sizes[size] = size_id
-			inv.Control[size_id] = &LockerControlSpec{
-				SizeId: size_id,
-				Size: size,
-				Lockers: make([]int, 0, count),
-			}
+	// O(n + L) for L lockers of n distinct sizes, plus O(n log n) to order the sizes.
+	for _, size := range sizes {
+		count := locker_counts_by_size[size]
+		size_id := inv.addSize(size, count)
+		for i := 0; i < count; i++ {
+			inv.addLocker(size_id, gen())
 		}
+	}
 
-		for max, i := count + index, 0; index < max; index, i = index+1, i+1 {
-			id := LockerID(uuid.NewString())
-			inv.Lockers[index] = Locker{
-				SizeId: size_id,
-				Id: id,
-			}
-			inv.LockersById[id] = index
-			inv.Control[size_id].Lockers = append(inv.Control[size_id].Lockers, index)
-		}
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	inv.idGen = gen
+	return inv
+}
+
+// Creates a new inventory of total lockers, split between sizes according to the given
+// proportions, such as {small: 0.2, medium: 0.5, large: 0.3}. Proportions are relative
+// to their sum, so they need not add up to 1. Counts are rounded down, and the lockers
+// left over are given one at a time to the sizes which lost the most to rounding,
+// ties going to the smaller size, so the counts always add up to exactly total and
+// the same input always gives the same counts. Sizes are otherwise handled exactly as
+// in NewInventory. Returns nil if any proportion is negative, NaN or infinite, they are
+// all zero or so large that their sum is infinite, or total is negative.
+func NewInventoryByProportion(proportions map[SizeSpec]float64, total int) *Inventory {
+	if total < 0 {
+		return nil
 	}
 
-	// for each size, compute which other sizes fit entirely within it
-	// and store a bidirectional graph representing this relationship.
-	// runs in O(n^2) for n distinct sizes, which is not too bad given n's
-	// tendancy to be fairly small
-	for i, s1 := range sizes {
-		for _, s2 := range sizes[i+1:] {
-			if s1.Contains(s2) {
-				inv.Control[inv.Sizes[s1]].BiggerThan  = append(inv.Control[inv.Sizes[s1]].BiggerThan,  inv.Sizes[s2])
-				inv.Control[inv.Sizes[s2]].SmallerThan = append(inv.Control[inv.Sizes[s2]].SmallerThan, inv.Sizes[s1])
-			} else if s2.Contains(s1) {
-				inv.Control[inv.Sizes[s1]].SmallerThan = append(inv.Control[inv.Sizes[s1]].SmallerThan, inv.Sizes[s2])
-				inv.Control[inv.Sizes[s2]].BiggerThan  = append(inv.Control[inv.Sizes[s2]].BiggerThan,  inv.Sizes[s1])
-			}
+	merged := make(map[SizeSpec]float64, len(proportions))
+	sum := 0.0
+	for size, proportion := range proportions {
+		if proportion < 0 || math.IsNaN(proportion) || math.IsInf(proportion, 0) {
+			return nil
 		}
+		merged[size.Normalize()] += proportion
+		sum += proportion
+	}
+	if sum <= 0 || math.IsInf(sum, 0) {
+		return nil
 	}
 
-	// calculate the virtual capacity of each locker group
-	// this also runs in O(n^2) time. The problem is finding partial
-	// sums of nodes in a directed acyclig graph. Somewhat to my surprise,
-	// there is no known algorithm which does this in better than O(n^2).
-	// again though, n is likely to be fairly small.
-	for _, ctrl := range inv.Control {
-		for _, other_id := range ctrl.SmallerThan {
-			ctrl.VirtualCapacity += len(inv.Control[other_id].Lockers)
-		}
-		ctrl.VirtualCapacity += len(ctrl.Lockers)
+	type share struct {
+		size SizeSpec
+		count int
+		remainder float64
 	}
+	shares := make([]share, 0, len(merged))
+	assigned := 0
+	for size, proportion := range merged {
+		exact := proportion / sum * float64(total)
+		count := int(exact)
+		shares = append(shares, share{size, count, exact - float64(count)})
+		assigned += count
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].remainder != shares[j].remainder {
+			return shares[i].remainder > shares[j].remainder
+		}
+		return shares[i].size.tighterThan(shares[j].size)
+	})
 
-	return inv
+	counts := make(map[SizeSpec]int, len(shares))
+	for i := range shares {
+		if assigned < total {
+			shares[i].count += 1
+			assigned += 1
+		}
+		counts[shares[i].size] = shares[i].count
+	}
+	return NewInventory(counts)
 }
 
-// Fetches the most appropriate size of locker to store a given size of package in.
-// This is defined to be the size class of locker with the largest available capacity
-// in terms of both direct storage, and also larger available lockers.
-// If multiple such options exist, the smallest (volume-wise) locker is chosen.
-// note: this is usually, but not always, the locker with the best space efficiency.
-// An example where it is not:
-// imagine an inventory with 3 sizes of locker:
-// small-1 (4x1x1, 1   available)
-// small-2 (2x2x2, 100 available)
-// medium  (4x4x4, 1   available)
-// small-1 cannot fit into small-2, and neither can small-2 fit into small-1,
-// but both can fit inside medium.
-// if a 2x1x1 package comes in, it will be placed into small-2 even though it would be more
-// space efficient to place it into small-1, because of the relative scarcity of lockers
-// large enough to hold a package which could fit into small-1 but not small-2.
-// I assert that a space-optimizing algorithm would lead you astray if you applied it here.
-func (inv *Inventory) GetMostSuitableLockerSize(package_size SizeSpec) (LockerSize, error) {
-	// build a list of all locker sizes which a. have empty lockers and
-	// b. have enough space for the given dimensions
-	candidate_sizes := make([]LockerSize, 0, len(inv.Sizes))
-	for size, size_id := range inv.Sizes {
-		if !size.Contains(package_size) { continue }
-		if inv.Control[size_id].Full() { continue }
+// Creates a new inventory with caller-chosen locker IDs, such as the labels printed
+// on the physical doors. Pass it a map, with desired locker dimensions as keys and
+// the IDs of the lockers of that size as values; the number of IDs is the locker count.
+// Sizes are handled exactly as in NewInventory, and created from smallest to largest
+// as in NewInventoryWithIDGen. IDs are used verbatim, and an error is returned if the
+// same ID appears more than once anywhere in the map.
+func NewInventoryWithIDs(locker_ids_by_size map[SizeSpec][]LockerID) (*Inventory, error) {
+	total_locker_count := 0
+	for _, ids := range locker_ids_by_size {
+		total_locker_count += len(ids)
+	}
 
-		candidate_sizes = append(candidate_sizes, size_id)
+	seen := make(map[LockerID]bool, total_locker_count)
+	for _, ids := range locker_ids_by_size {
+		for _, id := range ids {
+			if seen[id] {
+				return nil, errors.New("Duplicate locker ID")
+			}
+			seen[id] = true
+		}
 	}
 
-	if len(candidate_sizes) == 0 {
-		return LockerSize(0), errors.New("No available lockers which can fit package")
+	sizes := make([]SizeSpec, 0, len(locker_ids_by_size))
+	for size := range locker_ids_by_size {
+		sizes = append(sizes, size)
 	}
+	orderSizes(sizes)
 
-	// choose the most eligible candidate
-	chosen_id := candidate_sizes[0]
-	for _, id := range candidate_sizes[1:] {
-		if id.Before(chosen_id, inv) {
-			chosen_id = id
+	inv := newInventory(len(locker_ids_by_size), total_locker_count)
+	for _, size := range sizes {
+		ids := locker_ids_by_size[size]
+		size_id := inv.addSize(size, len(ids))
+		for _, id := range ids {
+			inv.addLocker(size_id, id)
 		}
 	}
 
-	return chosen_id, nil
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return inv, nil
 }
 
-// places a package into the inventory. O(n) for n different size lockers.
-// returns a locker ID and nil, or "" and an error if one occurs.
-func (inv *Inventory) DepositPackage(pkg *Package) (LockerID, error) {
-	if _, ok := inv.LockersByPackageId[pkg.Id]; ok {
-		return "", errors.New("Duplicate package ID")
+// sorts sizes from smallest to largest, by their normalized size and then as given,
+// so that inventories built from the same map always number their sizes the same way.
+func orderSizes(sizes []SizeSpec) {
+	sort.Slice(sizes, func(i, j int) bool {
+		a, b := sizes[i].Normalize(), sizes[j].Normalize()
+		if a != b {
+			return a.tighterThan(b)
+		}
+		return sizes[i].tighterThan(sizes[j])
+	})
+}
+
+// allocates an empty inventory, with its maps and slices sized to hold the given
+// number of distinct sizes and lockers.
+func newInventory(size_count, locker_count int) *Inventory {
+	return &Inventory{
+		Control: make(map[LockerSize]*LockerControlSpec, size_count),
+		Sizes: make(map[SizeSpec]LockerSize, size_count),
+
+		LockersById: make(map[LockerID]int, locker_count),
+		LockersByPackageId: make(map[PackageID]int, locker_count),
+		refs: make(map[LockerRef]int, locker_count),
+
+		Lockers: make([]Locker, 0, locker_count),
+
+		TransitiveCapacity: true,
 	}
+}
 
-	chosen_id, err := inv.GetMostSuitableLockerSize(pkg.Size.Normalize())
-	if err != nil {
-		return "", err
+// normalizes a size and returns its LockerSize, allocating a new size class for it
+// if it is not already known. count is a hint for how many lockers it will hold.
+// New size classes are not linked into the containment graph; see linkSizes.
+func (inv *Inventory) addSize(size SizeSpec, count int) LockerSize {
+	oriented := SizeSpec{absDimension(size.Length), absDimension(size.Width), absDimension(size.Height)}
+	size = size.Normalize()
+	if size_id, ok := inv.Sizes[size]; ok {
+		return size_id
 	}
 
-	ctrl := inv.Control[chosen_id]
-	locker_index := ctrl.Lockers[len(ctrl.Lockers) - 1]
-	err = inv.Lockers[locker_index].Put(pkg)
-	if err != nil {
-		return "", err
+	size_id := LockerSize(len(inv.Sizes) + 1)
+	inv.Sizes[size] = size_id
+	inv.Control[size_id] = &LockerControlSpec{
+		SizeId: size_id,
+		Size: size,
+		Oriented: oriented,
+		Lockers: make([]int, 0, count),
 	}
+	return size_id
+}
 
-	inv.AllocateLocker(chosen_id)
-	inv.LockersByPackageId[pkg.Id] = locker_index
-	return inv.Lockers[locker_index].Id, nil
+// appends a new, empty locker of the given size to the master locker list and
+// makes it available. Returns the locker's index. Virtual capacity is not updated.
+func (inv *Inventory) addLocker(size_id LockerSize, id LockerID) int {
+	if inv.refs == nil {
+		inv.refs = make(map[LockerRef]int)
+	}
+	inv.last_ref += 1
+	ref := LockerRef{inv.last_ref}
+
+	index := len(inv.Lockers)
+	inv.Lockers = append(inv.Lockers, Locker{
+		SizeId: size_id,
+		Id: id,
+		ref: ref,
+	})
+	inv.LockersById[id] = index
+	inv.refs[ref] = index
+	inv.Control[size_id].Lockers = append(inv.Control[size_id].Lockers, index)
+	return index
 }
 
-// removes a package from the inventory (via package ID lookup).
+// removes an empty locker from the inventory, along with its place in its size's
+// available lockers, if it has one. The last locker is moved into
+// its place, so every index referring to that one is updated to match. Capacity is
+// not recomputed; see ResetVirtualCapacityFromFreeLists.
+func (inv *Inventory) removeLocker(index int) {
+	l := &inv.Lockers[index]
+	ctrl := inv.Control[l.SizeId]
+	if position := inv.availablePosition(index); position >= 0 {
+		ctrl.Lockers = append(ctrl.Lockers[:position], ctrl.Lockers[position + 1:]...)
+	}
+	delete(inv.LockersById, l.Id)
+	delete(inv.refs, l.ref)
+	if l.lastRetrieved != nil && inv.retrievedFrom[l.lastRetrieved.Id] == l.Id {
+		delete(inv.retrievedFrom, l.lastRetrieved.Id)
+	}
+
+	last := len(inv.Lockers) - 1
+	if index != last {
+		moved := inv.Lockers[last]
+		inv.Lockers[index] = moved
+		inv.LockersById[moved.Id] = index
+		if moved.ref != (LockerRef{}) {
+			inv.refs[moved.ref] = index
+		}
+		if moved.Contents != nil {
+			inv.LockersByPackageId[moved.Contents.Id] = index
+		}
+		for _, p := range moved.Stacked {
+			inv.LockersByPackageId[p.Id] = index
+		}
+		if position := inv.availablePosition(last); position >= 0 {
+			inv.Control[moved.SizeId].Lockers[position] = index
+		}
+		if g, ok := inv.doorGroups[moved.DoorGroup]; ok {
+			for i, x := range g.members {
+				if x == last {
+					g.members[i] = index
+				}
+			}
+		}
+	}
+
+	inv.Lockers[last] = Locker{}
+	inv.Lockers = inv.Lockers[:last]
+	inv.RepairBackPointers()
+}
+
+// DeepCopy returns a copy of the inventory which shares no mutable state with it, so
+// that hypothetical deposits and retrievals can be tried against the copy and thrown
+// away. Stored packages are copied too, and the copies point at the copied lockers.
+// The copy keeps the Clock, but not the Logger, OnCapacityAlarm, OnDeposit or
+// OnRetrieve, so changes to it are not reported. They can be set on the copy if
+// its changes should be reported too.
+func (inv *Inventory) DeepCopy() *Inventory {
+	return inv.clone()
+}
+
+// makes a deep copy of an inventory, sharing no mutable state with the original, for
+// hypothetical changes which should not be logged or reported (see DeepCopy).
+// Stored packages are copied too, and the copies point at the copied lockers.
+func (inv *Inventory) clone() *Inventory {
+	c := *inv
+
+	c.Lockers = make([]Locker, len(inv.Lockers))
+	copy(c.Lockers, inv.Lockers)
+	for i := range c.Lockers {
+		if c.Lockers[i].Contents != nil {
+			pkg := *c.Lockers[i].Contents
+			c.Lockers[i].Contents = &pkg
+		}
+		if c.Lockers[i].lastRetrieved != nil {
+			pkg := *c.Lockers[i].lastRetrieved
+			c.Lockers[i].lastRetrieved = &pkg
+		}
+		if c.Lockers[i].Stacked != nil {
+			stacked := make([]*Package, len(c.Lockers[i].Stacked))
+			for j, p := range c.Lockers[i].Stacked {
+				pkg := *p
+				stacked[j] = &pkg
+			}
+			c.Lockers[i].Stacked = stacked
+		}
+		if c.Lockers[i].Configurable != nil {
+			c.Lockers[i].Configurable = append([]SizeSpec(nil), c.Lockers[i].Configurable...)
+		}
+	}
+	c.RepairBackPointers()
+
+	c.Control = make(map[LockerSize]*LockerControlSpec, len(inv.Control))
+	for size_id, ctrl := range inv.Control {
+		x := *ctrl
+		x.BiggerThan = append([]LockerSize(nil), ctrl.BiggerThan...)
+		x.SmallerThan = append([]LockerSize(nil), ctrl.SmallerThan...)
+		x.Lockers = append([]int(nil), ctrl.Lockers...)
+		c.Control[size_id] = &x
+	}
+
+	c.Sizes = make(map[SizeSpec]LockerSize, len(inv.Sizes))
+	for k, v := range inv.Sizes {
+		c.Sizes[k] = v
+	}
+	c.LockersById = make(map[LockerID]int, len(inv.LockersById))
+	for k, v := range inv.LockersById {
+		c.LockersById[k] = v
+	}
+	c.LockersByPackageId = make(map[PackageID]int, len(inv.LockersByPackageId))
+	for k, v := range inv.LockersByPackageId {
+		c.LockersByPackageId[k] = v
+	}
+	c.refs = make(map[LockerRef]int, len(inv.refs))
+	for k, v := range inv.refs {
+		c.refs[k] = v
+	}
+
+	// alarms are copied, but hypothetical changes to the copy are not reported.
+	c.alarms = make(map[LockerSize]*capacityAlarm, len(inv.alarms))
+	for k, v := range inv.alarms {
+		x := *v
+		c.alarms[k] = &x
+	}
+	c.Logger, c.OnCapacityAlarm = nil, nil
+	c.OnDeposit, c.OnRetrieve = nil, nil
+
+	c.packagesByKey = make(map[string]PackageID, len(inv.packagesByKey))
+	for k, v := range inv.packagesByKey {
+		c.packagesByKey[k] = v
+	}
+	c.keysByPackage = make(map[PackageID]string, len(inv.keysByPackage))
+	for k, v := range inv.keysByPackage {
+		c.keysByPackage[k] = v
+	}
+	c.rejections = make(map[SizeSpec]int, len(inv.rejections))
+	for k, v := range inv.rejections {
+		c.rejections[k] = v
+	}
+	c.retrievedFrom = make(map[PackageID]LockerID, len(inv.retrievedFrom))
+	for k, v := range inv.retrievedFrom {
+		c.retrievedFrom[k] = v
+	}
+	c.reservations = make(map[ReservationToken]*reservation, len(inv.reservations))
+	for k, v := range inv.reservations {
+		x := *v
+		c.reservations[k] = &x
+	}
+
+	c.doorGroups = make(map[DoorGroupID]*doorGroup, len(inv.doorGroups))
+	for k, v := range inv.doorGroups {
+		x := *v
+		x.members = append([]int(nil), v.members...)
+		c.doorGroups[k] = &x
+	}
+
+	return &c
+}
+
+// Points every stored package back at the locker which holds it in this inventory.
+// Copying an Inventory by value shares its packages, whose StoredIn pointers still
+// point into the original's Lockers, and the same goes for a Lockers slice which has
+// been reallocated or rebuilt, such as after deserializing. Reallocation is handled
+// internally, but a caller who copies or rebuilds an inventory by hand should call
+// this afterwards. Note that a shallow copy still shares its packages with the original,
+// so repairing one breaks the other. A full copy needs its packages copied as well.
+func (inv *Inventory) RepairBackPointers() {
+	for i := range inv.Lockers {
+		if inv.Lockers[i].Contents != nil {
+			inv.Lockers[i].Contents.StoredIn = &inv.Lockers[i]
+		}
+		for _, p := range inv.Lockers[i].Stacked {
+			p.StoredIn = &inv.Lockers[i]
+		}
+	}
+}
+
+// for each size, compute which other sizes fit entirely within it
+// and store a bidirectional graph representing this relationship,
+// replacing any existing one.
+// runs in O(n^2) for n distinct sizes, which is not too bad given n's
+// tendancy to be fairly small
+func (inv *Inventory) linkSizes() {
+	for _, ctrl := range inv.Control {
+		ctrl.BiggerThan, ctrl.SmallerThan = nil, nil
+	}
+
+	sizes := make([]SizeSpec, 0, len(inv.Sizes))
+	for size := range inv.Sizes {
+		sizes = append(sizes, size)
+	}
+
+	for i, s1 := range sizes {
+		for _, s2 := range sizes[i+1:] {
+			if s1.Contains(s2) {
+				inv.Control[inv.Sizes[s1]].BiggerThan  = append(inv.Control[inv.Sizes[s1]].BiggerThan,  inv.Sizes[s2])
+				inv.Control[inv.Sizes[s2]].SmallerThan = append(inv.Control[inv.Sizes[s2]].SmallerThan, inv.Sizes[s1])
+			} else if s2.Contains(s1) {
+				inv.Control[inv.Sizes[s1]].SmallerThan = append(inv.Control[inv.Sizes[s1]].SmallerThan, inv.Sizes[s2])
+				inv.Control[inv.Sizes[s2]].BiggerThan  = append(inv.Control[inv.Sizes[s2]].BiggerThan,  inv.Sizes[s1])
+			}
+		}
+	}
+}
+
+// Recalculates the virtual capacity of each locker group from scratch, using only the
+// current lists of available lockers and the containment graph. Nothing else, such as
+// which lockers are occupied, is examined or changed. This is exactly the computation
+// NewInventory performs, and is useful after editing Control directly.
+// this also runs in O(n^2) time. The problem is finding partial
+// sums of nodes in a directed acyclig graph. Somewhat to my surprise,
+// there is no known algorithm which does this in better than O(n^2).
+// again though, n is likely to be fairly small.
+// if capacity is not transitive, this is just O(n).
+func (inv *Inventory) ResetVirtualCapacityFromFreeLists() {
+	free := func(ctrl *LockerControlSpec) int {
+		if ctrl.Inactive {
+			return 0
+		}
+		return len(ctrl.Lockers)
+	}
+
+	for _, ctrl := range inv.Control {
+		ctrl.VirtualCapacity = free(ctrl)
+		if !inv.TransitiveCapacity { continue }
+		for _, other_id := range ctrl.SmallerThan {
+			ctrl.VirtualCapacity += free(inv.Control[other_id])
+		}
+	}
+	inv.checkAlarms()
+}
+
+// Switches the inventory between transitive and direct virtual capacity (see
+// Inventory.TransitiveCapacity), and recomputes the capacity of every size to match.
+func (inv *Inventory) SetTransitiveCapacity(transitive bool) {
+	inv.TransitiveCapacity = transitive
+	inv.ResetVirtualCapacityFromFreeLists()
+}
+
+// Fetches the most appropriate size of locker to store a given size of package in.
+// This is defined to be the size class of locker with the largest available capacity
+// in terms of both direct storage, and also larger available lockers.
+// If multiple such options exist, the smallest (volume-wise) locker is chosen. Sizes
+// which still tie are chosen between by the clearance they leave around the package,
+// most first, and then by comparing dimensions, so the same package and inventory
+// always give the same size.
+// note: this is usually, but not always, the locker with the best space efficiency.
+// An example where it is not:
+// imagine an inventory with 3 sizes of locker:
+// small-1 (4x1x1, 1   available)
+// small-2 (2x2x2, 100 available)
+// medium  (4x4x4, 1   available)
+// small-1 cannot fit into small-2, and neither can small-2 fit into small-1,
+// but both can fit inside medium.
+// if a 2x1x1 package comes in, it will be placed into small-2 even though it would be more
+// space efficient to place it into small-1, because of the relative scarcity of lockers
+// large enough to hold a package which could fit into small-1 but not small-2.
+// I assert that a space-optimizing algorithm would lead you astray if you applied it here.
+func (inv *Inventory) GetMostSuitableLockerSize(package_size SizeSpec) (LockerSize, error) {
+	return inv.GetMostSuitableLockerSizeFor(package_size, PurposeDeposit)
+}
+
+// Fetches the most appropriate size of locker to store a given size of package in,
+// as GetMostSuitableLockerSize, for a package which may not be turned to fit. Only
+// sizes whose lockers, as installed, contain package_size as given, with
+// SizeSpec.ContainsOriented, are considered. See Package.NoRotate.
+func (inv *Inventory) GetMostSuitableLockerSizeOriented(package_size SizeSpec) (LockerSize, error) {
+	return inv.selectSize(inv.packagePlacement(&Package{Size: package_size, NoRotate: true}, PurposeDeposit))
+}
+
+// Fetches the most appropriate size of locker to store a given size of package in,
+// as GetMostSuitableLockerSize, considering only lockers whose purpose accepts a
+// deposit travelling in the given direction. Virtual capacity does not distinguish
+// between purposes, so the relative priority of sizes is approximate when some
+// lockers are set aside for one direction.
+func (inv *Inventory) GetMostSuitableLockerSizeFor(package_size SizeSpec, direction LockerPurpose) (LockerSize, error) {
+	return inv.selectSize(inv.placementFor(package_size, direction))
+}
+
+// the constraints on where a single package may be placed.
+type placement struct {
+	// the normalized size of the package, or if oriented, its size as given.
+	size SizeSpec
+
+	// if true, the package may not be turned to fit, so sizes must contain it with
+	// SizeSpec.ContainsOriented. See Package.NoRotate.
+	oriented bool
+
+	// the weight of the package, which sizes must be rated for. See Package.Weight.
+	weight int
+
+	// the direction the package is travelling, which the locker's purpose must accept.
+	direction LockerPurpose
+
+	// if true, lockers which have not been cleaned since they were last emptied may be used.
+	allow_dirty bool
+
+	// chooses between the candidate sizes. If nil, the inventory's Strategy is used.
+	strategy SelectionStrategy
+
+	// if true, the inventory's MaxOccupancy is ignored.
+	override_cap bool
+
+	// if not empty, only lockers in this zone may be used. See Locker.Zone.
+	zone string
+
+	// lockers which may not be used, such as ones which are temporarily blocked.
+	exclude map[LockerID]bool
+
+	// a reservation whose held lockers may be used. See Inventory.DepositReserved.
+	reservation ReservationToken
+}
+
+// builds the placement for a package of the given size travelling in the given direction.
+func (inv *Inventory) placementFor(package_size SizeSpec, direction LockerPurpose) placement {
+	return placement{
+		size: package_size,
+		direction: direction,
+	}
+}
+
+// returns the constraints for placing a particular package in the given direction,
+// including those the package itself asks for, such as Package.NoRotate.
+func (inv *Inventory) packagePlacement(pkg *Package, direction LockerPurpose) placement {
+	p := inv.placementFor(pkg.RequiredSize(), direction)
+	if pkg.NoRotate {
+		p.size = pkg.requiredOriented()
+		p.oriented = true
+	}
+	p.weight = pkg.Weight
+	return p
+}
+
+// checks if a size of locker is large enough for a placement, as installed if the
+// package may not be turned.
+func (p placement) fits(ctrl *LockerControlSpec) bool {
+	if p.oriented {
+		return ctrl.installed().ContainsOriented(p.size)
+	}
+	return ctrl.Size.Contains(p.size)
+}
+
+// checks if a size of locker can take a placement: it must be large enough, and rated
+// for the package's weight.
+func (p placement) accepts(ctrl *LockerControlSpec) bool {
+	return p.fits(ctrl) && ctrl.carries(p.weight)
+}
+
+// returns the dimensions of this size's lockers as installed: Oriented, if it is known,
+// or else Size.
+func (lcs LockerControlSpec) installed() SizeSpec {
+	if lcs.Oriented != (SizeSpec{}) {
+		return lcs.Oriented
+	}
+	return lcs.Size
+}
+
+// checks if a locker of this size is rated for the given weight. See MaxWeight.
+func (lcs LockerControlSpec) carries(weight int) bool {
+	return lcs.MaxWeight == 0 || weight <= lcs.MaxWeight
+}
+
+// checks if an available locker (by index) may be used for a placement. Sizes with no
+// usable lockers are treated as if they were full.
+func (inv *Inventory) usable(p placement, locker_index int) bool {
+	l := &inv.Lockers[locker_index]
+	if !l.Purpose.Accepts(p.direction) {
+		return false
+	}
+	if !p.allow_dirty && l.Dirty() {
+		return false
+	}
+	if p.zone != "" && l.Zone != p.zone {
+		return false
+	}
+	if p.exclude[l.Id] {
+		return false
+	}
+	return true
+}
+
+// returns the position within ctrl.Lockers of the available locker which would be
+// allocated next for a placement, or -1 if there isn't one. Lockers are allocated
+// from the end of the list, so usually this is just the last position. Lockers held
+// by active reservations (see ReserveWindow) are passed over.
+func (inv *Inventory) next(ctrl *LockerControlSpec, p placement) int {
+	if ctrl.Inactive {
+		return -1
+	}
+	held := inv.heldBack(ctrl, p)
+	for i := len(ctrl.Lockers) - 1; i >= 0; i-- {
+		if !inv.usable(p, ctrl.Lockers[i]) { continue }
+		if held > 0 {
+			held -= 1
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// lists the sizes of locker which could take a placement, in the order they are
+// offered to a selection strategy.
+func (inv *Inventory) candidates(p placement) []LockerSize {
+	// build a list of all locker sizes which a. have usable empty lockers and
+	// b. have enough space for the given dimensions and are rated for its weight
+	candidate_sizes := make([]LockerSize, 0, len(inv.Sizes))
+	for _, size_id := range inv.Sizes {
+		if !p.accepts(inv.Control[size_id]) { continue }
+		if inv.next(inv.Control[size_id], p) < 0 { continue }
+
+		candidate_sizes = append(candidate_sizes, size_id)
+	}
+
+	// order the candidates by how much clearance they would leave around the package,
+	// most first, so that strategies which stop at the first of several equally good
+	// candidates prefer the least skewed fit. Differently shaped sizes with the same
+	// volume and capacity are otherwise indistinguishable. Remaining ties are broken
+	// by the tighter size, so the order never depends on map iteration.
+	sort.Slice(candidate_sizes, func(i, j int) bool {
+		a, b := inv.Control[candidate_sizes[i]].Size, inv.Control[candidate_sizes[j]].Size
+		if ca, cb := a.clearance(p.size), b.clearance(p.size); ca != cb {
+			return ca > cb
+		}
+		return a.tighterThan(b)
+	})
+	return candidate_sizes
+}
+
+// Lists every size of locker which could take a package of the given size right now,
+// best first, as ScarcityStrategy ranks them: by Before, with ties kept in the
+// deterministic order GetMostSuitableLockerSize breaks them in, so the first size is
+// the one it would choose with the default Strategy. Returns ErrNoLockerFits if there are none.
+func (inv *Inventory) RankSuitableLockerSizes(size SizeSpec) ([]LockerSize, error) {
+	ranked := inv.candidates(inv.placementFor(size.Normalize(), PurposeDeposit))
+	if len(ranked) == 0 {
+		return nil, ErrNoLockerFits
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Before(ranked[j], inv)
+	})
+	return ranked, nil
+}
+
+// chooses the most suitable size of locker for a placement. See GetMostSuitableLockerSize.
+func (inv *Inventory) selectSize(p placement) (LockerSize, error) {
+	candidate_sizes := inv.candidates(p)
+	if len(candidate_sizes) == 0 {
+		return LockerSize(0), ErrNoLockerFits
+	}
+
+	// choose the most eligible candidate
+	var strategy PlacementStrategy = p.strategy
+	if p.strategy == nil {
+		strategy = inv.Strategy
+	}
+	if strategy == nil {
+		strategy = SelectionStrategy(ScarcityStrategy)
+	}
+	chosen_id := strategy.Choose(candidate_sizes, inv)
+	for _, id := range candidate_sizes {
+		if id == chosen_id {
+			return chosen_id, nil
+		}
+	}
+
+	return LockerSize(0), errors.New("Selection strategy did not choose a candidate")
+}
+
+// Classifies a package by the tightest size class in the catalog which can contain it,
+// regardless of whether any lockers of that size are currently available. This is
+// intended for pre-sorting packages before they reach the lockers, and only returns
+// an error if no size class is large enough for the package. The tightest size is
+// the one with the smallest volume, with ties broken by comparing dimensions.
+func (inv *Inventory) BinPackage(size SizeSpec) (LockerSize, error) {
+	size = size.Normalize()
+
+	var best_id LockerSize
+	var best SizeSpec
+	for s, size_id := range inv.Sizes {
+		if !s.Contains(size) { continue }
+		if best_id == LockerSize(0) || s.tighterThan(best) {
+			best_id, best = size_id, s
+		}
+	}
+
+	if best_id == LockerSize(0) {
+		return LockerSize(0), errors.New("No locker size large enough to fit package")
+	}
+	return best_id, nil
+}
+
+// Decides whether a package of the given size can be accepted right now. If it can,
+// and the tightest size class for it (see BinPackage) has no available lockers, also
+// returns the size of locker it would spill into instead; otherwise the returned size
+// is the zero SizeSpec. A package is not accepted while the inventory is at its
+// MaxOccupancy, as with DryRunDeposit.
+func (inv *Inventory) AdmissionDecision(size SizeSpec) (bool, SizeSpec) {
+	size = size.Normalize()
+	chosen_id, err := inv.DryRunDeposit(size)
+	if err != nil {
+		return false, SizeSpec{}
+	}
+
+	// if anything can hold the package, there is a tightest fit for it
+	tightest_id, _ := inv.BinPackage(size)
+	if inv.Control[tightest_id].Full() {
+		return true, inv.Control[chosen_id].Size
+	}
+	return true, SizeSpec{}
+}
+
+// Checks whether a package of the given size could be placed right now, as
+// GetMostSuitableLockerSize. Nothing in the inventory is changed.
+func (inv *Inventory) CanFit(size SizeSpec) bool {
+	_, err := inv.GetMostSuitableLockerSize(size.Normalize())
+	return err == nil
+}
+
+// Returns the size of locker DepositPackage would choose for a package of the given
+// size, or the error it would fail with, without placing anything. This includes
+// the inventory's MaxOccupancy, but not checks on the package itself, such as its ID.
+func (inv *Inventory) DryRunDeposit(size SizeSpec) (LockerSize, error) {
+	if inv.atMaxOccupancy() {
+		return LockerSize(0), ErrFacilityAtCapacity
+	}
+	return inv.GetMostSuitableLockerSize(size.Normalize())
+}
+
+// places a package into the inventory. O(n) for n different size lockers.
+// returns a locker ID and nil, or "" and an error if one occurs.
+// The package is treated as an outbound deposit, and will not be placed into lockers
+// set aside for returns.
+func (inv *Inventory) DepositPackage(pkg *Package) (LockerID, error) {
+	return inv.DepositOutbound(pkg)
+}
+
+// places a package into the inventory as DepositPackage, but safe to retry. Each deposit
+// carries a key chosen by the caller, such as a request ID. If a package deposited
+// with the same key is still stored, nothing is placed, and the locker it is in now
+// is returned with true, whatever package is passed in. Otherwise the package is
+// deposited as usual, and false is returned with the result. A key is forgotten
+// once its package is retrieved.
+func (inv *Inventory) DepositPackageIdempotent(key string, pkg *Package) (LockerID, bool, error) {
+	if pkg_id, ok := inv.packagesByKey[key]; ok {
+		return inv.Lockers[inv.LockersByPackageId[pkg_id]].Id, true, nil
+	}
+
+	locker_id, err := inv.DepositPackage(pkg)
+	if err != nil {
+		return "", false, err
+	}
+
+	if inv.packagesByKey == nil {
+		inv.packagesByKey = make(map[string]PackageID)
+		inv.keysByPackage = make(map[PackageID]string)
+	}
+	inv.packagesByKey[key] = pkg.Id
+	inv.keysByPackage[pkg.Id] = key
+	return locker_id, false, nil
+}
+
+// places each of a batch of packages into the inventory as DepositPackage, in order.
+// Returns the locker ID and error for each package, at the same index as the package.
+// A package which cannot be placed, such as one whose ID was already used earlier in
+// the batch, does not stop the others. If ctx is done before every package is placed,
+// the rest are not attempted and get ctx.Err() as their error; packages already placed
+// stay in the inventory.
+func (inv *Inventory) DepositBatch(ctx context.Context, pkgs []*Package) ([]LockerID, []error) {
+	ids := make([]LockerID, len(pkgs))
+	errs := make([]error, len(pkgs))
+	for i, pkg := range pkgs {
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(pkgs); j++ {
+				errs[j] = err
+			}
+			inv.logf("lockers: batch deposit stopped with %d of %d packages left: %s", len(pkgs) - i, len(pkgs), err.Error())
+			break
+		}
+		ids[i], errs[i] = inv.DepositPackage(pkg)
+	}
+	return ids, errs
+}
+
+// places an outbound package into the inventory, using only lockers whose purpose
+// is PurposeDeposit or PurposeBoth. See DepositPackage.
+func (inv *Inventory) DepositOutbound(pkg *Package) (LockerID, error) {
+	return inv.deposit(pkg, inv.packagePlacement(pkg, PurposeDeposit))
+}
+
+// places a returned package into the inventory, using only lockers whose purpose
+// is PurposeReturn or PurposeBoth. See DepositPackage.
+func (inv *Inventory) DepositReturn(pkg *Package) (LockerID, error) {
+	return inv.deposit(pkg, inv.packagePlacement(pkg, PurposeReturn))
+}
+
+// places an outbound package into the inventory, as DepositPackage, except that lockers
+// which have not been cleaned since they were last emptied may be used.
+func (inv *Inventory) DepositPackageAllowDirty(pkg *Package) (LockerID, error) {
+	p := inv.packagePlacement(pkg, PurposeDeposit)
+	p.allow_dirty = true
+	return inv.deposit(pkg, p)
+}
+
+// places an outbound package into the inventory, as DepositPackage, except that the
+// lockers in exclude are not used, such as when their doors are blocked for a while.
+// Nothing about the excluded lockers changes. If every available locker of a size is
+// excluded, the size is treated as full, so a larger size may be chosen instead.
+func (inv *Inventory) DepositPackageExcluding(pkg *Package, exclude map[LockerID]bool) (LockerID, error) {
+	p := inv.packagePlacement(pkg, PurposeDeposit)
+	p.exclude = exclude
+	return inv.deposit(pkg, p)
+}
+
+// places a package into a specific locker, such as one a customer reserved, instead of
+// the one DepositPackage would choose. The locker must be available and large enough
+// for the package, but its purpose and cleanliness are not checked, since the caller
+// is choosing it. The inventory's MaxOccupancy still applies.
+func (inv *Inventory) DepositIntoLocker(lockerId LockerID, pkg *Package) error {
+	locker_index, ok := inv.LockersById[lockerId]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	if _, ok := inv.LockersByPackageId[pkg.Id]; ok {
+		return ErrDuplicatePackageID
+	}
+	if pkg.StoredIn != nil {
+		return ErrPackageAlreadyStored
+	}
+
+	l := &inv.Lockers[locker_index]
+	if l.Contents != nil {
+		return ErrLockerNotEmpty
+	}
+	ctrl := inv.Control[l.SizeId]
+	p := inv.packagePlacement(pkg, l.Purpose)
+	if !p.fits(ctrl) {
+		return errors.New("Package does not fit in locker")
+	}
+	if !ctrl.carries(pkg.Weight) {
+		return errors.New("Package is too heavy for locker")
+	}
+	position := inv.availablePosition(locker_index)
+	if position < 0 || ctrl.Inactive {
+		return errors.New("Locker is not available")
+	}
+	if inv.atMaxOccupancy() {
+		return ErrFacilityAtCapacity
+	}
+
+	tightest_id, _ := inv.tightestFor(p)
+	_, err := inv.fill(pkg, l.SizeId, position, tightest_id)
+	return err
+}
+
+// places an outbound package into the inventory, as DepositPackage, except that the
+// inventory's MaxOccupancy is ignored, for the cases which the room it keeps free is for.
+func (inv *Inventory) DepositOverride(pkg *Package) (LockerID, error) {
+	p := inv.packagePlacement(pkg, PurposeDeposit)
+	p.override_cap = true
+	return inv.deposit(pkg, p)
+}
+
+// reports whether taking one more locker would put the inventory beyond its
+// MaxOccupancy. Occupancy is measured over the lockers which can be used at all:
+// lockers which are out of service, or of an inactive size, count towards neither
+// the lockers in use nor the total, so taking a size out of service doesn't raise
+// occupancy. Dirty lockers are still free. There is no running count of usable
+// lockers to keep in step with every status change, so this is O(n) in the number
+// of lockers.
+func (inv *Inventory) atMaxOccupancy() bool {
+	if inv.MaxOccupancy <= 0 {
+		return false
+	}
+
+	total := 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.OutOfService || inv.Control[l.SizeId].Inactive { continue }
+		total += 1
+	}
+	if total == 0 {
+		return false
+	}
+
+	in_use := total
+	for _, ctrl := range inv.Control {
+		if ctrl.Inactive { continue }
+		in_use -= len(ctrl.Lockers)
+	}
+	return float64(in_use + 1) > inv.MaxOccupancy * float64(total)
+}
+
+// Records that a locker has been cleaned, making it usable for deposits again.
+func (inv *Inventory) MarkCleaned(id LockerID) error {
+	lid, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+
+	inv.Lockers[lid].LastCleaned = inv.now()
+	return nil
+}
+
+// places a package into the most suitable locker for a placement.
+func (inv *Inventory) deposit(pkg *Package, p placement) (LockerID, error) {
+	if pkg.PreferTight && p.strategy == nil {
+		p.strategy = TightestFitStrategy
+	}
+
+	if inv.MoveIfStored && pkg.StoredIn != nil {
+		if err := inv.takeForMove(pkg, p); err != nil {
+			inv.logf("lockers: cannot move package %s: %s", pkg.Id, err.Error())
+			return "", err
+		}
+	}
+
+	if _, ok := inv.LockersByPackageId[pkg.Id]; ok {
+		inv.logf("lockers: cannot deposit package %s: duplicate package ID", pkg.Id)
+		return "", ErrDuplicatePackageID
+	}
+
+	if !p.override_cap && inv.atMaxOccupancy() {
+		inv.logf("lockers: cannot deposit package %s: %s", pkg.Id, ErrFacilityAtCapacity.Error())
+		return "", ErrFacilityAtCapacity
+	}
+
+	chosen_id, err := inv.selectSize(p)
+	if err != nil {
+		inv.logf("lockers: cannot deposit package %s (%v): %s", pkg.Id, p.size, err.Error())
+		return "", err
+	}
+
+	tightest_id, _ := inv.tightestFor(p)
+	return inv.fill(pkg, chosen_id, inv.next(inv.Control[chosen_id], p), tightest_id)
+}
+
+// puts a package into the available locker at the given position in a size's list of
+// available lockers, and records the deposit. tightest_id is the tightest size which
+// could have been offered for the package (see Locker.TightestOffered).
+func (inv *Inventory) fill(pkg *Package, size_id LockerSize, position int, tightest_id LockerSize) (LockerID, error) {
+	ctrl, ok := inv.Control[size_id]
+	if !ok {
+		return "", ErrUnknownLockerSize
+	}
+	if position < 0 || position >= len(ctrl.Lockers) {
+		return "", ErrNoLockerAvailable
+	}
+	locker_index := ctrl.Lockers[position]
+	inv.forgetRetrieved(pkg, locker_index)
+	err := inv.Lockers[locker_index].Put(pkg)
+	if err != nil {
+		inv.logf("lockers: cannot deposit package %s: %s", pkg.Id, err.Error())
+		return "", err
+	}
+
+	inv.allocateAt(size_id, position)
+	ctrl.Deposits += 1
+	inv.Lockers[locker_index].LastFilled = inv.now()
+	inv.Lockers[locker_index].TightestOffered = tightest_id
+	inv.holdGroup(locker_index)
+	inv.LockersByPackageId[pkg.Id] = locker_index
+	inv.logf("lockers: deposited package %s into locker %s", pkg.Id, inv.Lockers[locker_index].Id)
+	if ctrl.Full() {
+		inv.logf("lockers: no lockers of size %v remain available", ctrl.Size)
+	}
+	if inv.OnDeposit != nil {
+		inv.OnDeposit(pkg, inv.Lockers[locker_index].Id)
+	}
+	return inv.Lockers[locker_index].Id, nil
+}
+
+// takes a stored package out of its locker so that it can be deposited again. See
+// Inventory.MoveIfStored. Retrieving the package can only make more lockers available,
+// so if a locker is available for the placement beforehand, the deposit will succeed.
+// The same goes for MaxOccupancy, unless taking the package leaves its locker in use,
+// so that case is checked before anything is taken.
+func (inv *Inventory) takeForMove(pkg *Package, p placement) error {
+	locker_index, ok := inv.LockersByPackageId[pkg.Id]
+	if !ok || &inv.Lockers[locker_index] != pkg.StoredIn {
+		return errors.New("Package is stored in a different inventory")
+	}
+	if _, err := inv.selectSize(p); err != nil {
+		return err
+	}
+	if !p.override_cap && inv.atMaxOccupancy() && !inv.freedByTaking(locker_index) {
+		return ErrFacilityAtCapacity
+	}
+
+	key, has_key := inv.keysByPackage[pkg.Id]
+	inv.Lockers[locker_index].toTop(pkg.Id)
+	if _, err := inv.take(locker_index); err != nil {
+		return err
+	}
+	if has_key {
+		inv.packagesByKey[key] = pkg.Id
+		inv.keysByPackage[pkg.Id] = key
+	}
+	return nil
+}
+
+// reports whether taking one package out of a locker would make it available again,
+// as release does: it must hold nothing else, be in service, and not be kept in use
+// by the other lockers of its door group.
+func (inv *Inventory) freedByTaking(locker_index int) bool {
+	l := &inv.Lockers[locker_index]
+	if len(l.Stacked) != 0 || l.OutOfService {
+		return false
+	}
+	if g, ok := inv.doorGroups[l.DoorGroup]; ok {
+		for _, index := range g.members {
+			if index != locker_index && inv.Lockers[index].Contents != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// moves the package on top of one locker (see Locker.Fetch) into the available locker
+// at the given position in a size's list of available lockers, keeping free lists,
+// capacity and the package index up to date. Once nothing is left in the source, it
+// is emptied like any other retrieval.
+func (inv *Inventory) moveTo(src_index int, size_id LockerSize, position int) int {
+	dst_index := inv.allocateAt(size_id, position)
+
+	pkg, _ := inv.Lockers[src_index].Fetch()
+	inv.forgetRetrieved(pkg, dst_index)
+	inv.Lockers[dst_index].Put(pkg)
+	inv.Lockers[dst_index].LastFilled = inv.Lockers[src_index].LastFilled
+	inv.Lockers[dst_index].TightestOffered = inv.Lockers[src_index].TightestOffered
+	inv.holdGroup(dst_index)
+	inv.LockersByPackageId[pkg.Id] = dst_index
+
+	if inv.Lockers[src_index].Contents == nil {
+		inv.Lockers[src_index].LastEmptied = inv.now()
+		inv.release(src_index)
+	}
+	return dst_index
+}
+
+// finds the tightest size class (see BinPackage) which has a usable available
+// locker for a placement, ignoring the usual selection strategy.
+func (inv *Inventory) tightestFor(p placement) (LockerSize, bool) {
+	var best_id LockerSize
+	var best SizeSpec
+	for s, size_id := range inv.Sizes {
+		if !p.accepts(inv.Control[size_id]) { continue }
+		if inv.next(inv.Control[size_id], p) < 0 { continue }
+		if best_id == LockerSize(0) || s.tighterThan(best) {
+			best_id, best = size_id, s
+		}
+	}
+	return best_id, best_id != LockerSize(0)
+}
+
+// Sets the purpose of a locker, restricting which deposits may use it.
+func (inv *Inventory) SetLockerPurpose(id LockerID, purpose LockerPurpose) error {
+	lid, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+
+	inv.Lockers[lid].Purpose = purpose
+	return nil
+}
+
+// removes a package from the inventory (via package ID lookup).
 func (inv *Inventory) RetrievePackage(pkg *Package) (*Package, error) {
 	return inv.RetrievePackageById(pkg.Id)
 }
@@ -330,39 +1891,214 @@ func (inv *Inventory) RetrievePackage(pkg *Package) (*Package, error) {
 // removes a package from the inventory.
 func (inv *Inventory) RetrievePackageById(id PackageID) (*Package, error) {
 	lid, ok := inv.LockersByPackageId[id]
+	if ok {
+		inv.Lockers[lid].toTop(id)
+	}
 	return inv.RetrievePackageInternal(lid, ok)
 }
 
-// removes a package from the inventory.
+// removes a package from the inventory. As with RetrievePackageById, an unknown locker
+// ID fails with ErrUnknownPackageID, since there is no package to retrieve.
 func (inv *Inventory) RetrievePackageByLockerId(id LockerID) (*Package, error) {
 	lid, ok := inv.LockersById[id]
 	return inv.RetrievePackageInternal(lid, ok)
 }
 
+// returns a stored package without removing it from the inventory. The package
+// returned is the one stored, not a copy, and must not be changed: in particular,
+// changing its ID or size would corrupt the inventory.
+func (inv *Inventory) PeekPackageById(id PackageID) (*Package, error) {
+	lid, ok := inv.LockersByPackageId[id]
+	if !ok {
+		return nil, ErrUnknownPackageID
+	}
+	if pkg := inv.Lockers[lid].find(id); pkg != nil {
+		return pkg, nil
+	}
+	return nil, ErrUnknownPackageID
+}
+
+// returns the package which RetrievePackageByLockerId would remove from a locker,
+// without removing it. This is the stored package, not a copy, as PeekPackageById.
+// Fails with ErrUnknownLockerID if the locker is not known, or ErrLockerEmpty if it
+// holds nothing.
+func (inv *Inventory) PeekPackageByLockerId(id LockerID) (*Package, error) {
+	lid, ok := inv.LockersById[id]
+	if !ok {
+		return nil, ErrUnknownLockerID
+	}
+	l := &inv.Lockers[lid]
+	if l.Contents == nil {
+		return nil, ErrLockerEmpty
+	}
+	if n := len(l.Stacked); n > 0 {
+		return l.Stacked[n - 1], nil
+	}
+	return l.Contents, nil
+}
+
+// empties a locker, removing its package from the inventory if it has one.
+// Unlike RetrievePackageByLockerId, an empty locker is not an error: it returns
+// the package and true if the locker held one, nil and false if the locker was
+// already empty, and an error only if the locker ID is not known.
+func (inv *Inventory) ClearLockerById(id LockerID) (*Package, bool, error) {
+	lid, ok := inv.LockersById[id]
+	if !ok {
+		return nil, false, ErrUnknownLockerID
+	}
+
+	if inv.Lockers[lid].Contents == nil {
+		return nil, false, nil
+	}
+
+	pkg, err := inv.RetrievePackageInternal(lid, true)
+	if err != nil {
+		return nil, false, err
+	}
+	return pkg, true, nil
+}
+
 // retrieves a package from the inventory. O(n) for n different size lockers.
 // internal function, not meant to be called directly.
 func (inv *Inventory) RetrievePackageInternal(locker_index int, ok bool) (*Package, error) {
 	if !ok {
-		return nil, errors.New("Package ID not known")
+		inv.logf("lockers: cannot retrieve package: package ID not known")
+		return nil, ErrUnknownPackageID
 	}
 
+	pkg, err := inv.take(locker_index)
+	if err != nil {
+		return nil, err
+	}
+	if inv.OnRetrieve != nil {
+		inv.OnRetrieve(pkg, inv.Lockers[locker_index].Id)
+	}
+	return pkg, nil
+}
+
+// takes the package on top of a locker (see Locker.Fetch) out of the inventory, as
+// RetrievePackageInternal, without reporting it to OnRetrieve.
+func (inv *Inventory) take(locker_index int) (*Package, error) {
 	pkg, err := inv.Lockers[locker_index].Fetch()
 	if err != nil {
+		inv.logf("lockers: cannot retrieve package from locker %s: %s", inv.Lockers[locker_index].Id, err.Error())
 		return nil, err
 	}
 
-	inv.DeallocateLocker(locker_index)
 	delete(inv.LockersByPackageId, pkg.Id)
+	if key, ok := inv.keysByPackage[pkg.Id]; ok {
+		delete(inv.packagesByKey, key)
+		delete(inv.keysByPackage, pkg.Id)
+	}
+	if inv.Lockers[locker_index].Contents == nil {
+		inv.Lockers[locker_index].LastEmptied = inv.now()
+		inv.Lockers[locker_index].lastRetrieved = pkg
+		if inv.retrievedFrom == nil {
+			inv.retrievedFrom = make(map[PackageID]LockerID)
+		}
+		inv.retrievedFrom[pkg.Id] = inv.Lockers[locker_index].Id
+		inv.release(locker_index)
+	}
+	inv.logf("lockers: retrieved package %s from locker %s", pkg.Id, inv.Lockers[locker_index].Id)
 	return pkg, nil
 }
 
-// Reserves a locker of the given size. This immediately removes it from the
-// available lockers in the inventory, and updates the inventory's space availability
-func (inv *Inventory) AllocateLocker(size_id LockerSize) int {
+// Puts the package most recently retrieved from a locker back into it, such as when a
+// customer opens the locker, inspects the package, and decides to leave it, bypassing
+// size selection. The locker must still be empty and available, the package must
+// not have been stored anywhere since, and it must still fit in the locker and be
+// within its weight limit, which may have changed in the meantime. The locker keeps the TightestOffered it had
+// before the retrieval, and since the same package is going back, it may be used
+// even though it is dirty.
+func (inv *Inventory) ReturnToSameLocker(id LockerID) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	pkg := l.lastRetrieved
+	if pkg == nil {
+		return errors.New("No package to return to locker")
+	}
+	if _, ok := inv.LockersByPackageId[pkg.Id]; ok || pkg.StoredIn != nil {
+		return errors.New("Package is stored elsewhere")
+	}
+	position := inv.availablePosition(index)
+	if position < 0 || inv.Control[l.SizeId].Inactive {
+		return errors.New("Locker is not available")
+	}
+	if !inv.packagePlacement(pkg, PurposeBoth).fits(inv.Control[l.SizeId]) {
+		return errors.New("Package does not fit in locker")
+	}
+	if !inv.Control[l.SizeId].carries(pkg.Weight) {
+		return errors.New("Package is too heavy for locker")
+	}
+
+	inv.allocateAt(l.SizeId, position)
+	inv.forgetRetrieved(pkg, index)
+	l.Put(pkg)
+	l.LastFilled = inv.now()
+	inv.holdGroup(index)
+	inv.LockersByPackageId[pkg.Id] = index
+	inv.logf("lockers: returned package %s to locker %s", pkg.Id, l.Id)
+	return nil
+}
+
+// Reports through the inventory's Logger that a locker was opened and closed again
+// without anything being taken out, such as by a customer inspecting a package, and
+// returns the package on top, as PeekPackageByLockerId. Nothing is retrieved: the
+// locker stays allocated with its packages in it, and OnRetrieve is not called. If the
+// package was retrieved after all and then left, use ReturnToSameLocker instead.
+func (inv *Inventory) InspectAndReclose(id LockerID) (*Package, error) {
+	pkg, err := inv.PeekPackageByLockerId(id)
+	if err != nil {
+		return nil, err
+	}
+	inv.logf("lockers: locker %s opened to inspect package %s", id, pkg.Id)
+	return pkg, nil
+}
+
+// forgets, as a package is put into a locker, the packages which can no longer be put
+// back with ReturnToSameLocker: the one last retrieved from that locker, and the
+// package itself, wherever it was last retrieved from.
+func (inv *Inventory) forgetRetrieved(pkg *Package, locker_index int) {
+	l := &inv.Lockers[locker_index]
+	if l.lastRetrieved != nil && inv.retrievedFrom[l.lastRetrieved.Id] == l.Id {
+		delete(inv.retrievedFrom, l.lastRetrieved.Id)
+	}
+	l.lastRetrieved = nil
+
+	if id, ok := inv.retrievedFrom[pkg.Id]; ok {
+		delete(inv.retrievedFrom, pkg.Id)
+		if index, ok := inv.LockersById[id]; ok && inv.Lockers[index].lastRetrieved != nil && inv.Lockers[index].lastRetrieved.Id == pkg.Id {
+			inv.Lockers[index].lastRetrieved = nil
+		}
+	}
+}
+
+// Reserves a locker of the given size, returning its index. This immediately removes
+// it from the available lockers in the inventory, and updates the inventory's space
+// availability. Returns ErrUnknownLockerSize for a size not in the inventory, or
+// ErrNoLockerAvailable if none of its lockers are available.
+func (inv *Inventory) AllocateLocker(size_id LockerSize) (int, error) {
+	ctrl, ok := inv.Control[size_id]
+	if !ok {
+		return 0, ErrUnknownLockerSize
+	}
+	if len(ctrl.Lockers) == 0 {
+		return 0, ErrNoLockerAvailable
+	}
+	return inv.allocateAt(size_id, len(ctrl.Lockers) - 1), nil
+}
+
+// reserves the available locker at the given position in a size's list of available
+// lockers, preserving the order of the others. See AllocateLocker. The size and
+// position must be valid.
+func (inv *Inventory) allocateAt(size_id LockerSize, position int) int {
 	ctrl := inv.Control[size_id]
-	locker_index := ctrl.Lockers[len(ctrl.Lockers) - 1]
-	ctrl.Lockers = ctrl.Lockers[:len(ctrl.Lockers) - 1]
-	inv.AdjustVirtualCapacity(size_id, -1)
+	locker_index := ctrl.Lockers[position]
+	ctrl.Lockers = append(ctrl.Lockers[:position], ctrl.Lockers[position + 1:]...)
+	inv.adjustCapacity(size_id, -1)
 	return locker_index
 }
 
@@ -371,14 +2107,65 @@ func (inv *Inventory) AllocateLocker(size_id LockerSize) int {
 func (inv *Inventory) DeallocateLocker(locker_index int) {
 	size_id := inv.Lockers[locker_index].SizeId
 	inv.Control[size_id].Lockers = append(inv.Control[size_id].Lockers, locker_index)
-	inv.AdjustVirtualCapacity(size_id, 1)
+	inv.adjustCapacity(size_id, 1)
 }
 
 // Updates the inventory's space availability by adding the specified amount to
 // the given locker size, and all other lockers large enough to hold the same contents
-func (inv *Inventory) AdjustVirtualCapacity(size_id LockerSize, by int) {
+// (or only the given locker size, if capacity is not transitive). Does nothing for an
+// inactive size, whose lockers are not counted. Returns ErrUnknownLockerSize for a size
+// not in the inventory.
+func (inv *Inventory) AdjustVirtualCapacity(size_id LockerSize, by int) error {
+	if _, ok := inv.Control[size_id]; !ok {
+		return ErrUnknownLockerSize
+	}
+	inv.adjustCapacity(size_id, by)
+	return nil
+}
+
+// moves one locker's worth of capacity from one size to another, as adjustCapacity(from,
+// -1) followed by adjustCapacity(to, 1), except that each size changes by its net
+// amount, and alarms are checked only once. A size which counts both, such as a smaller
+// size when TransitiveCapacity is set, ends where it started and sees no alarm.
+func (inv *Inventory) moveCapacity(from, to LockerSize) {
+	var order []LockerSize
+	changes := make(map[LockerSize]int)
+	add := func(size_id LockerSize, by int) {
+		if _, ok := changes[size_id]; !ok {
+			order = append(order, size_id)
+		}
+		changes[size_id] += by
+	}
+	shift := func(size_id LockerSize, by int) {
+		if inv.Control[size_id].Inactive { return }
+		add(size_id, by)
+		if !inv.TransitiveCapacity { return }
+		for _, other_id := range inv.Control[size_id].BiggerThan {
+			add(other_id, by)
+		}
+	}
+	shift(from, -1)
+	shift(to, 1)
+
+	for _, size_id := range order {
+		if changes[size_id] == 0 { continue }
+		inv.Control[size_id].VirtualCapacity += changes[size_id]
+		inv.checkAlarm(size_id)
+	}
+}
+
+// adjusts capacity as AdjustVirtualCapacity, for a size known to be in the inventory.
+func (inv *Inventory) adjustCapacity(size_id LockerSize, by int) {
+	if inv.Control[size_id].Inactive {
+		return
+	}
 	inv.Control[size_id].VirtualCapacity += by
+	inv.checkAlarm(size_id)
+	if !inv.TransitiveCapacity {
+		return
+	}
 	for _, other_id := range inv.Control[size_id].BiggerThan {
 		inv.Control[other_id].VirtualCapacity += by
+		inv.checkAlarm(other_id)
 	}
 }
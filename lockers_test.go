@@ -1,9 +1,13 @@
 package lockers
 
 import (
+	"context"
 	"testing"
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"time"
 )
 
 func Test_SizeSpec_Contains(t *testing.T) {
@@ -32,6 +36,31 @@ func Test_SizeSpec_Contains(t *testing.T) {
 	}
 }
 
+func Test_SizeSpec_ContainsOriented(t *testing.T) {
+	type X struct {
+		first, second SizeSpec
+		forward, reverse bool
+	}
+	tests := map[string]X{
+		"self":     X{SizeSpec{10, 11, 12}, SizeSpec{10, 11, 12}, true, true},
+		"bigger-x": X{SizeSpec{10, 10, 10}, SizeSpec{11, 10, 10}, false, true},
+		"rotated":  X{SizeSpec{12, 11, 10}, SizeSpec{10, 11, 12}, false, false},
+		"smaller":  X{SizeSpec{12, 11, 10}, SizeSpec{11, 10, 9}, true, false},
+		"upright":  X{SizeSpec{12, 11, 10}, SizeSpec{1, 1, 10}, true, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if v.first.ContainsOriented(v.second) != v.forward {
+				t.Errorf("containment failure: %v CONTAINS %v (%t, expected %t)", v.first, v.second, !v.forward, v.forward)
+			}
+			if v.second.ContainsOriented(v.first) != v.reverse {
+				t.Errorf("containment failure: %v CONTAINS %v (%t, expected %t)", v.second, v.first, !v.reverse, v.reverse)
+			}
+		})
+	}
+}
+
 func Test_SizeSpec_Volume(t *testing.T) {
 	type X struct {
 		value SizeSpec
@@ -44,6 +73,10 @@ func Test_SizeSpec_Volume(t *testing.T) {
 		"10x12x11": X{SizeSpec{10,12,11}, 10*12*11},
 		"13x12x11": X{SizeSpec{13,12,11}, 13*12*11},
 		"negative": X{SizeSpec{-1,1,1}, -1},
+		// 2097152 is 2^21, so these volumes are just beyond what an int64 holds
+		"overflowing":          X{SizeSpec{2097152,2097152,2097152}, math.MaxInt64},
+		"negative overflowing": X{SizeSpec{2097152,-2097152,2097152}, math.MinInt64},
+		"flat":                 X{SizeSpec{1 << 40,1 << 40,0}, 0},
 	}
 
 	for k, v := range tests {
@@ -55,6 +88,54 @@ func Test_SizeSpec_Volume(t *testing.T) {
 	}
 }
 
+func Test_SizeSpec_VolumeBig(t *testing.T) {
+	// 2097152 is 2^21, so a cube with sides of it has a volume of 2^63, one more than
+	// the largest int64.
+	big_side := 2097152
+
+	type X struct {
+		value SizeSpec
+		answer string
+		overflows bool
+	}
+
+	tests := map[string]X{
+		"small":       X{SizeSpec{13,12,11}, "1716", false},
+		"negative":    X{SizeSpec{-1,1,1}, "-1", false},
+		"largest":     X{SizeSpec{big_side - 1, big_side - 1, big_side - 1}, "9223358842721533951", false},
+		"overflowing": X{SizeSpec{big_side, big_side, big_side}, "9223372036854775808", true},
+		"far over":    X{SizeSpec{big_side * 4, big_side, big_side * 2}, "73786976294838206464", true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if got := v.value.VolumeBig().String(); got != v.answer {
+				t.Errorf("VOLUME %v (expected %s, got %s)", v.value, v.answer, got)
+			}
+			if got := v.value.Volume(); (fmt.Sprint(got) != v.answer) != v.overflows {
+				t.Errorf("Unexpected Volume for %v: %d", v.value, got)
+			}
+		})
+	}
+
+	smaller := LockerControlSpec{VirtualCapacity: 1, Size: SizeSpec{big_side - 1, big_side - 1, big_side - 1}}
+	larger := LockerControlSpec{VirtualCapacity: 1, Size: SizeSpec{big_side, big_side, big_side}}
+	if larger.Size.Volume() != math.MaxInt64 {
+		t.Fatalf("Expected the larger volume to be clamped, got %d", larger.Size.Volume())
+	}
+	inv := MockInventory{CompareFrom: &smaller, CompareTo: &larger}
+	if !LockerSize(0).Before(LockerSize(1), inv) {
+		t.Errorf("Overflowing volume was ordered before a smaller one")
+	}
+	inv = MockInventory{CompareFrom: &larger, CompareTo: &smaller}
+	if LockerSize(0).Before(LockerSize(1), inv) {
+		t.Errorf("Overflowing volume was ordered before a smaller one")
+	}
+	if !smaller.Size.tighterThan(larger.Size) || larger.Size.tighterThan(smaller.Size) {
+		t.Errorf("Overflowing volume was treated as a tighter fit")
+	}
+}
+
 func Test_SizeSpec_Normalize(t *testing.T) {
 	tests := map[string]SizeSpec{
 		"rearrange-1": SizeSpec{Length: 1, Width: 3, Height: 5},
@@ -79,6 +160,119 @@ func Test_SizeSpec_Normalize(t *testing.T) {
 	}
 }
 
+func Test_SizeSpec_Normalize_Extremes(t *testing.T) {
+	max_int := int(^uint(0) >> 1)
+	min_int := -max_int - 1
+
+	type X struct {
+		value SizeSpec
+		answer SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"min length":     X{SizeSpec{min_int, 3, 1}, SizeSpec{3, 1, 0}, true},
+		"min width":      X{SizeSpec{5, min_int, 1}, SizeSpec{5, 1, 0}, true},
+		"min height":     X{SizeSpec{5, 3, min_int}, SizeSpec{5, 3, 0}, true},
+		"all min":        X{SizeSpec{min_int, min_int, min_int}, SizeSpec{0, 0, 0}, true},
+		"large negative": X{SizeSpec{min_int + 1, 3, 1}, SizeSpec{max_int, 3, 1}, false},
+		"max":            X{SizeSpec{1, max_int, 3}, SizeSpec{max_int, 3, 1}, false},
+		"negative":       X{SizeSpec{-1, -3, -5}, SizeSpec{5, 3, 1}, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			normalized := v.value.Normalize()
+			if normalized != v.answer {
+				t.Errorf("%v mis-normalized to %v", v.value, normalized)
+			}
+			if normalized.Length < 0 || normalized.Width < 0 || normalized.Height < 0 {
+				t.Errorf("%v normalized to a negative dimension: %v", v.value, normalized)
+			}
+
+			checked, err := v.value.NormalizeChecked()
+			if (err != nil) != v.is_error {
+				t.Errorf("Unexpected error result: %v", err)
+			}
+			if err == nil && checked != v.answer {
+				t.Errorf("%v mis-normalized to %v", v.value, checked)
+			}
+		})
+	}
+}
+
+func Test_ParseSizeSpec(t *testing.T) {
+	type X struct {
+		text string
+		size SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"simple":        X{"10x20x30", SizeSpec{10,20,30}, false},
+		"upper case":    X{"10X20X30", SizeSpec{10,20,30}, false},
+		"spaces":        X{" 10 x 20x 30 ", SizeSpec{10,20,30}, false},
+		"denormalized":  X{"3x-1x2", SizeSpec{3,-1,2}, false},
+		"two":           X{"10x20", SizeSpec{}, true},
+		"four":          X{"1x2x3x4", SizeSpec{}, true},
+		"empty":         X{"", SizeSpec{}, true},
+		"empty part":    X{"1xx3", SizeSpec{}, true},
+		"not a number":  X{"1xtwox3", SizeSpec{}, true},
+		"fraction":      X{"1.5x2x3", SizeSpec{}, true},
+		"other sep":     X{"1*2*3", SizeSpec{}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			size, err := ParseSizeSpec(v.text)
+			if (err != nil) != v.is_error || size != v.size {
+				t.Errorf("Expected %v (error %t), got %v (%v)", v.size, v.is_error, size, err)
+			}
+			if err != nil { return }
+
+			again, err := ParseSizeSpec(size.String())
+			if err != nil || again != size {
+				t.Errorf("String did not round trip: %q", size.String())
+			}
+		})
+	}
+
+	if s := (SizeSpec{10,20,30}).String(); s != "10x20x30" {
+		t.Errorf("Unexpected string: %q", s)
+	}
+}
+
+func Test_SizeSpec_Equal_Hash(t *testing.T) {
+	type X struct {
+		first, second SizeSpec
+		equal bool
+	}
+
+	tests := map[string]X{
+		"self": X{SizeSpec{1,2,3}, SizeSpec{1,2,3}, true},
+		"rotated": X{SizeSpec{1,2,3}, SizeSpec{3,2,1}, true},
+		"negated": X{SizeSpec{1,-2,3}, SizeSpec{3,2,1}, true},
+		"different": X{SizeSpec{1,2,3}, SizeSpec{1,2,4}, false},
+		"permuted-values": X{SizeSpec{1,1,3}, SizeSpec{1,3,3}, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if v.first.Equal(v.second) != v.equal {
+				t.Errorf("%v EQUAL %v (%t, expected %t)", v.first, v.second, !v.equal, v.equal)
+			}
+			if (v.first.Hash() == v.second.Hash()) != v.equal {
+				t.Errorf("%v HASH %v (%x, %x)", v.first, v.second, v.first.Hash(), v.second.Hash())
+			}
+		})
+	}
+
+	// the hash is documented, so it should never change
+	if h := (SizeSpec{1,2,3}).Hash(); h != 0x2978848ba26b2705 {
+		t.Errorf("Unstable hash: %x", h)
+	}
+}
+
 type MockInventory struct {
 	CompareFrom, CompareTo *LockerControlSpec
 }
@@ -114,6 +308,9 @@ func Test_LockerSize_Before(t *testing.T) {
 		"25-6": X{LockerControlSpec{VirtualCapacity: 25, Size: SizeSpec{6,6,6}}, true},
 		"25-5": X{LockerControlSpec{VirtualCapacity: 25, Size: SizeSpec{5,5,5}}, true},
 		"25-4": X{LockerControlSpec{VirtualCapacity: 25, Size: SizeSpec{4,4,4}}, true},
+		"25+50-6": X{LockerControlSpec{VirtualCapacity: 25, PriorityBias: 50, Size: SizeSpec{6,6,6}}, false},
+		"75-50-4": X{LockerControlSpec{VirtualCapacity: 75, PriorityBias: -50, Size: SizeSpec{4,4,4}}, true},
+		"75-50-6": X{LockerControlSpec{VirtualCapacity: 75, PriorityBias: -50, Size: SizeSpec{6,6,6}}, true},
 	}
 
 	for k, v := range tests {
@@ -126,6 +323,45 @@ func Test_LockerSize_Before(t *testing.T) {
 	}
 }
 
+func Test_LockerSize_After(t *testing.T) {
+	type X struct {
+		cmp LockerControlSpec
+		expected bool
+	}
+
+	inv := MockInventory{
+		CompareFrom: &LockerControlSpec{
+			VirtualCapacity: 50,
+			Size: SizeSpec{5, 5, 5},
+		},
+	}
+
+	tests := map[string]X{
+		"75-6": X{LockerControlSpec{VirtualCapacity: 75, Size: SizeSpec{6,6,6}}, true},
+		"75-4": X{LockerControlSpec{VirtualCapacity: 75, Size: SizeSpec{4,4,4}}, true},
+		"50-6": X{LockerControlSpec{VirtualCapacity: 50, Size: SizeSpec{6,6,6}}, false},
+		"50-5": X{LockerControlSpec{VirtualCapacity: 50, Size: SizeSpec{5,5,5}}, false},
+		"50-4": X{LockerControlSpec{VirtualCapacity: 50, Size: SizeSpec{4,4,4}}, true},
+		"50-same-volume": X{LockerControlSpec{VirtualCapacity: 50, Size: SizeSpec{25,5,1}}, false},
+		"25-4": X{LockerControlSpec{VirtualCapacity: 25, Size: SizeSpec{4,4,4}}, false},
+		"25+50-6": X{LockerControlSpec{VirtualCapacity: 25, PriorityBias: 50, Size: SizeSpec{6,6,6}}, true},
+		"75-50-4": X{LockerControlSpec{VirtualCapacity: 75, PriorityBias: -50, Size: SizeSpec{4,4,4}}, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv.CompareTo = &v.cmp
+			after := LockerSize(0).After(LockerSize(1), inv)
+			if after != v.expected {
+				t.Errorf("Unexpected AFTER result: %v %v (%t, should be %t)", inv.CompareFrom, inv.CompareTo, after, v.expected)
+			}
+			if after && LockerSize(0).Before(LockerSize(1), inv) {
+				t.Errorf("Size is both before and after: %v %v", inv.CompareFrom, inv.CompareTo)
+			}
+		})
+	}
+}
+
 func Test_LockerControlSpec_Full(t *testing.T) {
 	spec := LockerControlSpec{}
 	if !spec.Full() {
@@ -152,7 +388,7 @@ func Test_Inventory_ControlSpec(t *testing.T) {
 	}
 }
 
-func Example_Locker_PutFetch() {
+func ExampleLocker_Put() {
 	locker := Locker{
 	}
 
@@ -409,9 +645,9 @@ func Test_New_Inventory(t *testing.T) {
 		}},
 		"1-type-lockers": X{map[SizeSpec]int{SizeSpec{1,1,1}:3}, &Inventory{
 			Lockers: []Locker{
-				Locker{"1", 100, nil},
-				Locker{"2", 100, nil},
-				Locker{"3", 100, nil},
+				Locker{Id: "1", SizeId: 100},
+				Locker{Id: "2", SizeId: 100},
+				Locker{Id: "3", SizeId: 100},
 			},
 			Control: map[LockerSize]*LockerControlSpec{
 				100: &LockerControlSpec{
@@ -433,12 +669,12 @@ func Test_New_Inventory(t *testing.T) {
 		}},
 		"3-type-lockers": X{map[SizeSpec]int{SizeSpec{3,3,3}:2, SizeSpec{1,1,1}:2, SizeSpec{2,2,2}:2}, &Inventory{
 			Lockers: []Locker{
-				Locker{"1", 100, nil},
-				Locker{"2", 100, nil},
-				Locker{"3", 200, nil},
-				Locker{"4", 200, nil},
-				Locker{"5", 300, nil},
-				Locker{"6", 300, nil},
+				Locker{Id: "1", SizeId: 100},
+				Locker{Id: "2", SizeId: 100},
+				Locker{Id: "3", SizeId: 200},
+				Locker{Id: "4", SizeId: 200},
+				Locker{Id: "5", SizeId: 300},
+				Locker{Id: "6", SizeId: 300},
 			},
 			Control: map[LockerSize]*LockerControlSpec{
 				100: &LockerControlSpec{
@@ -481,10 +717,10 @@ func Test_New_Inventory(t *testing.T) {
 		}},
 		"duplicate-lockers": X{map[SizeSpec]int{SizeSpec{2,1,1}:2, SizeSpec{1,2,1}:2}, &Inventory{
 			Lockers: []Locker{
-				Locker{"1", 100, nil},
-				Locker{"2", 100, nil},
-				Locker{"3", 100, nil},
-				Locker{"4", 100, nil},
+				Locker{Id: "1", SizeId: 100},
+				Locker{Id: "2", SizeId: 100},
+				Locker{Id: "3", SizeId: 100},
+				Locker{Id: "4", SizeId: 100},
 			},
 			Control: map[LockerSize]*LockerControlSpec{
 				100: &LockerControlSpec{
@@ -522,19 +758,175 @@ func Test_New_Inventory(t *testing.T) {
 	}
 }
 
+func Test_NewInventoryByProportion(t *testing.T) {
+	type X struct {
+		proportions map[SizeSpec]float64
+		total int
+		expected map[SizeSpec]int
+	}
+
+	tests := map[string]X{
+		"exact": X{map[SizeSpec]float64{SizeSpec{1,1,1}: 0.2, SizeSpec{2,2,2}: 0.5, SizeSpec{3,3,3}: 0.3}, 10,
+			map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 5, SizeSpec{3,3,3}: 3}},
+		"unnormalized": X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 3}, 8,
+			map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 6}},
+		"largest remainder": X{map[SizeSpec]float64{SizeSpec{1,1,1}: 0.45, SizeSpec{2,2,2}: 0.35, SizeSpec{3,3,3}: 0.2}, 3,
+			map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1}},
+		"ties to smaller": X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1}, 4,
+			map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1}},
+		"denormalized": X{map[SizeSpec]float64{SizeSpec{2,1,1}: 1, SizeSpec{1,2,1}: 1}, 3,
+			map[SizeSpec]int{SizeSpec{2,1,1}: 3}},
+		"zero share": X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 0}, 2,
+			map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 0}},
+		"negative":   X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: -1}, 2, nil},
+		"all zero":   X{map[SizeSpec]float64{SizeSpec{1,1,1}: 0}, 2, nil},
+		"empty":      X{map[SizeSpec]float64{}, 2, nil},
+		"bad total":  X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1}, -1, nil},
+		"nan":        X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: math.NaN()}, 2, nil},
+		"only nan":   X{map[SizeSpec]float64{SizeSpec{1,1,1}: math.NaN()}, 2, nil},
+		"infinite":   X{map[SizeSpec]float64{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: math.Inf(1)}, 2, nil},
+		"negative infinite": X{map[SizeSpec]float64{SizeSpec{1,1,1}: math.Inf(-1)}, 2, nil},
+		"infinite sum": X{map[SizeSpec]float64{SizeSpec{1,1,1}: math.MaxFloat64, SizeSpec{2,2,2}: math.MaxFloat64}, 2, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventoryByProportion(v.proportions, v.total)
+			if v.expected == nil {
+				if inv != nil {
+					t.Errorf("Expected nil inventory")
+				}
+				return
+			}
+			if inv == nil {
+				t.Fatalf("Unexpected nil inventory")
+			}
+			if eq, explanation := CompareInventories(t, inv, NewInventory(v.expected)); !eq {
+				t.Errorf("Unexpected inventory: %s", explanation)
+			}
+		})
+	}
+}
+
+func Test_NewInventoryWithIDs(t *testing.T) {
+	type X struct {
+		ids map[SizeSpec][]LockerID
+		result *Inventory
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"no-lockers": X{map[SizeSpec][]LockerID{}, NewInventory(map[SizeSpec]int{}), false},
+		"3-type-lockers": X{map[SizeSpec][]LockerID{
+			SizeSpec{3,3,3}: []LockerID{"C-1", "C-2"},
+			SizeSpec{1,1,1}: []LockerID{"A-1", "A-2"},
+			SizeSpec{2,2,2}: []LockerID{"B-1", "B-2"},
+		}, NewInventory(map[SizeSpec]int{SizeSpec{3,3,3}:2, SizeSpec{1,1,1}:2, SizeSpec{2,2,2}:2}), false},
+		"duplicate-lockers": X{map[SizeSpec][]LockerID{
+			SizeSpec{2,1,1}: []LockerID{"A-1", "A-2"},
+			SizeSpec{1,2,1}: []LockerID{"A-3", "A-4"},
+		}, NewInventory(map[SizeSpec]int{SizeSpec{2,1,1}:4}), false},
+		"duplicate-id-same-size": X{map[SizeSpec][]LockerID{
+			SizeSpec{1,1,1}: []LockerID{"A-1", "A-1"},
+		}, nil, true},
+		"duplicate-id-across-sizes": X{map[SizeSpec][]LockerID{
+			SizeSpec{1,1,1}: []LockerID{"A-1", "A-2"},
+			SizeSpec{2,2,2}: []LockerID{"B-1", "A-2"},
+		}, nil, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(v.ids)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Expected error, but completed successfully")
+				return
+			}
+
+			eq, explanation := CompareInventories(t, inv, v.result)
+			if !eq {
+				t.Errorf("Incorrect NewInventoryWithIDs output:\n%+v\nExpected:\n%+v\n%s", inv, v.result, explanation)
+			}
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+
+			for size, ids := range v.ids {
+				for _, id := range ids {
+					index, ok := inv.LockersById[id]
+					if !ok {
+						t.Errorf("Missing locker ID %s", id)
+					} else if inv.Control[inv.Lockers[index].SizeId].Size != size.Normalize() {
+						t.Errorf("Locker %s has the wrong size", id)
+					}
+				}
+			}
+			for size, size_id := range v.result.Sizes {
+				if inv.Sizes[size] != size_id {
+					t.Errorf("Size %v numbered %d, expected %d", size, inv.Sizes[size], size_id)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_Ref(t *testing.T) {
+	inv, _ := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"A-1", "A-2"},
+		SizeSpec{2,2,2}: []LockerID{"B-1"},
+	})
+
+	refs := make(map[LockerRef]LockerID)
+	for _, id := range []LockerID{"A-1", "A-2", "B-1"} {
+		ref, ok := inv.Ref(id)
+		if !ok {
+			t.Errorf("No reference for locker %s", id)
+			continue
+		}
+		if _, dupe := refs[ref]; dupe {
+			t.Errorf("Duplicate reference for locker %s", id)
+		}
+		refs[ref] = id
+	}
+
+	for ref, id := range refs {
+		l, ok := inv.Resolve(ref)
+		if !ok || l.Id != id {
+			t.Errorf("Reference for %s resolved to %+v", id, l)
+		}
+		index, ok := inv.Index(ref)
+		if !ok || index != inv.LockersById[id] {
+			t.Errorf("Reference for %s resolved to index %d", id, index)
+		}
+	}
+
+	if _, ok := inv.Ref("no-id"); ok {
+		t.Errorf("Got a reference for an unknown locker")
+	}
+	if _, ok := inv.Resolve(LockerRef{}); ok {
+		t.Errorf("Resolved the zero reference")
+	}
+}
+
 func basic(t *testing.T) *Inventory {
 	t.Helper()
 
 	return &Inventory{
 		Lockers: []Locker{
-			Locker{"1", 100, nil},
-			Locker{"2", 100, nil},
-			Locker{"3", 200, nil},
-			Locker{"4", 200, nil},
-			Locker{"5", 300, nil},
-			Locker{"6", 300, nil},
-			Locker{"7", 400, nil},
-			Locker{"8", 400, nil},
+			Locker{Id: "1", SizeId: 100},
+			Locker{Id: "2", SizeId: 100},
+			Locker{Id: "3", SizeId: 200},
+			Locker{Id: "4", SizeId: 200},
+			Locker{Id: "5", SizeId: 300},
+			Locker{Id: "6", SizeId: 300},
+			Locker{Id: "7", SizeId: 400},
+			Locker{Id: "8", SizeId: 400},
 		},
 		Control: map[LockerSize]*LockerControlSpec{
 			100: &LockerControlSpec{
@@ -585,6 +977,7 @@ func basic(t *testing.T) *Inventory {
 			"8": 7,
 		},
 		LockersByPackageId: make(map[PackageID]int),
+		TransitiveCapacity: true,
 	}
 }
 
@@ -593,15 +986,15 @@ func cplx(t *testing.T) *Inventory {
 
 	return &Inventory{
 		Lockers: []Locker{
-			Locker{"1", 100, nil},
-			Locker{"2", 100, nil},
-			Locker{"3", 200, nil},
-			Locker{"4", 200, nil},
-			Locker{"4.5", 200, nil},
-			Locker{"5", 300, nil},
-			Locker{"6", 300, nil},
-			Locker{"7", 400, nil},
-			Locker{"8", 400, nil},
+			Locker{Id: "1", SizeId: 100},
+			Locker{Id: "2", SizeId: 100},
+			Locker{Id: "3", SizeId: 200},
+			Locker{Id: "4", SizeId: 200},
+			Locker{Id: "4.5", SizeId: 200},
+			Locker{Id: "5", SizeId: 300},
+			Locker{Id: "6", SizeId: 300},
+			Locker{Id: "7", SizeId: 400},
+			Locker{Id: "8", SizeId: 400},
 		},
 		Control: map[LockerSize]*LockerControlSpec{
 			100: &LockerControlSpec{
@@ -653,6 +1046,7 @@ func cplx(t *testing.T) *Inventory {
 			"8": 8,
 		},
 		LockersByPackageId: make(map[PackageID]int),
+		TransitiveCapacity: true,
 	}
 }
 
@@ -661,28 +1055,19 @@ func Test_Inventory_AllocateLocker(t *testing.T) {
 
 	type X struct {
 		size SizeSpec
-		panics bool
+		err error
 	}
 
 	tests := map[string]X{
-		"smallest": X{SizeSpec{1,1,1}, false},
-		"medium": X{SizeSpec{2,2,2}, false},
-		"largest": X{SizeSpec{3,3,3}, false},
-		"overallocate": X{SizeSpec{4,4,4}, true},
-		"missing": X{SizeSpec{5,5,5}, true},
+		"smallest": X{SizeSpec{1,1,1}, nil},
+		"medium": X{SizeSpec{2,2,2}, nil},
+		"largest": X{SizeSpec{3,3,3}, nil},
+		"overallocate": X{SizeSpec{4,4,4}, ErrNoLockerAvailable},
+		"missing": X{SizeSpec{5,5,5}, ErrUnknownLockerSize},
 	}
 
 	for k, v := range tests {
 		t.Run(k, func(t *testing.T) {
-			defer func(){
-				r := recover()
-				if v.panics && r == nil {
-					t.Errorf("Expected panic, but completed normally")
-				} else if !v.panics && r != nil {
-					t.Errorf("Expected normal completion, but panic'd")
-				}
-			}()
-
 			size := inv.Sizes[v.size]
 			var inner_available []int
 			var capacity int
@@ -697,8 +1082,17 @@ func Test_Inventory_AllocateLocker(t *testing.T) {
 			}
 
 			// a should be removed from inv.Control[size].Lockers
-			// if the slice has no elements, or if size isn't in Control, it will panic
-			a := inv.AllocateLocker(size)
+			// if the slice has no elements, or if size isn't in Control, it is an error
+			a, err := inv.AllocateLocker(size)
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err != nil {
+				if x, ok := inv.Control[size]; ok && x.VirtualCapacity != capacity {
+					t.Error("Virtual capacity changed by a failed allocation")
+				}
+				return
+			}
 
 			if len(available) != len(inv.Control[size].Lockers) + 1 {
 				t.Error("Mismatched lengths between expected and actual lockers free")
@@ -735,29 +1129,22 @@ func Test_Inventory_AdjustVirtualCapacity(t *testing.T) {
 		add int
 		addto LockerSize
 		result *Inventory
-		panics bool
+		err error
 	}
 
 	tests := map[string]X{
-		"smallest": X{1, 100, inv1, false},
-		"medium": X{2, 200, inv2, false},
-		"largest": X{3, 300, inv3, false},
-		"missing": X{4, 400, nil, true},
+		"smallest": X{1, 100, inv1, nil},
+		"medium": X{2, 200, inv2, nil},
+		"largest": X{3, 300, inv3, nil},
+		"missing": X{4, 500, basic(t), ErrUnknownLockerSize},
 	}
 
 	for k, v := range tests {
 		t.Run(k, func(t *testing.T) {
-			defer func() {
-				r := recover()
-				if v.panics && r == nil {
-					t.Errorf("Expected panic, but completed normally")
-				} else if !v.panics && r != nil {
-					t.Errorf("Expected normal completion, but panic'd")
-				}
-			}()
-
 			inv := basic(t)
-			inv.AdjustVirtualCapacity(v.addto, v.add)
+			if err := inv.AdjustVirtualCapacity(v.addto, v.add); err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
 
 			eq, explain := CompareInventories(t, inv, v.result)
 			if !eq {
@@ -767,6 +1154,79 @@ func Test_Inventory_AdjustVirtualCapacity(t *testing.T) {
 	}
 }
 
+func Test_Inventory_TransitiveCapacity(t *testing.T) {
+	type X struct {
+		transitive bool
+		capacities map[LockerSize]int
+		adjusted map[LockerSize]int
+		small_choice SizeSpec
+	}
+
+	tests := map[string]X{
+		"transitive": X{true,
+			map[LockerSize]int{100: 8, 200: 4, 300: 3, 400: 1},
+			map[LockerSize]int{100: 7, 200: 4, 300: 2, 400: 1},
+			SizeSpec{1,1,1}},
+		"direct": X{false,
+			map[LockerSize]int{100: 2, 200: 3, 300: 2, 400: 1},
+			map[LockerSize]int{100: 2, 200: 3, 300: 1, 400: 1},
+			SizeSpec{5,1,1}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			inv.SetTransitiveCapacity(v.transitive)
+			for size_id, c := range v.capacities {
+				if inv.Control[size_id].VirtualCapacity != c {
+					t.Errorf("Wrong capacity for %d: expected %d, got %d", size_id, c, inv.Control[size_id].VirtualCapacity)
+				}
+			}
+
+			out, err := inv.GetMostSuitableLockerSize(SizeSpec{1,1,1})
+			if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			} else if inv.Control[out].Size != v.small_choice {
+				t.Errorf("Wrong selection: expected %v, got %v", v.small_choice, inv.Control[out].Size)
+			}
+
+			inv.AdjustVirtualCapacity(300, -1)
+			for size_id, c := range v.adjusted {
+				if inv.Control[size_id].VirtualCapacity != c {
+					t.Errorf("Wrong adjusted capacity for %d: expected %d, got %d", size_id, c, inv.Control[size_id].VirtualCapacity)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_ResetVirtualCapacityFromFreeLists(t *testing.T) {
+	// a freshly built inventory should be unchanged
+	fresh := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{5,1,1}: 3, SizeSpec{3,3,1}: 2, SizeSpec{5,5,5}: 1})
+	expected := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{5,1,1}: 3, SizeSpec{3,3,1}: 2, SizeSpec{5,5,5}: 1})
+	fresh.ResetVirtualCapacityFromFreeLists()
+	eq, explain := CompareInventories(t, fresh, expected)
+	if !eq {
+		t.Errorf("Reset changed a fresh inventory:\n%+v\nExpected:\n%+v\n%s", fresh, expected, explain)
+	}
+
+	// an inventory with hand-edited free lists should be fixed
+	inv, result := cplx(t), cplx(t)
+	for _, c := range inv.Control {
+		c.VirtualCapacity = 0
+	}
+	inv.Control[300].Lockers = []int{5}
+	result.Control[300].Lockers = []int{5}
+	result.Control[300].VirtualCapacity -= 1
+	result.Control[100].VirtualCapacity -= 1
+
+	inv.ResetVirtualCapacityFromFreeLists()
+	eq, explain = CompareInventories(t, inv, result)
+	if !eq {
+		t.Errorf("Incorrect capacities after reset:\n%+v\nExpected:\n%+v\n%s", inv, result, explain)
+	}
+}
+
 func Test_Inventory_DeallocateLocker(t *testing.T) {
 	inv := basic(t)
 
@@ -797,24 +1257,133 @@ func Test_Inventory_DeallocateLocker(t *testing.T) {
 	}
 }
 
-func Test_Inventory_GetMostSuitableLockerSize(t *testing.T) {
-	inv1, inv2, inv3, inv4 := cplx(t), cplx(t), cplx(t), cplx(t)
-	// inv1 is normal and unmodified
+func Test_Inventory_GetMostSuitableLockerSize_Ties(t *testing.T) {
+	type X struct {
+		sizes []SizeSpec
+		pkg SizeSpec
+		expected SizeSpec
+	}
+
+	// every size has one locker and the same volume, so they tie on capacity and volume.
+	tests := map[string]X{
+		"same clearance": X{[]SizeSpec{SizeSpec{4,1,1}, SizeSpec{2,2,1}}, SizeSpec{1,1,1}, SizeSpec{2,2,1}},
+		"more clearance": X{[]SizeSpec{SizeSpec{8,1,1}, SizeSpec{4,2,1}, SizeSpec{2,2,2}}, SizeSpec{1,1,1}, SizeSpec{2,2,2}},
+		"dimensions":     X{[]SizeSpec{SizeSpec{8,1,1}, SizeSpec{4,2,1}, SizeSpec{2,2,2}}, SizeSpec{2,1,1}, SizeSpec{2,2,2}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			counts := make(map[SizeSpec]int)
+			for _, size := range v.sizes {
+				counts[size] = 1
+			}
+
+			// map iteration order changes from one inventory to the next.
+			for i := 0; i < 200; i++ {
+				inv := NewInventory(counts)
+				size_id, err := inv.GetMostSuitableLockerSize(v.pkg)
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+				if inv.Control[size_id].Size != v.expected {
+					t.Fatalf("Run %d chose %v, expected %v", i, inv.Control[size_id].Size, v.expected)
+				}
+			}
+		})
+	}
+}
 
+func Test_Inventory_RankSuitableLockerSizes(t *testing.T) {
+	inv1, inv2 := cplx(t), cplx(t)
 	// inv2 has all {1,1,1} lockers allocated
-	inv2.Control[100].VirtualCapacity -= len(inv2.Control[200].Lockers)
+	inv2.Control[100].VirtualCapacity -= len(inv2.Control[100].Lockers)
 	inv2.Control[100].Lockers = nil
 
-	// inv3 has all {1,1,1} lockers allocated, and one {4,1,1} locker resized to {3,3,1}
-	inv3.Control[100].VirtualCapacity -= len(inv2.Control[200].Lockers)
-	inv3.Control[100].Lockers = nil
-	inv3.Control[200].Lockers = []int{2,3}
-	inv3.Control[300].Lockers = []int{4,5,6}
-	inv3.Lockers[4].SizeId = 300
-	inv3.Control[200].VirtualCapacity -= 1
-	inv3.Control[300].VirtualCapacity += 1
+	type X struct {
+		inv *Inventory
+		size SizeSpec
+	}
 
-	// inv4 has no available lockers except for small
+	tests := map[string]X{
+		"normal-small":  X{inv1, SizeSpec{1,1,1}},
+		"normal-med2":   X{inv1, SizeSpec{1,2,2}},
+		"nosmall-small": X{inv2, SizeSpec{1,1,1}},
+		"denormalized":  X{inv1, SizeSpec{-2,1,2}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			ranked, err := v.inv.RankSuitableLockerSizes(v.size)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			expected := 0
+			for s, size_id := range v.inv.Sizes {
+				if s.Contains(v.size.Normalize()) && !v.inv.Control[size_id].Full() {
+					expected += 1
+				}
+			}
+			if len(ranked) != expected {
+				t.Errorf("Expected %d sizes, got %v", expected, ranked)
+			}
+			for i := 1; i < len(ranked); i++ {
+				if ranked[i].Before(ranked[i - 1], v.inv) {
+					t.Errorf("Sizes out of order: %v", ranked)
+				}
+			}
+			best, _ := v.inv.GetMostSuitableLockerSize(v.size.Normalize())
+			if ranked[0] != best {
+				t.Errorf("Best ranked size %d is not the one chosen, %d", ranked[0], best)
+			}
+
+			again, _ := v.inv.RankSuitableLockerSizes(v.size)
+			if !reflect.DeepEqual(ranked, again) {
+				t.Errorf("Ranking changed: %v then %v", ranked, again)
+			}
+		})
+	}
+
+	if _, err := inv1.RankSuitableLockerSizes(SizeSpec{7,1,1}); err != ErrNoLockerFits {
+		t.Errorf("Expected ErrNoLockerFits, got %v", err)
+	}
+
+	// sizes which tie on capacity and volume are ranked the same way every time.
+	counts := map[SizeSpec]int{SizeSpec{8,1,1}: 1, SizeSpec{4,2,1}: 1, SizeSpec{2,2,2}: 1}
+	var first []SizeSpec
+	for i := 0; i < 100; i++ {
+		inv := NewInventory(counts)
+		ranked, _ := inv.RankSuitableLockerSizes(SizeSpec{1,1,1})
+		sizes := make([]SizeSpec, len(ranked))
+		for j, size_id := range ranked {
+			sizes[j] = inv.Control[size_id].Size
+		}
+		if first == nil {
+			first = sizes
+		} else if !reflect.DeepEqual(first, sizes) {
+			t.Fatalf("Ranking changed between inventories: %v then %v", first, sizes)
+		}
+	}
+}
+
+func Test_Inventory_GetMostSuitableLockerSize(t *testing.T) {
+	inv1, inv2, inv3, inv4 := cplx(t), cplx(t), cplx(t), cplx(t)
+	// inv1 is normal and unmodified
+
+	// inv2 has all {1,1,1} lockers allocated
+	inv2.Control[100].VirtualCapacity -= len(inv2.Control[200].Lockers)
+	inv2.Control[100].Lockers = nil
+
+	// inv3 has all {1,1,1} lockers allocated, and one {4,1,1} locker resized to {3,3,1}
+	inv3.Control[100].VirtualCapacity -= len(inv2.Control[200].Lockers)
+	inv3.Control[100].Lockers = nil
+	inv3.Control[200].Lockers = []int{2,3}
+	inv3.Control[300].Lockers = []int{4,5,6}
+	inv3.Lockers[4].SizeId = 300
+	inv3.Control[200].VirtualCapacity -= 1
+	inv3.Control[300].VirtualCapacity += 1
+
+	// inv4 has no available lockers except for small
 	for _, c := range inv4.Control {
 		if (c.Size == SizeSpec{1,1,1}) {
 			c.VirtualCapacity = len(c.Lockers)
@@ -886,6 +1455,144 @@ func Test_Inventory_GetMostSuitableLockerSize(t *testing.T) {
 	}
 }
 
+func Test_Inventory_BinPackage(t *testing.T) {
+	inv1, inv2 := cplx(t), cplx(t)
+	// inv2 has no available lockers at all
+	for _, c := range inv2.Control {
+		c.Lockers = nil
+		c.VirtualCapacity = 0
+	}
+
+	type X struct {
+		inv *Inventory
+		size SizeSpec
+		answer SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"micro":        X{inv1, SizeSpec{1,0,0}, SizeSpec{1,1,1}, false},
+		"small":        X{inv1, SizeSpec{1,1,1}, SizeSpec{1,1,1}, false},
+		"med-ambig":    X{inv1, SizeSpec{3,1,1}, SizeSpec{5,1,1}, false},
+		"med2":         X{inv1, SizeSpec{2,2,1}, SizeSpec{3,3,1}, false},
+		"denormalized": X{inv1, SizeSpec{1,2,2}, SizeSpec{3,3,1}, false},
+		"big":          X{inv1, SizeSpec{4,4,2}, SizeSpec{5,5,5}, false},
+		"toobig":       X{inv1, SizeSpec{7,1,1}, SizeSpec{0,0,0}, true},
+
+		"full-small":   X{inv2, SizeSpec{1,1,1}, SizeSpec{1,1,1}, false},
+		"full-med2":    X{inv2, SizeSpec{2,2,1}, SizeSpec{3,3,1}, false},
+		"full-toobig":  X{inv2, SizeSpec{7,1,1}, SizeSpec{0,0,0}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			out, err := v.inv.BinPackage(v.size)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Errorf("Expected error, but got %v instead", out)
+				return
+			}
+
+			if out != v.inv.Sizes[v.answer] {
+				t.Errorf("Wrong answer: expected %v, got %v", v.answer, v.inv.Control[out].Size)
+			}
+		})
+	}
+}
+
+func Test_Inventory_AdmissionDecision(t *testing.T) {
+	inv1, inv2 := cplx(t), cplx(t)
+	// inv2 has all {1,1,1} lockers allocated
+	inv2.Control[100].VirtualCapacity -= len(inv2.Control[100].Lockers)
+	inv2.Control[100].Lockers = nil
+	inv3 := cplx(t)
+	inv3.MaxOccupancy = 0.01
+
+	type X struct {
+		inv *Inventory
+		size SizeSpec
+		admit bool
+		alternative SizeSpec
+	}
+
+	tests := map[string]X{
+		"normal-small":   X{inv1, SizeSpec{1,1,1}, true, SizeSpec{}},
+		"normal-med2":    X{inv1, SizeSpec{1,2,2}, true, SizeSpec{}},
+		"normal-toobig":  X{inv1, SizeSpec{7,1,1}, false, SizeSpec{}},
+		"nosmall-small":  X{inv2, SizeSpec{1,1,1}, true, SizeSpec{5,1,1}},
+		"nosmall-med1":   X{inv2, SizeSpec{4,1,1}, true, SizeSpec{}},
+		"nosmall-toobig": X{inv2, SizeSpec{7,1,1}, false, SizeSpec{}},
+		"at capacity":    X{inv3, SizeSpec{1,1,1}, false, SizeSpec{}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			admit, alternative := v.inv.AdmissionDecision(v.size)
+			if admit != v.admit || alternative != v.alternative {
+				t.Errorf("Wrong decision: expected %t %v, got %t %v", v.admit, v.alternative, admit, alternative)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DryRunDeposit(t *testing.T) {
+	inv1, inv2 := cplx(t), cplx(t)
+	// inv2 has all {1,1,1} lockers allocated
+	inv2.Control[100].VirtualCapacity -= len(inv2.Control[100].Lockers)
+	inv2.Control[100].Lockers = nil
+	inv3 := cplx(t)
+	inv3.MaxOccupancy = 0.01
+
+	type X struct {
+		inv *Inventory
+		size SizeSpec
+		size_id LockerSize
+		err error
+	}
+
+	tests := map[string]X{
+		"normal-small":    X{inv1, SizeSpec{1,1,1}, 100, nil},
+		"normal-rotated":  X{inv1, SizeSpec{1,4,1}, 200, nil},
+		"normal-toobig":   X{inv1, SizeSpec{7,1,1}, 0, ErrNoLockerFits},
+		"nosmall-small":   X{inv2, SizeSpec{1,1,1}, 200, nil},
+		"at capacity":     X{inv3, SizeSpec{1,1,1}, 0, ErrFacilityAtCapacity},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			before := v.inv.clone()
+			for i := 0; i < 3; i++ {
+				size_id, err := v.inv.DryRunDeposit(v.size)
+				if size_id != v.size_id || err != v.err {
+					t.Errorf("Expected %d %v, got %d %v", v.size_id, v.err, size_id, err)
+				}
+				if fits := v.inv.CanFit(v.size); fits != (v.err != ErrNoLockerFits) {
+					t.Errorf("Unexpected CanFit: %t", fits)
+				}
+			}
+			if eq, explanation := CompareInventories(t, v.inv, before); !eq {
+				t.Errorf("Dry run changed the inventory: %s", explanation)
+			}
+			for size_id, ctrl := range before.Control {
+				if len(ctrl.Lockers) != len(v.inv.Control[size_id].Lockers) || ctrl.VirtualCapacity != v.inv.Control[size_id].VirtualCapacity {
+					t.Errorf("Dry run changed size %d", size_id)
+				}
+			}
+
+			if v.err == nil {
+				locker_id, err := v.inv.clone().DepositPackage(&Package{Id: "a", Size: v.size})
+				if err != nil || v.inv.Lockers[v.inv.LockersById[locker_id]].SizeId != v.size_id {
+					t.Errorf("Deposit disagrees with dry run: %s %v", locker_id, err)
+				}
+			}
+		})
+	}
+}
+
 func Test_Inventory_DepositPackage(t *testing.T) {
 	type X struct {
 		inv *Inventory
@@ -897,16 +1604,16 @@ func Test_Inventory_DepositPackage(t *testing.T) {
 	inv.LockersByPackageId["dupe"] = 0
 
 	tests := map[string]X{
-		"tiny":        X{cplx(t), &Package{"a", SizeSpec{1,0,0}, nil}, false},
-		"small":       X{cplx(t), &Package{"b", SizeSpec{1,1,1}, nil}, false},
-		"med-ambig":   X{cplx(t), &Package{"c", SizeSpec{3,1,1}, nil}, false},
-		"med1":        X{cplx(t), &Package{"d", SizeSpec{5,1,1}, nil}, false},
-		"med2":        X{cplx(t), &Package{"e", SizeSpec{3,3,1}, nil}, false},
-		"large-ambig": X{cplx(t), &Package{"f", SizeSpec{4,4,4}, nil}, false},
-		"too-large":   X{cplx(t), &Package{"g", SizeSpec{6,6,6}, nil}, true},
-		"dupe-pkg":    X{inv,     &Package{"dupe", SizeSpec{1,1,1}, nil}, true},
-		"stored-pkg":  X{inv,     &Package{"h", SizeSpec{1,1,1}, &inv.Lockers[0]}, true},
-		"no-space":    X{&Inventory{}, &Package{"h", SizeSpec{1,1,1}, nil}, true},
+		"tiny":        X{cplx(t), &Package{Id: "a", Size: SizeSpec{1,0,0}}, false},
+		"small":       X{cplx(t), &Package{Id: "b", Size: SizeSpec{1,1,1}}, false},
+		"med-ambig":   X{cplx(t), &Package{Id: "c", Size: SizeSpec{3,1,1}}, false},
+		"med1":        X{cplx(t), &Package{Id: "d", Size: SizeSpec{5,1,1}}, false},
+		"med2":        X{cplx(t), &Package{Id: "e", Size: SizeSpec{3,3,1}}, false},
+		"large-ambig": X{cplx(t), &Package{Id: "f", Size: SizeSpec{4,4,4}}, false},
+		"too-large":   X{cplx(t), &Package{Id: "g", Size: SizeSpec{6,6,6}}, true},
+		"dupe-pkg":    X{inv,     &Package{Id: "dupe", Size: SizeSpec{1,1,1}}, true},
+		"stored-pkg":  X{inv,     &Package{Id: "h", Size: SizeSpec{1,1,1}, StoredIn: &inv.Lockers[0]}, true},
+		"no-space":    X{&Inventory{}, &Package{Id: "h", Size: SizeSpec{1,1,1}}, true},
 	}
 
 	for k, v := range tests {
@@ -945,7 +1652,7 @@ func cplx_pkg(t *testing.T) (*Inventory, *Package) {
 	locker_index := ctrl.Lockers[len(ctrl.Lockers) - 1]
 	ctrl.Lockers = ctrl.Lockers[:len(ctrl.Lockers) - 1]
 	locker := &inv.Lockers[locker_index]
-	pkg := &Package{"abc", SizeSpec{1,1,1}, locker}
+	pkg := &Package{Id: "abc", Size: SizeSpec{1,1,1}, StoredIn: locker}
 	locker.Contents = pkg
 	ctrl.VirtualCapacity -= 1
 	for _, x := range ctrl.BiggerThan {
@@ -959,80 +1666,1398 @@ func cplx_pkg(t *testing.T) (*Inventory, *Package) {
 	return inv, pkg
 }
 
-func Test_Inventory_RetrievePackage(t *testing.T) {
-	sp := func(s PackageID) *PackageID { return &s }
-
+func Test_Inventory_DepositPackageExcluding(t *testing.T) {
 	type X struct {
-		package_id *PackageID
+		exclude map[LockerID]bool
+		expected LockerID
 		is_error bool
 	}
 
 	tests := map[string]X{
-		"normal":  X{nil, false},
-		"missing": X{sp("no-id"), true},
+		"nothing excluded": X{nil, "s2", false},
+		"within size":      X{map[LockerID]bool{"s2": true}, "s1", false},
+		"size unusable":    X{map[LockerID]bool{"s1": true, "s2": true}, "b1", false},
+		"all excluded":     X{map[LockerID]bool{"s1": true, "s2": true, "b1": true}, "", true},
+		"unknown ignored":  X{map[LockerID]bool{"nope": true}, "s2", false},
 	}
 
 	for k, v := range tests {
 		t.Run(k, func(t *testing.T) {
-			inv, pkg := cplx_pkg(t)
-			if v.package_id != nil {
-				pkg.Id = *v.package_id
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+				SizeSpec{2,2,2}: []LockerID{"b1"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
 			}
 
-			output, err := inv.RetrievePackage(pkg)
-			if err != nil && v.is_error {
-				return
-			} else if err != nil {
-				t.Errorf("Unexpected error: %s", err.Error())
-				return
-			} else if v.is_error {
-				t.Error("Unespected success")
-				return
+			id, err := inv.DepositPackageExcluding(&Package{Id: "a", Size: SizeSpec{1,1,1}}, v.exclude)
+			if (err != nil) != v.is_error || id != v.expected {
+				t.Errorf("Unexpected result: got %q (%v), expected %q", id, err, v.expected)
 			}
-
-			if output != pkg {
-				t.Errorf("Got unexpected package back")
+			if v.is_error && err != ErrNoSuitableLocker {
+				t.Errorf("Expected ErrNoSuitableLocker, got %v", err)
+			}
+			for id := range v.exclude {
+				if index, ok := inv.LockersById[id]; ok && inv.Lockers[index].OutOfService {
+					t.Errorf("Excluded locker %s was taken out of service", id)
+				}
 			}
 		})
 	}
 }
 
-func Test_Inventory_RetrievePackageByLockerId(t *testing.T) {
-	sp := func(s LockerID) *LockerID { return &s }
+func Test_Inventory_MaxOccupancy(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 9, SizeSpec{2,2,2}: 1})
+	inv.MaxOccupancy = 0.9
+
+	for i := 0; i < 9; i++ {
+		if _, err := inv.DepositPackage(&Package{Id: PackageID(fmt.Sprint(i)), Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Deposit %d: unexpected error: %s", i, err.Error())
+		}
+	}
+
+	if !inv.HasFreeExact(SizeSpec{2,2,2}) {
+		t.Fatalf("Expected a locker to be available")
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "big", Size: SizeSpec{2,2,2}}); err != ErrFacilityAtCapacity {
+		t.Errorf("Expected ErrFacilityAtCapacity, got %v", err)
+	}
+	if _, ok := inv.LockersByPackageId["big"]; ok {
+		t.Errorf("Rejected package was stored")
+	}
+	if _, err := inv.DepositOverride(&Package{Id: "big", Size: SizeSpec{2,2,2}}); err != nil {
+		t.Errorf("Unexpected error from override: %s", err.Error())
+	}
+
+	inv.MaxOccupancy = 0
+	if _, err := inv.RetrievePackageById("big"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := inv.DepositPackageAllowDirty(&Package{Id: "big", Size: SizeSpec{2,2,2}}); err != nil {
+		t.Errorf("Unexpected error with no cap: %s", err.Error())
+	}
+}
 
+func Test_Inventory_MaxOccupancy_Unusable(t *testing.T) {
 	type X struct {
-		locker_id *LockerID
-		is_error bool
+		setup func(t *testing.T, inv *Inventory)
+		at_cap bool
 	}
 
 	tests := map[string]X{
-		"normal":  X{nil, false},
-		"missing": X{sp("8"), true},
+		"none":           X{func(t *testing.T, inv *Inventory) {}, false},
+		"out of service": X{func(t *testing.T, inv *Inventory) {
+			if _, err := inv.TakeSizeOutOfService(SizeSpec{2,2,2}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, false},
+		"inactive":       X{func(t *testing.T, inv *Inventory) {
+			if err := inv.DeactivateSize(SizeSpec{2,2,2}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, false},
+		"small size full": X{func(t *testing.T, inv *Inventory) {
+			if _, err := inv.TakeSizeOutOfService(SizeSpec{2,2,2}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "3", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+		}, true},
 	}
 
 	for k, v := range tests {
 		t.Run(k, func(t *testing.T) {
-			inv, pkg := cplx_pkg(t)
-			locker_id := pkg.StoredIn.Id
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2", "3", "4"},
+				SizeSpec{2,2,2}: []LockerID{"5", "6", "7", "8"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+			store_in(t, inv, "2", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+			inv.MaxOccupancy = 0.75
+
+			// with the big lockers unusable, a third small locker is still within the
+			// cap of 3 in 4, but a fourth is not
+			v.setup(t, inv)
+			if inv.atMaxOccupancy() != v.at_cap {
+				t.Errorf("Expected at cap %t, got %t", v.at_cap, !v.at_cap)
+			}
+		})
+	}
+}
 
-			if v.locker_id != nil {
-				locker_id = *v.locker_id
+func Test_Inventory_MaxOccupancy_Move(t *testing.T) {
+	type X struct {
+		setup func(t *testing.T, inv *Inventory) *Package
+		err error
+	}
+
+	tests := map[string]X{
+		// taking the package frees its locker, so the move stays within the cap
+		"alone": X{func(t *testing.T, inv *Inventory) *Package {
+			return inv.Lockers[inv.LockersById["1"]].Contents
+		}, nil},
+		"stacked": X{func(t *testing.T, inv *Inventory) *Package {
+			pkg := &Package{Id: "s", Size: SizeSpec{1,1,1}}
+			if err := inv.StackPackage("1", pkg); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			return pkg
+		}, ErrFacilityAtCapacity},
+		"door group": X{func(t *testing.T, inv *Inventory) *Package {
+			if err := inv.AddDoorGroup("door", []LockerID{"1", "2"}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
 			}
+			// the group is held already, so b goes straight into its locker
+			b := &Package{Id: "b", Size: SizeSpec{1,1,1}}
+			if err := inv.Lockers[inv.LockersById["2"]].Put(b); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			inv.LockersByPackageId[b.Id] = inv.LockersById["2"]
+			return inv.Lockers[inv.LockersById["1"]].Contents
+		}, ErrFacilityAtCapacity},
+	}
 
-			output, err := inv.RetrievePackageByLockerId(locker_id)
-			if err != nil && v.is_error {
-				return
-			} else if err != nil {
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{2,2,2}: []LockerID{"1", "2", "3", "4"}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+			store_in(t, inv, "4", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+			pkg := v.setup(t, inv)
+			inv.ResetVirtualCapacityFromFreeLists()
+			inv.MaxOccupancy = 0.5
+			inv.MoveIfStored = true
+			if !inv.atMaxOccupancy() {
+				t.Fatalf("Expected the inventory to be at its cap")
+			}
+
+			from := pkg.StoredIn
+			_, err = inv.DepositPackage(pkg)
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err != nil && (pkg.StoredIn != from || inv.LockersByPackageId[pkg.Id] != inv.LockersById[from.Id]) {
+				t.Errorf("Rejected move did not leave the package where it was")
+			}
+			if err := inv.CheckInvariants(); err != nil {
 				t.Errorf("Unexpected error: %s", err.Error())
-				return
-			} else if v.is_error {
-				t.Error("Unespected success")
-				return
 			}
+		})
+	}
+}
 
-			if output != pkg {
-				t.Errorf("Got unexpected package back")
+func Test_Inventory_InspectAndReclose(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1", "2"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+
+	pkg, err := inv.InspectAndReclose("1")
+	if err != nil || pkg == nil || pkg.Id != "a" {
+		t.Fatalf("Unexpected result: got %v (%v), expected package a", pkg, err)
+	}
+	if pkg.StoredIn == nil || inv.LockersByPackageId["a"] != inv.LockersById["1"] {
+		t.Errorf("Inspected package was removed from its locker")
+	}
+	if capacity := inv.Control[inv.Sizes[SizeSpec{1,1,1}]].VirtualCapacity; capacity != 1 {
+		t.Errorf("Unexpected capacity after inspection: %d", capacity)
+	}
+	if _, err := inv.InspectAndReclose("2"); err != ErrLockerEmpty {
+		t.Errorf("Unexpected error: got %v, expected %v", err, ErrLockerEmpty)
+	}
+	if _, err := inv.InspectAndReclose("9"); err == nil {
+		t.Errorf("Expected error inspecting an unknown locker")
+	}
+}
+
+func Test_Inventory_ReturnToSameLocker(t *testing.T) {
+	type X struct {
+		setup func(t *testing.T, inv *Inventory, pkg *Package)
+		locker LockerID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"returned":          X{func(t *testing.T, inv *Inventory, pkg *Package) {}, "1", false},
+		"unknown locker":    X{func(t *testing.T, inv *Inventory, pkg *Package) {}, "9", true},
+		"nothing retrieved": X{func(t *testing.T, inv *Inventory, pkg *Package) {}, "2", true},
+		"refilled":          X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			store_in(t, inv, "1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+		}, "1", true},
+		"stored elsewhere":  X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			store_in(t, inv, "2", pkg)
+		}, "1", true},
+		"moved on":          X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			if err := inv.DepositIntoLocker("2", pkg); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if _, err := inv.RetrievePackageById("a"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, "1", true},
+		"same id moved on":  X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			if err := inv.DepositIntoLocker("2", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if _, err := inv.RetrievePackageById("a"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, "1", true},
+		"stacked elsewhere": X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			store_in(t, inv, "2", &Package{Id: "b", Size: SizeSpec{0,0,0}})
+			if err := inv.StackPackage("2", pkg); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if _, err := inv.RetrievePackageById("a"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, "1", true},
+		"already returned":  X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			if err := inv.ReturnToSameLocker("1"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, "1", true},
+		"out of service":    X{func(t *testing.T, inv *Inventory, pkg *Package) {
+			if _, err := inv.TakeSizeOutOfService(SizeSpec{1,1,1}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, "1", true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1", "2"}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			inv.Clock = ticking_clock()
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			store_in(t, inv, "1", pkg)
+			if _, err := inv.RetrievePackageById("a"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			v.setup(t, inv, pkg)
+
+			err = inv.ReturnToSameLocker(v.locker)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if !v.is_error {
+				if pkg.StoredIn == nil || pkg.StoredIn.Id != "1" || inv.LockersByPackageId["a"] != inv.LockersById["1"] {
+					t.Errorf("Package was not returned to its locker")
+				}
+				if capacity := inv.Control[inv.Sizes[SizeSpec{1,1,1}]].VirtualCapacity; capacity != 1 {
+					t.Errorf("Unexpected capacity after return: %d", capacity)
+				}
+			}
+			if ok, msg := ValidateInventory(t, inv); !ok {
+				t.Errorf("Invalid inventory: %s", msg)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
 			}
 		})
 	}
 }
+
+func Test_Inventory_ReturnToSameLocker_Changed(t *testing.T) {
+	type X struct {
+		setup func(t *testing.T, inv *Inventory)
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"unchanged":     X{func(t *testing.T, inv *Inventory) {}, false},
+		"resized":       X{func(t *testing.T, inv *Inventory) {
+			if err := inv.ResizeLocker("1", SizeSpec{1,1,1}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, true},
+		"lighter limit": X{func(t *testing.T, inv *Inventory) {
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{2,2,2}], 4)
+		}, true},
+		"same limit":    X{func(t *testing.T, inv *Inventory) {
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{2,2,2}], 5)
+		}, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{2,2,2}: []LockerID{"1"},
+				SizeSpec{1,1,1}: []LockerID{"2"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			pkg := &Package{Id: "a", Size: SizeSpec{2,2,2}, Weight: 5}
+			store_in(t, inv, "1", pkg)
+			if _, err := inv.RetrievePackageById("a"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			v.setup(t, inv)
+
+			err = inv.ReturnToSameLocker("1")
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if v.is_error && (pkg.StoredIn != nil || inv.Lockers[inv.LockersById["1"]].Contents != nil) {
+				t.Errorf("Rejected package was returned")
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_RetrievePackage(t *testing.T) {
+	sp := func(s PackageID) *PackageID { return &s }
+
+	type X struct {
+		package_id *PackageID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"normal":  X{nil, false},
+		"missing": X{sp("no-id"), true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			if v.package_id != nil {
+				pkg.Id = *v.package_id
+			}
+
+			output, err := inv.RetrievePackage(pkg)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Unespected success")
+				return
+			}
+
+			if output != pkg {
+				t.Errorf("Got unexpected package back")
+			}
+		})
+	}
+}
+
+func Test_Inventory_RetrievePackageByLockerId(t *testing.T) {
+	sp := func(s LockerID) *LockerID { return &s }
+
+	type X struct {
+		locker_id *LockerID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"normal":  X{nil, false},
+		"missing": X{sp("8"), true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			locker_id := pkg.StoredIn.Id
+
+			if v.locker_id != nil {
+				locker_id = *v.locker_id
+			}
+
+			output, err := inv.RetrievePackageByLockerId(locker_id)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Unespected success")
+				return
+			}
+
+			if output != pkg {
+				t.Errorf("Got unexpected package back")
+			}
+		})
+	}
+}
+
+func Test_Inventory_ClearLockerById(t *testing.T) {
+	type X struct {
+		locker_id LockerID
+		found bool
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"occupied": X{"locker", true, false},
+		"empty":    X{"1", false, false},
+		"unknown":  X{"no-id", false, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			capacity := inv.Control[100].VirtualCapacity
+
+			output, found, err := inv.ClearLockerById(v.locker_id)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Unexpected success")
+				return
+			}
+
+			if found != v.found {
+				t.Errorf("Wrong found flag: expected %t, got %t", v.found, found)
+			}
+			if found && output != pkg {
+				t.Errorf("Got unexpected package back")
+			} else if !found && output != nil {
+				t.Errorf("Got a package back from an empty locker")
+			}
+
+			if _, ok := inv.LockersByPackageId[pkg.Id]; ok == found {
+				t.Errorf("Package index not updated")
+			}
+			if found && inv.Control[100].VirtualCapacity != capacity + 1 {
+				t.Errorf("Virtual capacity not restored")
+			} else if !found && inv.Control[100].VirtualCapacity != capacity {
+				t.Errorf("Virtual capacity changed")
+			}
+		})
+	}
+}
+
+func Test_LockerPurpose_Accepts(t *testing.T) {
+	type X struct {
+		purpose, direction LockerPurpose
+		accepts bool
+	}
+
+	tests := map[string]X{
+		"both-deposit":      X{PurposeBoth, PurposeDeposit, true},
+		"both-return":       X{PurposeBoth, PurposeReturn, true},
+		"deposit-deposit":   X{PurposeDeposit, PurposeDeposit, true},
+		"deposit-return":    X{PurposeDeposit, PurposeReturn, false},
+		"return-deposit":    X{PurposeReturn, PurposeDeposit, false},
+		"return-return":     X{PurposeReturn, PurposeReturn, true},
+		"return-any":        X{PurposeReturn, PurposeBoth, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if v.purpose.Accepts(v.direction) != v.accepts {
+				t.Errorf("%d ACCEPTS %d (%t, expected %t)", v.purpose, v.direction, !v.accepts, v.accepts)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DepositReturnOutbound(t *testing.T) {
+	type X struct {
+		purposes map[LockerID]LockerPurpose
+		returned bool
+		answer LockerID
+		is_error bool
+	}
+
+	// "1" and "2" are {1,1,1}, "3" is {2,2,2}. "2" is allocated first.
+	tests := map[string]X{
+		"outbound-unrestricted": X{nil, false, "2", false},
+		"return-unrestricted":   X{nil, true, "2", false},
+		"outbound-skips-return": X{map[LockerID]LockerPurpose{"2": PurposeReturn}, false, "1", false},
+		"return-skips-outbound": X{map[LockerID]LockerPurpose{"2": PurposeDeposit}, true, "1", false},
+		"outbound-falls-through": X{map[LockerID]LockerPurpose{"1": PurposeReturn, "2": PurposeReturn}, false, "3", false},
+		"outbound-only-returns": X{map[LockerID]LockerPurpose{"1": PurposeReturn, "2": PurposeReturn, "3": PurposeReturn}, false, "", true},
+		"return-only-returns":   X{map[LockerID]LockerPurpose{"1": PurposeReturn, "2": PurposeReturn, "3": PurposeReturn}, true, "2", false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2"},
+				SizeSpec{2,2,2}: []LockerID{"3"},
+			})
+			for id, purpose := range v.purposes {
+				if err := inv.SetLockerPurpose(id, purpose); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			deposit := inv.DepositOutbound
+			if v.returned {
+				deposit = inv.DepositReturn
+			}
+
+			id, err := deposit(pkg)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Errorf("Expected error, but deposited into %s", id)
+				return
+			}
+
+			if id != v.answer {
+				t.Errorf("Wrong locker: expected %s, got %s", v.answer, id)
+			}
+			if inv.Lockers[inv.LockersById[id]].Contents != pkg {
+				t.Errorf("Package not stored in returned locker")
+			}
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+		})
+	}
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+	if err := inv.SetLockerPurpose("no-id", PurposeReturn); err == nil {
+		t.Errorf("Set the purpose of an unknown locker")
+	}
+}
+
+// returns a clock which advances by a second every time it is read.
+func ticking_clock() func() time.Time {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func Test_Inventory_Cleanliness(t *testing.T) {
+	type X struct {
+		dirty []LockerID
+		cleaned []LockerID
+		allow_dirty bool
+		answer LockerID
+		is_error bool
+	}
+
+	// "1" and "2" are {1,1,1}, "3" is {2,2,2}. "2" is allocated first.
+	tests := map[string]X{
+		"clean":             X{nil, nil, false, "2", false},
+		"skips-dirty":       X{[]LockerID{"2"}, nil, false, "1", false},
+		"falls-through":     X{[]LockerID{"1", "2"}, nil, false, "3", false},
+		"all-dirty":         X{[]LockerID{"1", "2", "3"}, nil, false, "", true},
+		"all-dirty-allowed": X{[]LockerID{"1", "2", "3"}, nil, true, "2", false},
+		"cleaned":           X{[]LockerID{"1", "2", "3"}, []LockerID{"1"}, false, "1", false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2"},
+				SizeSpec{2,2,2}: []LockerID{"3"},
+			})
+			inv.Clock = ticking_clock()
+
+			// use each dirty locker once, so it needs cleaning
+			for _, id := range v.dirty {
+				pkg := &Package{Id: PackageID("used-" + id), Size: SizeSpec{1,1,1}}
+				store_in(t, inv, id, pkg)
+				if _, err := inv.RetrievePackage(pkg); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+				if !inv.Lockers[inv.LockersById[id]].Dirty() {
+					t.Errorf("Locker %s not dirty after use", id)
+				}
+			}
+			for _, id := range v.cleaned {
+				if err := inv.MarkCleaned(id); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+				if inv.Lockers[inv.LockersById[id]].Dirty() {
+					t.Errorf("Locker %s dirty after cleaning", id)
+				}
+			}
+
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			deposit := inv.DepositPackage
+			if v.allow_dirty {
+				deposit = inv.DepositPackageAllowDirty
+			}
+
+			id, err := deposit(pkg)
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Errorf("Expected error, but deposited into %s", id)
+				return
+			}
+
+			if id != v.answer {
+				t.Errorf("Wrong locker: expected %s, got %s", v.answer, id)
+			}
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+		})
+	}
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+	if err := inv.MarkCleaned("no-id"); err == nil {
+		t.Errorf("Cleaned an unknown locker")
+	}
+}
+
+type RecordingLogger struct {
+	Lines []string
+}
+
+func (l *RecordingLogger) Printf(format string, v ...interface{}) {
+	l.Lines = append(l.Lines, fmt.Sprintf(format, v...))
+}
+
+func Test_Inventory_Logger(t *testing.T) {
+	inv, _ := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1"}})
+	inv.Clock = ticking_clock()
+
+	// no logger should be silent, and not crash
+	pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+	inv.DepositPackage(pkg)
+	inv.RetrievePackage(pkg)
+	inv.MarkCleaned("1")
+
+	logger := &RecordingLogger{}
+	inv.Logger = logger
+
+	inv.DepositPackage(pkg)
+	inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{1,1,1}})
+	inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+	inv.RetrievePackage(pkg)
+	inv.RetrievePackageById("a")
+
+	expected := []string{
+		"lockers: deposited package a into locker 1",
+		"lockers: no lockers of size 1x1x1 remain available",
+		"lockers: cannot deposit package b (1x1x1): No available lockers which can fit package",
+		"lockers: cannot deposit package a: duplicate package ID",
+		"lockers: retrieved package a from locker 1",
+		"lockers: cannot retrieve package: package ID not known",
+	}
+
+	if len(logger.Lines) != len(expected) {
+		t.Fatalf("Wrong log output:\n%v", logger.Lines)
+	}
+	for i := range expected {
+		if logger.Lines[i] != expected[i] {
+			t.Errorf("Wrong log line: expected %q, got %q", expected[i], logger.Lines[i])
+		}
+	}
+}
+
+func Test_Inventory_DepositPackageIdempotent(t *testing.T) {
+	inv := basic(t)
+	inv.Clock = ticking_clock()
+
+	type X struct {
+		key string
+		pkg PackageID
+		retried bool
+		is_error bool
+	}
+
+	steps := []X{
+		X{"k1", "a", false, false},
+		X{"k1", "a", true, false},  // a plain retry
+		X{"k1", "b", true, false},  // the key wins, even with a different package
+		X{"k2", "a", false, true},  // a new key is a new deposit, so the duplicate is an error
+		X{"k2", "b", false, false},
+	}
+
+	lockers := make(map[string]LockerID)
+	for i, step := range steps {
+		locker_id, retried, err := inv.DepositPackageIdempotent(step.key, &Package{Id: step.pkg, Size: SizeSpec{1,1,1}})
+		if (err != nil) != step.is_error || retried != step.retried {
+			t.Fatalf("Step %d: unexpected result: %s %t %v", i, locker_id, retried, err)
+		}
+		if err != nil { continue }
+		if previous, ok := lockers[step.key]; ok && previous != locker_id {
+			t.Errorf("Step %d: retry returned locker %s, expected %s", i, locker_id, previous)
+		}
+		lockers[step.key] = locker_id
+	}
+	if len(inv.LockersByPackageId) != 2 {
+		t.Errorf("Expected 2 stored packages, got %d", len(inv.LockersByPackageId))
+	}
+
+	// once the package is gone, the key starts afresh
+	if _, err := inv.RetrievePackageById("a"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, retried, err := inv.DepositPackageIdempotent("k1", &Package{Id: "c", Size: SizeSpec{1,1,1}}); err != nil || retried {
+		t.Errorf("Unexpected result after retrieval: %t %v", retried, err)
+	}
+	if _, ok := inv.LockersByPackageId["c"]; !ok {
+		t.Errorf("Package was not deposited after its key was forgotten")
+	}
+}
+
+func Test_Inventory_DepositPackage_MoveIfStored(t *testing.T) {
+	type X struct {
+		move bool
+		elsewhere bool
+		fill bool
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"self move":       X{true, false, false, false},
+		"disabled":        X{false, false, false, true},
+		"other inventory": X{true, true, false, true},
+		"no room":         X{true, false, true, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			new_inventory := func() *Inventory {
+				inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1", "2"}})
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+				inv.Clock = ticking_clock()
+				inv.MoveIfStored = v.move
+				return inv
+			}
+
+			inv, other := new_inventory(), new_inventory()
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			source := inv
+			if v.elsewhere {
+				source = other
+			}
+			from, err := source.DepositPackage(pkg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if v.fill {
+				if _, err := inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{1,1,1}}); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+
+			to, err := inv.DepositPackage(pkg)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if err == nil && (to == from || pkg.StoredIn.Id != to) {
+				t.Errorf("Package was not moved: from %s to %s, stored in %s", from, to, pkg.StoredIn.Id)
+			}
+			if err != nil && pkg.StoredIn.Id != from {
+				t.Errorf("Failed move disturbed the package, now in %s", pkg.StoredIn.Id)
+			}
+			for _, x := range []*Inventory{inv, other} {
+				if ok, msg := ValidateInventory(t, x); !ok {
+					t.Errorf("Invalid inventory: %s", msg)
+				}
+				if err := x.CheckInvariants(); err != nil {
+					t.Errorf("Invariants violated: %s", err.Error())
+				}
+			}
+		})
+	}
+}
+
+func Test_Package_RequiredSize(t *testing.T) {
+	type X struct {
+		pkg Package
+		expected SizeSpec
+	}
+
+	tests := map[string]X{
+		"no margin":  X{Package{Size: SizeSpec{1,2,3}}, SizeSpec{3,2,1}},
+		"margin":     X{Package{Size: SizeSpec{1,2,3}, Margin: 1}, SizeSpec{4,3,2}},
+		"negative":   X{Package{Size: SizeSpec{1,2,3}, Margin: -1}, SizeSpec{3,2,1}},
+		"normalizes": X{Package{Size: SizeSpec{-1,2,0}, Margin: 2}, SizeSpec{4,3,2}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if size := v.pkg.RequiredSize(); size != v.expected {
+				t.Errorf("Expected %v, got %v", v.expected, size)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DepositPackage_Margin(t *testing.T) {
+	type X struct {
+		size SizeSpec
+		margin int
+		size_id LockerSize
+		err error
+	}
+
+	tests := map[string]X{
+		"no margin":    X{SizeSpec{2,1,1}, 0, 200, nil},
+		"padded":       X{SizeSpec{2,1,1}, 1, 400, nil},
+		"padded flat":  X{SizeSpec{1,1,0}, 1, 300, nil},
+		"nothing fits": X{SizeSpec{1,1,1}, 5, 0, ErrNoSuitableLocker},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			pkg := &Package{Id: "a", Size: v.size, Margin: v.margin}
+
+			size_id, err := inv.GetMostSuitableLockerSize(pkg.RequiredSize())
+			if err != v.err || size_id != v.size_id {
+				t.Errorf("Expected %d %v, got %d %v", v.size_id, v.err, size_id, err)
+			}
+
+			locker_id, err := inv.DepositPackage(pkg)
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err == nil && inv.Lockers[inv.LockersById[locker_id]].SizeId != v.size_id {
+				t.Errorf("Deposited into the wrong size: %d", inv.Lockers[inv.LockersById[locker_id]].SizeId)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DeepCopy(t *testing.T) {
+	inv := basic(t)
+	inv.Lockers[inv.LockersById["2"]].Configurable = []SizeSpec{SizeSpec{1,1,1}}
+	reference := basic(t)
+	reference.Lockers[reference.LockersById["2"]].Configurable = []SizeSpec{SizeSpec{1,1,1}}
+	for _, x := range []*Inventory{inv, reference} {
+		x.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+		x.DepositPackage(&Package{Id: "b", Size: SizeSpec{2,2,2}})
+	}
+	if len(inv.LockersByPackageId) != 2 {
+		t.Fatalf("Expected 2 stored packages, got %d", len(inv.LockersByPackageId))
+	}
+
+	c := inv.DeepCopy()
+	if eq, explanation := CompareInventories(t, c, inv); !eq {
+		t.Fatalf("Copy differs from the original: %s", explanation)
+	}
+
+	c.DepositPackage(&Package{Id: "copy", Size: SizeSpec{1,1,1}})
+	c.RetrievePackageById("a")
+
+	for _, l := range c.Lockers {
+		if l.Contents != nil {
+			l.Contents.Size = SizeSpec{9,9,9}
+			l.Contents.Margin = 9
+		}
+	}
+	c.Lockers[c.LockersById["2"]].Configurable[0] = SizeSpec{9,9,9}
+	for _, ctrl := range c.Control {
+		if len(ctrl.BiggerThan) != 0 {
+			ctrl.BiggerThan[0] = 0
+		}
+		if len(ctrl.SmallerThan) != 0 {
+			ctrl.SmallerThan[0] = 0
+		}
+		if len(ctrl.Lockers) != 0 {
+			ctrl.Lockers[0] = -1
+		}
+		ctrl.VirtualCapacity = -1
+	}
+	c.LockersById["new"] = 0
+	c.Sizes[SizeSpec{9,9,9}] = 9
+
+	if eq, explanation := CompareInventories(t, inv, reference); !eq {
+		t.Errorf("Changes to the copy leaked into the original: %s", explanation)
+	}
+	if inv.Lockers[inv.LockersById["2"]].Configurable[0] != (SizeSpec{1,1,1}) {
+		t.Errorf("Configurable sizes are shared with the copy")
+	}
+	for id, i := range inv.LockersByPackageId {
+		if pkg := inv.Lockers[i].Contents; pkg.Size == (SizeSpec{9,9,9}) || pkg.Margin != 0 {
+			t.Errorf("Package %s is shared with the copy", id)
+		}
+	}
+}
+
+func Test_Inventory_SentinelErrors(t *testing.T) {
+	type X struct {
+		action func(inv *Inventory) error
+		err error
+	}
+
+	tests := map[string]X{
+		"locker not empty": X{func(inv *Inventory) error {
+			l := &Locker{Contents: &Package{Id: "a"}}
+			return l.Put(&Package{Id: "b"})
+		}, ErrLockerNotEmpty},
+		"package already stored": X{func(inv *Inventory) error {
+			pkg := &Package{Id: "a"}
+			(&Locker{}).Put(pkg)
+			return (&Locker{}).Put(pkg)
+		}, ErrPackageAlreadyStored},
+		"locker empty": X{func(inv *Inventory) error {
+			_, err := (&Locker{}).Fetch()
+			return err
+		}, ErrLockerEmpty},
+		"no locker fits": X{func(inv *Inventory) error {
+			_, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{9,9,9}})
+			return err
+		}, ErrNoLockerFits},
+		"duplicate package": X{func(inv *Inventory) error {
+			inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+			_, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+			return err
+		}, ErrDuplicatePackageID},
+		"unknown package": X{func(inv *Inventory) error {
+			_, err := inv.RetrievePackageById("a")
+			return err
+		}, ErrUnknownPackageID},
+		"unknown locker": X{func(inv *Inventory) error {
+			_, err := inv.RetrievePackageByLockerId("nope")
+			return err
+		}, ErrUnknownPackageID},
+		"empty locker by id": X{func(inv *Inventory) error {
+			_, err := inv.RetrievePackageByLockerId("1")
+			return err
+		}, ErrLockerEmpty},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			err := v.action(basic(t))
+			if !errors.Is(err, v.err) {
+				t.Errorf("Expected %v, got %v", v.err, err)
+			}
+		})
+	}
+
+	if !errors.Is(ErrNoSuitableLocker, ErrNoLockerFits) {
+		t.Errorf("ErrNoSuitableLocker should match ErrNoLockerFits")
+	}
+}
+
+func Test_Inventory_PeekPackage(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+		SizeSpec{2,2,2}: []LockerID{"b1"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+	inv.StackPackage("b1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+	inv.StackPackage("b1", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+	before := inv.clone()
+	empty := LockerID("s1")
+	if inv.Lockers[inv.LockersById[empty]].Contents != nil {
+		empty = "s2"
+	}
+
+	type X struct {
+		by_package PackageID
+		by_locker LockerID
+		expected PackageID
+		err error
+	}
+
+	tests := map[string]X{
+		"by package":        X{"a", "", "a", nil},
+		"by package bottom": X{"b", "", "b", nil},
+		"by package top":    X{"c", "", "c", nil},
+		"unknown package":   X{"z", "", "", ErrUnknownPackageID},
+		"by locker":         X{"", inv.Lockers[inv.LockersByPackageId["a"]].Id, "a", nil},
+		"by locker stacked": X{"", "b1", "c", nil},
+		"empty locker":      X{"", empty, "", ErrLockerEmpty},
+		"unknown locker":    X{"", "nope", "", ErrUnknownLockerID},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			var pkg *Package
+			var err error
+			if v.by_locker == "" {
+				pkg, err = inv.PeekPackageById(v.by_package)
+			} else {
+				pkg, err = inv.PeekPackageByLockerId(v.by_locker)
+			}
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err == nil && (pkg.Id != v.expected || pkg.StoredIn == nil) {
+				t.Errorf("Expected stored package %s, got %+v", v.expected, pkg)
+			}
+			if eq, explanation := CompareInventories(t, inv, before); !eq {
+				t.Errorf("Peek changed the inventory: %s", explanation)
+			}
+		})
+	}
+
+	if pkg, _ := inv.RetrievePackageByLockerId("b1"); pkg == nil || pkg.Id != "c" {
+		t.Errorf("Peek did not report the package retrieved next: %+v", pkg)
+	}
+}
+
+func Test_Inventory_DepositIntoLocker(t *testing.T) {
+	setup := func(t *testing.T) *Inventory {
+		inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+			SizeSpec{1,1,1}: []LockerID{"s1", "s2", "s3"},
+			SizeSpec{2,2,2}: []LockerID{"b1"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		store_in(t, inv, "s1", &Package{Id: "stored", Size: SizeSpec{1,1,1}})
+		return inv
+	}
+
+	type X struct {
+		locker_id LockerID
+		pkg *Package
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"exact":          X{"s2", &Package{Id: "a", Size: SizeSpec{1,1,1}}, false},
+		"other exact":    X{"s3", &Package{Id: "a", Size: SizeSpec{1,1,1}}, false},
+		"larger":         X{"b1", &Package{Id: "a", Size: SizeSpec{1,1,1}}, false},
+		"rotated":        X{"b1", &Package{Id: "a", Size: SizeSpec{2,-1,2}}, false},
+		"too big":        X{"s2", &Package{Id: "a", Size: SizeSpec{2,1,1}}, true},
+		"margin":         X{"s2", &Package{Id: "a", Size: SizeSpec{1,1,1}, Margin: 1}, true},
+		"occupied":       X{"s1", &Package{Id: "a", Size: SizeSpec{1,1,1}}, true},
+		"unknown locker": X{"nope", &Package{Id: "a", Size: SizeSpec{1,1,1}}, true},
+		"duplicate id":   X{"s2", &Package{Id: "stored", Size: SizeSpec{1,1,1}}, true},
+		"already stored": X{"s2", &Package{Id: "a", Size: SizeSpec{1,1,1}, StoredIn: &Locker{}}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := setup(t)
+			before := inv.clone()
+
+			err := inv.DepositIntoLocker(v.locker_id, v.pkg)
+			if v.is_error {
+				if err == nil {
+					t.Fatalf("Expected error")
+				}
+				if eq, explain := CompareInventories(t, inv, before); !eq {
+					t.Errorf("Failed deposit changed the inventory:\n%s", explain)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			index := inv.LockersById[v.locker_id]
+			if inv.Lockers[index].Contents != v.pkg || inv.LockersByPackageId[v.pkg.Id] != index {
+				t.Errorf("Package not stored in %s", v.locker_id)
+			}
+			size_id := inv.Lockers[index].SizeId
+			if len(inv.Control[size_id].Lockers) != len(before.Control[size_id].Lockers) - 1 || inv.availablePosition(index) >= 0 {
+				t.Errorf("Locker not removed from the available lockers: %v", inv.Control[size_id].Lockers)
+			}
+
+			reference := inv.clone()
+			reference.ResetVirtualCapacityFromFreeLists()
+			if eq, explain := CompareInventories(t, inv, reference); !eq {
+				t.Errorf("Capacity inconsistent after deposit:\n%s", explain)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invalid inventory after deposit: %s", err.Error())
+			}
+		})
+	}
+
+	inv := setup(t)
+	inv.TakeSizeOutOfService(SizeSpec{2,2,2})
+	if err := inv.DepositIntoLocker("b1", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err == nil {
+		t.Errorf("Deposited into an out of service locker")
+	}
+}
+
+func Test_Inventory_DepositPackage_NoRotate(t *testing.T) {
+	type X struct {
+		size SizeSpec
+		no_rotate bool
+		expected SizeSpec
+		err error
+	}
+
+	tests := map[string]X{
+		"as stored":   X{SizeSpec{3,2,1}, true, SizeSpec{3,2,1}, nil},
+		"turned":      X{SizeSpec{1,2,3}, false, SizeSpec{3,2,1}, nil},
+		"cannot turn": X{SizeSpec{1,2,3}, true, SizeSpec{3,3,3}, nil},
+		"upside down": X{SizeSpec{-3,-2,-1}, true, SizeSpec{3,2,1}, nil},
+		"too tall":    X{SizeSpec{1,1,4}, true, SizeSpec{}, ErrNoLockerFits},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{3,2,1}: 1, SizeSpec{3,3,3}: 1})
+
+			var size_id LockerSize
+			var err error
+			if v.no_rotate {
+				size_id, err = inv.GetMostSuitableLockerSizeOriented(v.size)
+			} else {
+				size_id, err = inv.GetMostSuitableLockerSize(v.size.Normalize())
+			}
+			if err != v.err || (err == nil && inv.Control[size_id].Size != v.expected) {
+				t.Errorf("Expected %v %v, got %d %v", v.expected, v.err, size_id, err)
+			}
+
+			locker_id, err := inv.DepositPackage(&Package{Id: "a", Size: v.size, NoRotate: v.no_rotate})
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err == nil && inv.Control[inv.Lockers[inv.LockersById[locker_id]].SizeId].Size != v.expected {
+				t.Errorf("Deposited into the wrong size: %v", inv.Control[inv.Lockers[inv.LockersById[locker_id]].SizeId].Size)
+			}
+		})
+	}
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{3,2,1}: 1})
+	id := inv.Lockers[0].Id
+	if err := inv.DepositIntoLocker(id, &Package{Id: "a", Size: SizeSpec{1,2,3}, NoRotate: true}); err == nil {
+		t.Errorf("Deposited a package which may not be turned into a locker it only fits turned")
+	}
+	if err := inv.DepositIntoLocker(id, &Package{Id: "a", Size: SizeSpec{1,2,3}}); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func Test_Inventory_DepositPackage_NoRotate_Installed(t *testing.T) {
+	// a tall, narrow locker, given upright: a package which must stay upright fits,
+	// but one which must stay on its side does not, although both fit it turned.
+	type X struct {
+		size SizeSpec
+		no_rotate bool
+		err error
+	}
+
+	tests := map[string]X{
+		"upright":       X{SizeSpec{1,1,3}, true, nil},
+		"on its side":   X{SizeSpec{3,1,1}, true, ErrNoLockerFits},
+		"turned":        X{SizeSpec{3,1,1}, false, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,3}: 1})
+			if _, err := inv.DepositPackage(&Package{Id: "a", Size: v.size, NoRotate: v.no_rotate}); err != v.err {
+				t.Errorf("Expected error %v, got %v", v.err, err)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DepositPackage_Weight(t *testing.T) {
+	type X struct {
+		weight int
+		expected SizeSpec
+		err error
+	}
+
+	tests := map[string]X{
+		"weightless": X{0, SizeSpec{1,1,1}, nil},
+		"at limit":   X{5, SizeSpec{1,1,1}, nil},
+		"over limit": X{6, SizeSpec{2,2,2}, nil},
+		"too heavy":  X{11, SizeSpec{}, ErrNoLockerFits},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1})
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{1,1,1}], 5)
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{2,2,2}], 10)
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{3,3,3}], 10)
+
+			locker_id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}, Weight: v.weight})
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err == nil && inv.Control[inv.Lockers[inv.LockersById[locker_id]].SizeId].Size != v.expected {
+				t.Errorf("Deposited into the wrong size: %v", inv.Control[inv.Lockers[inv.LockersById[locker_id]].SizeId].Size)
+			}
+		})
+	}
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+	if err := inv.SetMaxWeight(inv.Sizes[SizeSpec{1,1,1}], -1); err == nil {
+		t.Errorf("Expected error for a negative limit")
+	}
+	if err := inv.SetMaxWeight(LockerSize(99), 1); err == nil {
+		t.Errorf("Expected error for an unknown size")
+	}
+	inv.SetMaxWeight(inv.Sizes[SizeSpec{1,1,1}], 5)
+	if err := inv.DepositIntoLocker(inv.Lockers[0].Id, &Package{Id: "a", Size: SizeSpec{1,1,1}, Weight: 6}); err == nil {
+		t.Errorf("Deposited a package into a locker it is too heavy for")
+	}
+}
+
+func Test_Inventory_OnDeposit_OnRetrieve(t *testing.T) {
+	type event struct {
+		hook string
+		pkg *Package
+		locker LockerID
+	}
+
+	inv := basic(t)
+	var events []event
+	inv.OnDeposit = func(pkg *Package, locker LockerID) {
+		events = append(events, event{"deposit", pkg, locker})
+	}
+	inv.OnRetrieve = func(pkg *Package, locker LockerID) {
+		events = append(events, event{"retrieve", pkg, locker})
+	}
+
+	a := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+	locker_a, err := inv.DepositPackage(a)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	k := &Package{Id: "k", Size: SizeSpec{1,1,1}}
+	locker_k, _, err := inv.DepositPackageIdempotent("key", k)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, _, err := inv.DepositPackageIdempotent("key", k); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "huge", Size: SizeSpec{9,9,9}}); err == nil {
+		t.Fatalf("Expected error depositing an oversized package")
+	}
+	b := &Package{Id: "b", Size: SizeSpec{2,2,2}}
+	if err := inv.DepositIntoLocker("4", b); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := inv.RetrievePackageById("a"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := inv.RetrievePackageById("a"); err == nil {
+		t.Fatalf("Expected error retrieving a package twice")
+	}
+
+	expected := []event{
+		event{"deposit", a, locker_a},
+		event{"deposit", k, locker_k},
+		event{"deposit", b, "4"},
+		event{"retrieve", a, locker_a},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Event %d: expected %v, got %v", i, expected[i], events[i])
+		}
+	}
+
+	bulk := bulk_fixture(t)
+	var stacked []LockerID
+	bulk.OnDeposit = func(pkg *Package, locker LockerID) {
+		stacked = append(stacked, locker)
+	}
+	bulk.StackPackage("bulk", &Package{Id: "x", Size: SizeSpec{1,1,1}})
+	bulk.StackPackage("bulk", &Package{Id: "y", Size: SizeSpec{1,1,1}})
+	if !reflect.DeepEqual(stacked, []LockerID{"bulk", "bulk"}) {
+		t.Errorf("Expected two deposits into bulk, got %v", stacked)
+	}
+
+	c := inv.DeepCopy()
+	events = nil
+	c.RetrievePackageById("b")
+	if len(events) != 0 {
+		t.Errorf("Hooks fired on a copy: %v", events)
+	}
+
+	quiet := basic(t)
+	if _, err := quiet.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := quiet.RetrievePackageById("a"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+}
+
+func Test_Inventory_DepositBatch(t *testing.T) {
+	inv := basic(t)
+	pkgs := []*Package{
+		&Package{Id: "a", Size: SizeSpec{1,1,1}},
+		&Package{Id: "a", Size: SizeSpec{1,1,1}},
+		&Package{Id: "huge", Size: SizeSpec{9,9,9}},
+		&Package{Id: "b", Size: SizeSpec{2,2,2}},
+	}
+	expected_errs := []error{nil, ErrDuplicatePackageID, ErrNoLockerFits, nil}
+
+	ids, errs := inv.DepositBatch(context.Background(), pkgs)
+	if len(ids) != len(pkgs) || len(errs) != len(pkgs) {
+		t.Fatalf("Expected %d results, got %d ids and %d errors", len(pkgs), len(ids), len(errs))
+	}
+	for i := range pkgs {
+		if errs[i] != expected_errs[i] {
+			t.Errorf("Package %d: expected error %v, got %v", i, expected_errs[i], errs[i])
+		}
+		if (ids[i] != "") != (errs[i] == nil) {
+			t.Errorf("Package %d: unexpected locker ID %q with error %v", i, ids[i], errs[i])
+		}
+	}
+	for _, i := range []int{0, 3} {
+		if location, ok := inv.GetPackageLocation(pkgs[i].Id); !ok || location != ids[i] {
+			t.Errorf("Package %s stored in %q, expected %q", pkgs[i].Id, location, ids[i])
+		}
+	}
+	ValidateInventory(t, inv)
+
+	// a batch cancelled part way keeps what was placed before
+	ctx, cancel := context.WithCancel(context.Background())
+	inv = basic(t)
+	calls := 0
+	inv.OnDeposit = func(pkg *Package, locker LockerID) {
+		calls += 1
+		if calls == 2 {
+			cancel()
+		}
+	}
+	pkgs = []*Package{
+		&Package{Id: "a", Size: SizeSpec{1,1,1}},
+		&Package{Id: "b", Size: SizeSpec{1,1,1}},
+		&Package{Id: "c", Size: SizeSpec{1,1,1}},
+		&Package{Id: "d", Size: SizeSpec{1,1,1}},
+	}
+	ids, errs = inv.DepositBatch(ctx, pkgs)
+	for i := range pkgs {
+		if i < 2 && (errs[i] != nil || ids[i] == "") {
+			t.Errorf("Package %d: expected a placement, got %q (%v)", i, ids[i], errs[i])
+		} else if i >= 2 && (errs[i] != context.Canceled || ids[i] != "") {
+			t.Errorf("Package %d: expected cancellation, got %q (%v)", i, ids[i], errs[i])
+		}
+	}
+	if len(inv.LockersByPackageId) != 2 {
+		t.Errorf("Expected 2 packages to stay stored, got %d", len(inv.LockersByPackageId))
+	}
+
+	ids, errs = inv.DepositBatch(ctx, nil)
+	if len(ids) != 0 || len(errs) != 0 {
+		t.Errorf("Expected no results for an empty batch, got %v %v", ids, errs)
+	}
+}
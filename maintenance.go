@@ -0,0 +1,677 @@
+package lockers
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Adds count new, empty lockers of the given size to the inventory, such as when a
+// facility is restocked, and returns their IDs. IDs come from the generator the inventory
+// was created with, if any (see NewInventoryWithIDGen), and are random UUIDs otherwise.
+// If the size is new to the inventory, it is added to the containment graph. Either
+// way, the new lockers are available at once, and count towards the capacity of their
+// size and every size they fit inside. Nothing is added if count is not positive.
+func (inv *Inventory) AddLockers(size SizeSpec, count int) []LockerID {
+	if count <= 0 {
+		return nil
+	}
+
+	_, known := inv.Sizes[size.Normalize()]
+	size_id := inv.addSize(size, count)
+	ids := make([]LockerID, count)
+	for i := range ids {
+		ids[i] = inv.nextLockerId()
+		inv.addLocker(size_id, ids[i])
+	}
+
+	// growing the locker list may have moved it.
+	inv.RepairBackPointers()
+	if known {
+		inv.adjustCapacity(size_id, count)
+	} else {
+		inv.linkSizes()
+		inv.ResetVirtualCapacityFromFreeLists()
+	}
+	return ids
+}
+
+// Merges another inventory into this one, taking over all of its lockers, along with
+// any packages stored in them. Sizes are matched up by dimensions, and new sizes are
+// added to the containment graph. Returns an error without changing either inventory
+// if the two have any locker IDs or package IDs in common. After a successful merge
+// the other inventory's packages belong to this one, so it should be discarded.
+func (inv *Inventory) Merge(other *Inventory) error {
+	return inv.MergeDedupe(other, nil)
+}
+
+// Merges another inventory into this one, as Merge, except that a package ID which is
+// stored in both is resolved by calling onConflict with this inventory's package and
+// the other's, rather than returned as an error. Whichever of the two onConflict
+// returns keeps its locker, and the other package is removed, freeing its locker.
+// onConflict is called for every conflict before anything is changed, and an error
+// is returned with no changes made if it returns anything else. Locker ID conflicts
+// are always an error.
+func (inv *Inventory) MergeDedupe(other *Inventory, onConflict func(a, b *Package) *Package) error {
+	for id := range other.LockersById {
+		if _, ok := inv.LockersById[id]; ok {
+			return errors.New("Duplicate locker ID")
+		}
+	}
+	for id := range other.doorGroups {
+		if _, ok := inv.doorGroups[id]; ok {
+			return errors.New("Duplicate door group ID")
+		}
+	}
+
+	// decide every conflict up front, so that a bad resolution changes nothing
+	losers_here := make(map[PackageID]int)
+	losers_there := make(map[PackageID]bool)
+	for pkg_id, other_index := range other.LockersByPackageId {
+		index, ok := inv.LockersByPackageId[pkg_id]
+		if !ok { continue }
+		if onConflict == nil {
+			return ErrDuplicatePackageID
+		}
+
+		a, b := inv.Lockers[index].find(pkg_id), other.Lockers[other_index].find(pkg_id)
+		switch onConflict(a, b) {
+		case a:
+			losers_there[pkg_id] = true
+		case b:
+			losers_here[pkg_id] = index
+		default:
+			return errors.New("Conflict resolved to neither package")
+		}
+	}
+
+	for pkg_id, index := range losers_here {
+		inv.Lockers[index].toTop(pkg_id)
+		inv.take(index)
+	}
+
+	merged_index := make([]int, len(other.Lockers))
+	for i := range other.Lockers {
+		l := other.Lockers[i]
+		size_id := inv.addSize(other.Control[l.SizeId].installed(), 0)
+		index := inv.addLocker(size_id, l.Id)
+		merged_index[i] = index
+
+		l.SizeId = size_id
+		l.ref = inv.Lockers[index].ref
+		if offered, ok := other.Control[l.TightestOffered]; ok {
+			l.TightestOffered = inv.addSize(offered.installed(), 0)
+		}
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			if p == nil || !losers_there[p.Id] { continue }
+			l.toTop(p.Id)
+			l.Fetch()
+		}
+		l.lastRetrieved = nil
+		inv.Lockers[index] = l
+
+		// addLocker made the locker available; occupied and out of service ones are not.
+		if l.Contents != nil || l.OutOfService {
+			inv.allocateAt(size_id, inv.availablePosition(index))
+		}
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			if p == nil { continue }
+			inv.forgetRetrieved(p, index)
+			inv.LockersByPackageId[p.Id] = index
+		}
+	}
+
+	// door groups come along too, still held if they were.
+	for id, g := range other.doorGroups {
+		x := &doorGroup{members: make([]int, len(g.members)), held: g.held}
+		for i, index := range g.members {
+			x.members[i] = merged_index[index]
+		}
+		if inv.doorGroups == nil {
+			inv.doorGroups = make(map[DoorGroupID]*doorGroup)
+		}
+		inv.doorGroups[id] = x
+		if !x.held { continue }
+		for _, index := range x.members {
+			if position := inv.availablePosition(index); position >= 0 {
+				inv.allocateAt(inv.Lockers[index].SizeId, position)
+			}
+		}
+	}
+
+	inv.RepairBackPointers()
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return nil
+}
+
+// finds the position of a locker in its size's list of available lockers, or -1 if
+// it is not available.
+func (inv *Inventory) availablePosition(locker_index int) int {
+	ctrl := inv.Control[inv.Lockers[locker_index].SizeId]
+	for position, x := range ctrl.Lockers {
+		if x == locker_index {
+			return position
+		}
+	}
+	return -1
+}
+
+// Moves a stored package into a specific empty locker, such as when staff reassign it
+// to a particular door. The move is atomic: nothing changes if the package or locker
+// is unknown, or the locker is occupied, unavailable, or too small for the package.
+// The locker's purpose and cleanliness are not checked, since staff are choosing it.
+func (inv *Inventory) MovePackageToLocker(pkgId PackageID, lockerId LockerID) error {
+	src_index, ok := inv.LockersByPackageId[pkgId]
+	if !ok {
+		return ErrUnknownPackageID
+	}
+	dst_index, ok := inv.LockersById[lockerId]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+
+	dst := &inv.Lockers[dst_index]
+	if dst.Contents != nil {
+		return ErrLockerNotEmpty
+	}
+	pkg := inv.Lockers[src_index].Contents
+	for _, p := range inv.Lockers[src_index].Stacked {
+		if p.Id == pkgId {
+			pkg = p
+		}
+	}
+	if !inv.packagePlacement(pkg, PurposeBoth).fits(inv.Control[dst.SizeId]) {
+		return errors.New("Package does not fit in locker")
+	}
+	if !inv.Control[dst.SizeId].carries(pkg.Weight) {
+		return errors.New("Package is too heavy for locker")
+	}
+	position := inv.availablePosition(dst_index)
+	if position < 0 || inv.Control[dst.SizeId].Inactive {
+		return errors.New("Locker is not available")
+	}
+
+	inv.Lockers[src_index].toTop(pkgId)
+	inv.moveTo(src_index, dst.SizeId, position)
+	return nil
+}
+
+// Moves a stored package out of its locker into another one chosen as for a new
+// deposit, such as when its locker is about to be cleaned, and returns the new locker's
+// ID. The source locker is never chosen, and the package keeps to lockers with the
+// purpose of the one it is in. Moving a package does not change how many lockers are
+// in use, so MaxOccupancy does not apply. The move is atomic: if no other locker is
+// available for the package, nothing changes.
+func (inv *Inventory) TransferPackage(id PackageID) (LockerID, error) {
+	src_index, ok := inv.LockersByPackageId[id]
+	if !ok {
+		return "", ErrUnknownPackageID
+	}
+	src := &inv.Lockers[src_index]
+	pkg := src.find(id)
+
+	p := inv.packagePlacement(pkg, src.Purpose)
+	p.exclude = map[LockerID]bool{src.Id: true}
+	if pkg.PreferTight {
+		p.strategy = TightestFitStrategy
+	}
+	size_id, err := inv.selectSize(p)
+	if err != nil {
+		inv.logf("lockers: cannot transfer package %s: %s", id, err.Error())
+		return "", err
+	}
+
+	src.toTop(id)
+	dst_index := inv.moveTo(src_index, size_id, inv.next(inv.Control[size_id], p))
+	inv.logf("lockers: transferred package %s from locker %s to locker %s", id, inv.Lockers[src_index].Id, inv.Lockers[dst_index].Id)
+	return inv.Lockers[dst_index].Id, nil
+}
+
+// Moves packages out of lockers larger than they need and into smaller available ones,
+// such as after a busy period when small lockers were full for a while, and returns
+// the IDs of the lockers which were emptied, in the order they were. The moves are the
+// ones RelocationPlan would propose, so packages only go where they could be deposited,
+// keeping to their orientation and weight limits, and bulk lockers are left alone.
+// The emptied lockers need cleaning before they are used again, so calling Defragment
+// again straight away moves nothing.
+func (inv *Inventory) Defragment() []LockerID {
+	var freed []LockerID
+	for _, move := range inv.relocate() {
+		inv.logf("lockers: moved package %s from locker %s to locker %s", move.Package, move.From, move.To)
+		freed = append(freed, move.From)
+	}
+	return freed
+}
+
+// Checks that the inventory's internal bookkeeping agrees with its lockers, returning
+// an error describing the first inconsistency found, or nil if there are none. This
+// covers the locker and package indices, the free lists, and the back pointers from
+// stored packages to their lockers (see RepairBackPointers). It is meant for auditing
+// state after manual changes, and runs in O(L) for L lockers.
+func (inv *Inventory) CheckInvariants() error {
+	occupied := 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if _, ok := inv.Control[l.SizeId]; !ok {
+			return errors.New("Locker has unknown size")
+		}
+		if index, ok := inv.LockersById[l.Id]; !ok || index != i {
+			return errors.New("Locker ID not indexed to its locker")
+		}
+		if l.Contents == nil { continue }
+
+		for j := -1; j < len(l.Stacked); j++ {
+			p := l.Contents
+			if j >= 0 {
+				p = l.Stacked[j]
+			}
+			occupied += 1
+			if p.StoredIn != l {
+				return errors.New("Package does not point back at its locker")
+			}
+			if index, ok := inv.LockersByPackageId[p.Id]; !ok || index != i {
+				return errors.New("Package ID not indexed to its locker")
+			}
+		}
+	}
+
+	if len(inv.LockersById) != len(inv.Lockers) {
+		return errors.New("Locker ID index has extra entries")
+	}
+	if len(inv.LockersByPackageId) != occupied {
+		return errors.New("Package ID index has extra entries")
+	}
+
+	for size_id, ctrl := range inv.Control {
+		for _, index := range ctrl.Lockers {
+			if index < 0 || index >= len(inv.Lockers) {
+				return errors.New("Free list entry out of range")
+			}
+			if inv.Lockers[index].SizeId != size_id {
+				return errors.New("Free list entry has the wrong size")
+			}
+			if inv.Lockers[index].Contents != nil {
+				return errors.New("Free list entry is occupied")
+			}
+			if inv.Lockers[index].OutOfService {
+				return errors.New("Free list entry is out of service")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks the whole inventory for corruption, such as after loading it from disk or
+// making changes by hand, returning an error describing the first inconsistency found,
+// or nil if there are none. On top of CheckInvariants, this checks that the Sizes and
+// Control maps describe the same sizes under the same IDs. It allocates nothing, and
+// runs in O(L + S) for L lockers and S sizes, so it is cheap enough to run often.
+func (inv *Inventory) Validate() error {
+	if len(inv.Sizes) != len(inv.Control) {
+		return errors.New("Sizes and Control have different lengths")
+	}
+	for size_id, ctrl := range inv.Control {
+		if ctrl == nil {
+			return errors.New("Locker size has no control")
+		}
+		if ctrl.SizeId != size_id {
+			return errors.New("Locker size control has the wrong ID")
+		}
+		if id, ok := inv.Sizes[ctrl.Size]; !ok || id != size_id {
+			return errors.New("Locker size not indexed to its control")
+		}
+	}
+
+	return inv.CheckInvariants()
+}
+
+// Rebuilds every index derived from the inventory's lockers, such as after editing
+// them by hand or loading them from an old format: LockersById, LockersByPackageId,
+// the available lockers of each size, the containment graph between sizes, and their
+// VirtualCapacity, along with the back pointers of stored packages and the members
+// of door groups. inv.Lockers and inv.Sizes are trusted, and everything else is
+// regenerated from them. A size's settings, such as its MaxWeight, are kept if its
+// control is still in place. An empty locker is made available unless it is out of
+// service or in a held door group; available lockers are listed in the order they
+// appear in inv.Lockers. Problems in inv.Lockers itself, such as duplicate locker IDs
+// or lockers of unknown size, cannot be repaired this way, and are left for Validate
+// to report.
+func (inv *Inventory) Reindex() {
+	control := make(map[LockerSize]*LockerControlSpec, len(inv.Sizes))
+	for size, size_id := range inv.Sizes {
+		ctrl := inv.Control[size_id]
+		if ctrl == nil {
+			ctrl = &LockerControlSpec{}
+		}
+		ctrl.SizeId, ctrl.Size = size_id, size
+		ctrl.Lockers = nil
+		control[size_id] = ctrl
+	}
+	inv.Control = control
+
+	inv.LockersById = make(map[LockerID]int, len(inv.Lockers))
+	inv.LockersByPackageId = make(map[PackageID]int, len(inv.Lockers))
+	inv.refs = make(map[LockerRef]int, len(inv.Lockers))
+	for _, g := range inv.doorGroups {
+		g.members = nil
+	}
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		inv.LockersById[l.Id] = i
+		if l.ref != (LockerRef{}) {
+			inv.refs[l.ref] = i
+		}
+		if l.DoorGroup != "" {
+			if inv.doorGroups == nil {
+				inv.doorGroups = make(map[DoorGroupID]*doorGroup)
+			}
+			g, ok := inv.doorGroups[l.DoorGroup]
+			if !ok {
+				g = &doorGroup{}
+				inv.doorGroups[l.DoorGroup] = g
+			}
+			g.members = append(g.members, i)
+		}
+		if l.Contents == nil { continue }
+
+		inv.LockersByPackageId[l.Contents.Id] = i
+		for _, p := range l.Stacked {
+			inv.LockersByPackageId[p.Id] = i
+		}
+	}
+
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		ctrl, ok := inv.Control[l.SizeId]
+		if !ok || l.Contents != nil || l.OutOfService { continue }
+		if g, ok := inv.doorGroups[l.DoorGroup]; ok && g.held { continue }
+		ctrl.Lockers = append(ctrl.Lockers, i)
+	}
+
+	inv.RepairBackPointers()
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+}
+
+// Stops a size of locker from taking any new packages, for phasing it out while
+// packages are still inside. Packages already stored in it can be retrieved as usual,
+// and lockers emptied this way stay out of use. The size's available lockers no longer
+// count towards the VirtualCapacity of any size. Unlike removing the size, this can be
+// undone with ReactivateSize. Returns an error if the size is not known; deactivating
+// an inactive size does nothing.
+func (inv *Inventory) DeactivateSize(size SizeSpec) error {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return ErrUnknownLockerSize
+	}
+	ctrl := inv.Control[size_id]
+	if ctrl.Inactive {
+		return nil
+	}
+
+	inv.adjustCapacity(size_id, -len(ctrl.Lockers))
+	ctrl.Inactive = true
+	return nil
+}
+
+// Returns a size deactivated with DeactivateSize to use, along with any of its lockers
+// which were emptied in the meantime. Returns an error if the size is not known;
+// reactivating an active size does nothing.
+func (inv *Inventory) ReactivateSize(size SizeSpec) error {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return ErrUnknownLockerSize
+	}
+	ctrl := inv.Control[size_id]
+	if !ctrl.Inactive {
+		return nil
+	}
+
+	ctrl.Inactive = false
+	inv.adjustCapacity(size_id, len(ctrl.Lockers))
+	return nil
+}
+
+// Removes an empty locker from the inventory for good, such as when it is damaged
+// beyond repair. Lockers which are out of service may be removed. If the locker was
+// available, the capacity of its size and every size it can hold packages for goes
+// down by one. Lockers are stored by index, so removing one moves the last locker into
+// its place; LockerRefs keep referring to the same lockers, but indices do not.
+// Returns an error without changing anything if the locker is unknown, occupied or in
+// a door group.
+func (inv *Inventory) RemoveLocker(id LockerID) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	if l.Contents != nil {
+		return ErrLockerNotEmpty
+	}
+	if l.DoorGroup != "" {
+		return errors.New("Locker is in a door group")
+	}
+
+	size_id, available := l.SizeId, inv.availablePosition(index) >= 0
+	inv.removeLocker(index)
+	if available {
+		inv.adjustCapacity(size_id, -1)
+	}
+	return nil
+}
+
+// Reconfigures an empty locker into several smaller ones, such as by adding shelves.
+// The locker is removed, and a new locker is added for each of the given sizes, all of
+// which must fit inside the original. Whether they fit inside it all at once is up to
+// the caller. The new lockers are given IDs made from the original's, with a suffix
+// numbering them from 1 (so "A" splits into "A.1", "A.2" and so on), and these are
+// returned in the order of the sizes. Sizes which are new to the inventory are added
+// to it, and capacities are recomputed.
+//
+// Returns an error without changing anything if the locker is unknown, occupied, not
+// available or in a door group, if any size does not fit inside it, or if any of the
+// new IDs are already in use.
+func (inv *Inventory) SplitLocker(id LockerID, newSizes []SizeSpec) ([]LockerID, error) {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return nil, ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	if l.Contents != nil {
+		return nil, ErrLockerNotEmpty
+	}
+	if inv.availablePosition(index) < 0 {
+		return nil, errors.New("Locker is not available")
+	}
+	if l.DoorGroup != "" {
+		return nil, errors.New("Locker is in a door group")
+	}
+	if len(newSizes) == 0 {
+		return nil, errors.New("No sizes to split locker into")
+	}
+
+	original := inv.Control[l.SizeId].Size
+	ids := make([]LockerID, len(newSizes))
+	for i, size := range newSizes {
+		if !original.Contains(size.Normalize()) {
+			return nil, errors.New("Size does not fit inside locker")
+		}
+		ids[i] = id + LockerID("." + strconv.Itoa(i + 1))
+		if _, ok := inv.LockersById[ids[i]]; ok {
+			return nil, errors.New("Duplicate locker ID")
+		}
+	}
+
+	purpose, zone := l.Purpose, l.Zone
+	inv.removeLocker(index)
+	for i, size := range newSizes {
+		new_index := inv.addLocker(inv.addSize(size, 1), ids[i])
+		inv.Lockers[new_index].Purpose = purpose
+		inv.Lockers[new_index].Zone = zone
+	}
+
+	inv.RepairBackPointers()
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return ids, nil
+}
+
+// Takes every available locker of a size out of service at once, such as for a recall
+// of a faulty locker model. They are removed from the pool of available lockers, and
+// capacity is updated to match. Occupied lockers, and others which are not available
+// at the moment, are skipped. Returns how many lockers were taken out of service, or
+// an error if the size is not known. See RestoreSize to put them back.
+func (inv *Inventory) TakeSizeOutOfService(size SizeSpec) (int, error) {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return 0, ErrUnknownLockerSize
+	}
+
+	ctrl := inv.Control[size_id]
+	affected := len(ctrl.Lockers)
+	for _, index := range ctrl.Lockers {
+		inv.Lockers[index].OutOfService = true
+	}
+	ctrl.Lockers = ctrl.Lockers[:0]
+	inv.adjustCapacity(size_id, -affected)
+	return affected, nil
+}
+
+// Returns every empty out of service locker of a size to service, making them
+// available again, and updates capacity to match. Returns how many lockers were
+// returned to service, or an error if the size is not known.
+func (inv *Inventory) RestoreSize(size SizeSpec) (int, error) {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return 0, ErrUnknownLockerSize
+	}
+
+	affected := 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.SizeId != size_id || !l.OutOfService || l.Contents != nil { continue }
+
+		l.OutOfService = false
+		affected += 1
+		if g, ok := inv.doorGroups[l.DoorGroup]; ok && g.held { continue }
+		inv.DeallocateLocker(i)
+	}
+	return affected, nil
+}
+
+// Takes a single locker out of service, such as when its door jams, or returns it to
+// service, without losing track of its contents. An empty locker taken out of service
+// stops being available, and capacity is updated to match. An occupied one keeps its
+// package, which can still be retrieved, but the locker is not made available again
+// afterwards. An empty locker returned to service becomes available again, unless it
+// is held by its door group. Returns ErrUnknownLockerID if the locker is not known;
+// setting the status a locker already has does nothing.
+func (inv *Inventory) SetLockerStatus(id LockerID, outOfService bool) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	if l.OutOfService == outOfService {
+		return nil
+	}
+
+	l.OutOfService = outOfService
+	if outOfService {
+		if position := inv.availablePosition(index); position >= 0 {
+			inv.allocateAt(l.SizeId, position)
+		}
+		inv.logf("lockers: locker %s taken out of service", id)
+		return nil
+	}
+
+	inv.logf("lockers: locker %s returned to service", id)
+	if l.Contents != nil { return nil }
+	if g, ok := inv.doorGroups[l.DoorGroup]; ok && g.held { return nil }
+	if inv.availablePosition(index) < 0 {
+		inv.DeallocateLocker(index)
+	}
+	return nil
+}
+
+// Moves an empty locker into another size class which the inventory already has,
+// such as after it has been measured again or refitted. If the locker is available,
+// capacities change to match: the old size and every size it can hold packages for
+// lose one, and the new size and every size it can hold packages for gain one, so
+// sizes on both sides do not change, and see no capacity alarm. Unlike
+// ReconfigureLocker, any known size may be chosen, and lockers which are out of
+// service or held by a door group may be moved too. Returns an error without changing
+// anything if the locker is unknown or occupied, or the size is not known. Moving a
+// locker to the size it already has does nothing.
+func (inv *Inventory) ResizeLocker(id LockerID, newSize SizeSpec) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	if l.Contents != nil {
+		return ErrLockerNotEmpty
+	}
+	size_id, ok := inv.Sizes[newSize.Normalize()]
+	if !ok {
+		return ErrUnknownLockerSize
+	}
+	if size_id == l.SizeId {
+		return nil
+	}
+
+	old_id := l.SizeId
+	position := inv.availablePosition(index)
+	l.SizeId = size_id
+	if position >= 0 {
+		ctrl := inv.Control[old_id]
+		ctrl.Lockers = append(ctrl.Lockers[:position], ctrl.Lockers[position + 1:]...)
+		inv.Control[size_id].Lockers = append(inv.Control[size_id].Lockers, index)
+		inv.moveCapacity(old_id, size_id)
+	}
+	return nil
+}
+
+// Sets an adjustable locker to one of the sizes listed in its Configurable field, such
+// as after moving its shelves. The locker moves to the size class for the new size,
+// which is added to the inventory if it is new, and the containment graph and
+// capacities are recomputed. Returns an error without changing anything if the locker
+// is unknown, occupied or not available, or the target is not one of its sizes.
+// Setting a locker to the size it already has does nothing.
+func (inv *Inventory) ReconfigureLocker(id LockerID, target SizeSpec) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	l := &inv.Lockers[index]
+	if l.Contents != nil {
+		return ErrLockerNotEmpty
+	}
+
+	target = target.Normalize()
+	allowed := false
+	for _, size := range l.Configurable {
+		allowed = allowed || size.Normalize() == target
+	}
+	if !allowed {
+		return errors.New("Locker cannot be set to that size")
+	}
+	if inv.Control[l.SizeId].Size == target {
+		return nil
+	}
+
+	position := inv.availablePosition(index)
+	if position < 0 {
+		return errors.New("Locker is not available")
+	}
+
+	ctrl := inv.Control[l.SizeId]
+	ctrl.Lockers = append(ctrl.Lockers[:position], ctrl.Lockers[position + 1:]...)
+	l.SizeId = inv.addSize(target, 1)
+	inv.Control[l.SizeId].Lockers = append(inv.Control[l.SizeId].Lockers, index)
+
+	inv.linkSizes()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return nil
+}
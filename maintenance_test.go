@@ -0,0 +1,1138 @@
+package lockers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// stores a package directly into a specific locker, for building fixtures.
+func store_in(t *testing.T, inv *Inventory, id LockerID, pkg *Package) {
+	t.Helper()
+
+	index := inv.LockersById[id]
+	ctrl := inv.Control[inv.Lockers[index].SizeId]
+	for position, x := range ctrl.Lockers {
+		if x != index { continue }
+		if err := inv.Lockers[index].Put(pkg); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		inv.allocateAt(ctrl.SizeId, position)
+		inv.LockersByPackageId[pkg.Id] = index
+		return
+	}
+	t.Fatalf("Locker %s is not available", id)
+}
+
+// builds an inventory with the given lockers, and a package stored in each locker in
+// stored, with the package id given by the map value.
+func with_packages(t *testing.T, ids map[SizeSpec][]LockerID, stored map[LockerID]PackageID) *Inventory {
+	t.Helper()
+
+	inv, err := NewInventoryWithIDs(ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for locker_id, pkg_id := range stored {
+		store_in(t, inv, locker_id, &Package{Id: pkg_id, Size: SizeSpec{1,1,1}})
+	}
+	return inv
+}
+
+func Test_Inventory_Merge(t *testing.T) {
+	here := map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a1", "a2"}}
+	there := map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"b1"}, SizeSpec{2,2,2}: []LockerID{"b2"}}
+	merged := map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a1", "a2", "b1"}, SizeSpec{2,2,2}: []LockerID{"b2"}}
+
+	keep_a := func(a, b *Package) *Package { return a }
+	keep_b := func(a, b *Package) *Package { return b }
+	keep_neither := func(a, b *Package) *Package { return nil }
+
+	type X struct {
+		other *Inventory
+		resolve func(a, b *Package) *Package
+		result map[LockerID]PackageID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"disjoint": X{with_packages(t, there, map[LockerID]PackageID{"b2": "y"}), nil,
+			map[LockerID]PackageID{"a1": "x", "b2": "y"}, false},
+		"dupe-locker": X{with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a2"}}, nil), keep_a,
+			nil, true},
+		"dupe-package-strict": X{with_packages(t, there, map[LockerID]PackageID{"b2": "x"}), nil,
+			nil, true},
+		"dupe-package-keep-here": X{with_packages(t, there, map[LockerID]PackageID{"b2": "x", "b1": "y"}), keep_a,
+			map[LockerID]PackageID{"a1": "x", "b1": "y"}, false},
+		"dupe-package-keep-there": X{with_packages(t, there, map[LockerID]PackageID{"b2": "x", "b1": "y"}), keep_b,
+			map[LockerID]PackageID{"b2": "x", "b1": "y"}, false},
+		"dupe-package-bad-resolution": X{with_packages(t, there, map[LockerID]PackageID{"b2": "x"}), keep_neither,
+			nil, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := with_packages(t, here, map[LockerID]PackageID{"a1": "x"})
+			err := inv.MergeDedupe(v.other, v.resolve)
+			if err != nil && v.is_error {
+				unchanged := with_packages(t, here, map[LockerID]PackageID{"a1": "x"})
+				eq, explain := CompareInventories(t, inv, unchanged)
+				if !eq {
+					t.Errorf("Failed merge changed the inventory:\n%+v\n%s", inv, explain)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Expected error, but completed successfully")
+				return
+			}
+
+			eq, explain := CompareInventories(t, inv, with_packages(t, merged, v.result))
+			if !eq {
+				t.Errorf("Incorrect merge output:\n%+v\n%s", inv, explain)
+			}
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+			for locker_id, pkg_id := range v.result {
+				l := &inv.Lockers[inv.LockersById[locker_id]]
+				if l.Contents == nil || l.Contents.Id != pkg_id || l.Contents.StoredIn != l {
+					t.Errorf("Package %s not correctly stored in %s", pkg_id, locker_id)
+				}
+				if inv.LockersByPackageId[pkg_id] != inv.LockersById[locker_id] {
+					t.Errorf("Package %s not correctly indexed", pkg_id)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_Merge_Strict(t *testing.T) {
+	inv := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a1"}}, nil)
+	other := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,2}: []LockerID{"b1"}}, map[LockerID]PackageID{"b1": "x"})
+	if err := inv.Merge(other); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if len(inv.Lockers) != 2 || len(inv.Sizes) != 2 || inv.Control[inv.Sizes[SizeSpec{1,1,1}]].VirtualCapacity != 1 {
+		t.Errorf("Incorrect merge output:\n%+v", inv)
+	}
+
+	dupe := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"c1"}}, map[LockerID]PackageID{"c1": "x"})
+	if err := inv.Merge(dupe); err == nil {
+		t.Errorf("Merged a duplicate package")
+	}
+}
+
+func Test_Inventory_Merge_Stacked(t *testing.T) {
+	keep_a := func(a, b *Package) *Package { return a }
+	keep_b := func(a, b *Package) *Package { return b }
+
+	type X struct {
+		resolve func(a, b *Package) *Package
+		dup_in LockerID
+		b2 []PackageID
+	}
+
+	tests := map[string]X{
+		"keep here":  X{keep_a, "a1", []PackageID{"y"}},
+		"keep there": X{keep_b, "b2", []PackageID{"y", "dup"}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a1"}}, map[LockerID]PackageID{"a1": "dup"})
+			other := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{2,2,2}: []LockerID{"b2"}}, map[LockerID]PackageID{"b2": "y"})
+			if err := other.StackPackage("b2", &Package{Id: "dup", Size: SizeSpec{1,1,1}}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			var seen []PackageID
+			err := inv.MergeDedupe(other, func(a, b *Package) *Package {
+				seen = append(seen, a.Id, b.Id)
+				return v.resolve(a, b)
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(seen, []PackageID{"dup", "dup"}) {
+				t.Errorf("Conflict resolved between the wrong packages: %v", seen)
+			}
+			if location, ok := inv.GetPackageLocation("dup"); !ok || location != v.dup_in {
+				t.Errorf("Expected dup in %s, got %s", v.dup_in, location)
+			}
+			var b2 []PackageID
+			l := &inv.Lockers[inv.LockersById["b2"]]
+			for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+				b2 = append(b2, p.Id)
+			}
+			if !reflect.DeepEqual(b2, v.b2) {
+				t.Errorf("Expected b2 to hold %v, got %v", v.b2, b2)
+			}
+			if err := inv.Validate(); err != nil {
+				t.Errorf("Invalid inventory after merge: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_Merge_OutOfService(t *testing.T) {
+	inv := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"a1"}}, nil)
+	other := with_packages(t, map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"b1", "b2"}}, nil)
+	if err := other.SetLockerStatus("b1", true); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if err := inv.Merge(other); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.Validate(); err != nil {
+		t.Errorf("Invalid inventory after merge: %s", err.Error())
+	}
+	if inv.availablePosition(inv.LockersById["b1"]) >= 0 {
+		t.Errorf("Out of service locker made available by merge")
+	}
+	if c := inv.Control[inv.Sizes[SizeSpec{1,1,1}]].VirtualCapacity; c != 2 {
+		t.Errorf("Expected capacity 2, got %d", c)
+	}
+}
+
+func Test_Inventory_TransferPackage(t *testing.T) {
+	setup := func(t *testing.T) *Inventory {
+		inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+			SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+			SizeSpec{2,2,2}: []LockerID{"b1"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		inv.Clock = ticking_clock()
+		store_in(t, inv, "s1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+		store_in(t, inv, "b1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+		inv.StackPackage("b1", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+		return inv
+	}
+
+	type X struct {
+		pkg_id PackageID
+		prepare func(t *testing.T, inv *Inventory)
+		locker_id LockerID
+		err error
+	}
+
+	tests := map[string]X{
+		"same size":       X{"a", nil, "s2", nil},
+		"nowhere to go":   X{"a", func(t *testing.T, inv *Inventory) { store_in(t, inv, "s2", &Package{Id: "d", Size: SizeSpec{1,1,1}}) }, "", ErrNoLockerFits},
+		"from stack":      X{"b", nil, "s2", nil},
+		"unknown package": X{"nope", nil, "", ErrUnknownPackageID},
+		"dirty":           X{"a", func(t *testing.T, inv *Inventory) { inv.Lockers[inv.LockersById["s2"]].LastEmptied = inv.now() }, "", ErrNoLockerFits},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := setup(t)
+			if v.prepare != nil {
+				v.prepare(t, inv)
+			}
+			before := inv.clone()
+			src := inv.Lockers[inv.LockersByPackageId[v.pkg_id]].Id
+			filled := inv.Lockers[inv.LockersByPackageId[v.pkg_id]].LastFilled
+
+			locker_id, err := inv.TransferPackage(v.pkg_id)
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if err != nil {
+				if eq, explain := CompareInventories(t, inv, before); !eq {
+					t.Errorf("Failed transfer changed the inventory:\n%s", explain)
+				}
+				return
+			}
+
+			if locker_id != v.locker_id {
+				t.Errorf("Expected transfer to %s, got %s", v.locker_id, locker_id)
+			}
+			if l, ok := inv.GetPackageLocation(v.pkg_id); !ok || l != locker_id || locker_id == src {
+				t.Errorf("Package is in %s, expected %s", l, locker_id)
+			}
+			if inv.Lockers[inv.LockersById[locker_id]].LastFilled != filled {
+				t.Errorf("Transfer did not keep the time the package was stored")
+			}
+			if len(inv.LockersByPackageId) != len(before.LockersByPackageId) {
+				t.Errorf("Transfer changed the number of stored packages")
+			}
+
+			reference := inv.clone()
+			reference.ResetVirtualCapacityFromFreeLists()
+			if eq, explain := CompareInventories(t, inv, reference); !eq {
+				t.Errorf("Capacity inconsistent after transfer:\n%s", explain)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invalid inventory after transfer: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_Defragment(t *testing.T) {
+	type X struct {
+		stored map[LockerID]*Package
+		freed []LockerID
+		moved map[PackageID]LockerID
+	}
+
+	tests := map[string]X{
+		"empty": X{nil, nil, nil},
+		"tight": X{map[LockerID]*Package{"2": &Package{Id: "a", Size: SizeSpec{1,1,1}}}, nil, nil},
+		"oversized": X{map[LockerID]*Package{
+			"5": &Package{Id: "a", Size: SizeSpec{1,1,3}},
+			"7": &Package{Id: "b", Size: SizeSpec{1,1,1}},
+		}, []LockerID{"7", "5"}, map[PackageID]LockerID{"b": "2", "a": "4.5"}},
+		"no rotate": X{map[LockerID]*Package{
+			"7": &Package{Id: "b", Size: SizeSpec{1,1,1}, NoRotate: true},
+		}, []LockerID{"7"}, map[PackageID]LockerID{"b": "2"}},
+		"no-room": X{map[LockerID]*Package{
+			"1": &Package{Id: "a", Size: SizeSpec{1,1,1}},
+			"2": &Package{Id: "b", Size: SizeSpec{1,1,1}},
+			"7": &Package{Id: "c", Size: SizeSpec{2,2,2}},
+		}, nil, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			for id, pkg := range v.stored {
+				store_in(t, inv, id, pkg)
+			}
+			plan := inv.RelocationPlan()
+
+			freed := inv.Defragment()
+			if !reflect.DeepEqual(freed, v.freed) || len(plan) != len(freed) {
+				t.Fatalf("Wrong lockers freed: expected %v, got %v (plan %v)", v.freed, freed, plan)
+			}
+			for pkg_id, locker_id := range v.moved {
+				if at, ok := inv.GetPackageLocation(pkg_id); !ok || at != locker_id {
+					t.Errorf("Package %s is in %s, expected %s", pkg_id, at, locker_id)
+				}
+			}
+			for _, id := range freed {
+				if inv.Lockers[inv.LockersById[id]].Contents != nil {
+					t.Errorf("Locker %s was not emptied", id)
+				}
+			}
+
+			reference := inv.clone()
+			reference.ResetVirtualCapacityFromFreeLists()
+			if eq, explain := CompareInventories(t, inv, reference); !eq {
+				t.Errorf("Capacity inconsistent after defragmenting:\n%s", explain)
+			}
+			if valid, explanation := ValidateInventory(t, inv); !valid {
+				t.Errorf("Invalid or malformed inventory:\n%s", explanation)
+			}
+
+			if again := inv.Defragment(); len(again) != 0 {
+				t.Errorf("Second pass moved packages again: %v", again)
+			}
+		})
+	}
+}
+
+func Test_Inventory_MovePackageToLocker(t *testing.T) {
+	type X struct {
+		pkg_id PackageID
+		locker_id LockerID
+		is_error bool
+	}
+
+	// "abc" is {1,1,1}, stored in "locker", which is {5,1,1}
+	tests := map[string]X{
+		"same-size":       X{"abc", "3", false},
+		"smaller":         X{"abc", "1", false},
+		"larger":          X{"abc", "7", false},
+		"unknown-package": X{"nope", "1", true},
+		"unknown-locker":  X{"abc", "nope", true},
+		"occupied":        X{"abc", "locker", true},
+		"unavailable":     X{"abc", "8", true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			before, _ := cplx_pkg(t)
+
+			err := inv.MovePackageToLocker(v.pkg_id, v.locker_id)
+			if err != nil && v.is_error {
+				eq, explain := CompareInventories(t, inv, before)
+				if !eq {
+					t.Errorf("Failed move changed the inventory:\n%s", explain)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Error("Expected error, but completed successfully")
+				return
+			}
+
+			dst := &inv.Lockers[inv.LockersById[v.locker_id]]
+			if dst.Contents != pkg || pkg.StoredIn != dst || inv.LockersByPackageId[pkg.Id] != inv.LockersById[v.locker_id] {
+				t.Errorf("Package not moved to %s", v.locker_id)
+			}
+			if inv.Lockers[inv.LockersById["locker"]].Contents != nil {
+				t.Errorf("Source locker not emptied")
+			}
+
+			reference := inv.clone()
+			reference.ResetVirtualCapacityFromFreeLists()
+			eq, explain := CompareInventories(t, inv, reference)
+			if !eq {
+				t.Errorf("Capacity inconsistent after move:\n%s", explain)
+			}
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+		})
+	}
+
+	// too big
+	inv, _ := cplx_pkg(t)
+	store_in(t, inv, "7", &Package{Id: "big", Size: SizeSpec{4,4,4}})
+	if err := inv.MovePackageToLocker("big", "1"); err == nil {
+		t.Errorf("Moved a package into a locker too small for it")
+	}
+}
+
+func Test_Inventory_RepairBackPointers(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+	if err := inv.CheckInvariants(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	c := *inv
+	c.Lockers = append([]Locker(nil), inv.Lockers...)
+	if err := c.CheckInvariants(); err == nil {
+		t.Errorf("Expected an error from a copy with stale back pointers")
+	}
+
+	c.RepairBackPointers()
+	if err := c.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error after repair: %s", err.Error())
+	}
+}
+
+func Test_Inventory_CheckInvariants(t *testing.T) {
+	type X struct {
+		corrupt func(inv *Inventory)
+		err bool
+	}
+
+	tests := map[string]X{
+		"consistent":         X{func(inv *Inventory) {}, false},
+		"stale back pointer": X{func(inv *Inventory) { inv.Lockers[inv.LockersById["locker"]].Contents.StoredIn = nil }, true},
+		"unindexed package":  X{func(inv *Inventory) { delete(inv.LockersByPackageId, "abc") }, true},
+		"stray package":      X{func(inv *Inventory) { inv.LockersByPackageId["xyz"] = 0 }, true},
+		"unindexed locker":   X{func(inv *Inventory) { delete(inv.LockersById, "1") }, true},
+		"occupied free list": X{func(inv *Inventory) { inv.Control[200].Lockers = append(inv.Control[200].Lockers, inv.LockersById["locker"]) }, true},
+		"wrong size":         X{func(inv *Inventory) { inv.Control[100].Lockers = append(inv.Control[100].Lockers, 5) }, true},
+		"out of range":       X{func(inv *Inventory) { inv.Control[100].Lockers = append(inv.Control[100].Lockers, 50) }, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := cplx_pkg(t)
+			v.corrupt(inv)
+			if err := inv.CheckInvariants(); (err != nil) != v.err {
+				t.Errorf("Unexpected result: got %v, expected error: %t", err, v.err)
+			}
+		})
+	}
+}
+
+func Test_Inventory_Validate(t *testing.T) {
+	type X struct {
+		corrupt func(inv *Inventory)
+		err bool
+	}
+
+	tests := map[string]X{
+		"consistent":       X{func(inv *Inventory) {}, false},
+		"missing size":     X{func(inv *Inventory) { delete(inv.Sizes, SizeSpec{1,1,1}) }, true},
+		"extra size":       X{func(inv *Inventory) { inv.Sizes[SizeSpec{9,9,9}] = 100 }, true},
+		"swapped sizes":    X{func(inv *Inventory) { inv.Sizes[SizeSpec{1,1,1}], inv.Sizes[SizeSpec{5,1,1}] = 200, 100 }, true},
+		"wrong control id": X{func(inv *Inventory) { inv.Control[100].SizeId = 200 }, true},
+		"nil control":      X{func(inv *Inventory) { inv.Control[100] = nil }, true},
+		"unknown size":     X{func(inv *Inventory) { inv.Lockers[0].SizeId = 999 }, true},
+		"stray package":    X{func(inv *Inventory) { inv.LockersByPackageId["xyz"] = 0 }, true},
+		"wrong locker id":  X{func(inv *Inventory) { inv.LockersById["1"] = 1 }, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := cplx_pkg(t)
+			inv.Lockers[inv.LockersById["locker"]].Stacked = []*Package{&Package{Id: "stacked", StoredIn: &inv.Lockers[inv.LockersById["locker"]]}}
+			inv.LockersByPackageId["stacked"] = inv.LockersById["locker"]
+			v.corrupt(inv)
+			if err := inv.Validate(); (err != nil) != v.err {
+				t.Errorf("Unexpected result: got %v, expected error: %t", err, v.err)
+			}
+		})
+	}
+
+	inv, _ := cplx_pkg(t)
+	if allocs := testing.AllocsPerRun(10, func() { inv.Validate() }); allocs != 0 {
+		t.Errorf("Expected no allocations, got %v", allocs)
+	}
+}
+
+func Test_Inventory_Reindex(t *testing.T) {
+	build := func() *Inventory {
+		inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+			SizeSpec{1,1,1}: []LockerID{"s1", "s2", "s3"},
+			SizeSpec{2,2,2}: []LockerID{"m1", "m2"},
+			SizeSpec{3,3,3}: []LockerID{"l1", "l2"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		store_in(t, inv, "s2", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+		store_in(t, inv, "m1", &Package{Id: "b", Size: SizeSpec{2,1,1}})
+		if err := inv.StackPackage("m1", &Package{Id: "c", Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if _, err := inv.TakeSizeOutOfService(SizeSpec{3,3,3}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if err := inv.AddDoorGroup("door", []LockerID{"s1", "s3"}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if err := inv.AllocateDoorGroup("door"); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		inv.ResetVirtualCapacityFromFreeLists()
+		return inv
+	}
+
+	type X struct {
+		scramble func(inv *Inventory)
+	}
+
+	tests := map[string]X{
+		"unchanged":       X{func(inv *Inventory) {}},
+		"locker ids":      X{func(inv *Inventory) { inv.LockersById = map[LockerID]int{"s1": 4, "bogus": 9} }},
+		"package ids":     X{func(inv *Inventory) { inv.LockersByPackageId = map[PackageID]int{"a": 0, "zzz": 1} }},
+		"free lists":      X{func(inv *Inventory) {
+			for _, ctrl := range inv.Control {
+				ctrl.Lockers = []int{0, 1, 2, 3, 4, 5, 6}
+			}
+		}},
+		"graph":           X{func(inv *Inventory) {
+			for _, ctrl := range inv.Control {
+				ctrl.BiggerThan, ctrl.SmallerThan = nil, []LockerSize{ctrl.SizeId}
+			}
+		}},
+		"capacity":        X{func(inv *Inventory) {
+			for _, ctrl := range inv.Control {
+				ctrl.VirtualCapacity = 99
+			}
+		}},
+		"back pointers":   X{func(inv *Inventory) { inv.Lockers[inv.LockersById["s2"]].Contents.StoredIn = nil }},
+		"missing control": X{func(inv *Inventory) { delete(inv.Control, inv.Sizes[SizeSpec{2,2,2}]) }},
+		"everything":      X{func(inv *Inventory) {
+			inv.LockersById, inv.LockersByPackageId = nil, nil
+			inv.Control = map[LockerSize]*LockerControlSpec{}
+		}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := build()
+			v.scramble(inv)
+			inv.Reindex()
+
+			if err := inv.Validate(); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+			if eq, explanation := CompareInventories(t, inv, build()); !eq {
+				t.Errorf("Reindexed inventory differs: %s", explanation)
+			}
+			if _, err := inv.RetrievePackageById("c"); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		})
+	}
+
+	// settings of a size survive while its control is in place
+	inv := build()
+	inv.SetMaxWeight(inv.Sizes[SizeSpec{2,2,2}], 7)
+	inv.Reindex()
+	if max := inv.Control[inv.Sizes[SizeSpec{2,2,2}]].MaxWeight; max != 7 {
+		t.Errorf("Expected MaxWeight 7 to be kept, got %d", max)
+	}
+}
+
+func Test_Inventory_DeactivateSize(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+		SizeSpec{2,2,2}: []LockerID{"b1", "b2"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.Clock = ticking_clock()
+	small, big := inv.Sizes[SizeSpec{1,1,1}], inv.Sizes[SizeSpec{2,2,2}]
+	capacity := func(small_capacity, big_capacity int) {
+		t.Helper()
+		if inv.Control[small].VirtualCapacity != small_capacity || inv.Control[big].VirtualCapacity != big_capacity {
+			t.Errorf("Expected capacity %d and %d, got %d and %d", small_capacity, big_capacity,
+				inv.Control[small].VirtualCapacity, inv.Control[big].VirtualCapacity)
+		}
+	}
+
+	if locker_id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil || inv.Lockers[inv.LockersById[locker_id]].SizeId != small {
+		t.Fatalf("Unexpected deposit result: %s %v", locker_id, err)
+	}
+	if err := inv.DeactivateSize(SizeSpec{1,1,1}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(2, 2)
+	if inv.HasFreeExact(SizeSpec{1,1,1}) {
+		t.Errorf("Inactive size reported as having free lockers")
+	}
+	if err := inv.MovePackageToLocker("a", inv.Lockers[inv.Control[small].Lockers[0]].Id); err == nil {
+		t.Errorf("Expected moving into an inactive size to fail")
+	}
+
+	locker_id, err := inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{1,1,1}})
+	if err != nil || inv.Lockers[inv.LockersById[locker_id]].SizeId != big {
+		t.Errorf("Expected deposit into the big size, got %s %v", locker_id, err)
+	}
+	capacity(1, 1)
+
+	// retrieving from the inactive size still works, and frees nothing for deposits
+	if _, err := inv.RetrievePackageById("a"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	capacity(1, 1)
+	if err := inv.DeactivateSize(SizeSpec{1,1,1}); err != nil {
+		t.Errorf("Unexpected error deactivating twice: %s", err.Error())
+	}
+	capacity(1, 1)
+
+	if err := inv.ReactivateSize(SizeSpec{1,1,1}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(3, 1)
+	inv.ResetVirtualCapacityFromFreeLists()
+	capacity(3, 1)
+
+	if err := inv.DeactivateSize(SizeSpec{3,3,3}); err == nil {
+		t.Errorf("Expected an error for an unknown size")
+	}
+	if err := inv.ReactivateSize(SizeSpec{3,3,3}); err == nil {
+		t.Errorf("Expected an error for an unknown size")
+	}
+}
+
+func Test_Inventory_AddLockers(t *testing.T) {
+	type X struct {
+		size SizeSpec
+		count int
+		expected map[SizeSpec]int
+	}
+
+	tests := map[string]X{
+		"existing size":   X{SizeSpec{2,2,2}, 2, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 3, SizeSpec{3,3,3}: 1}},
+		"denormalized":    X{SizeSpec{2,1,2}, 1, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{2,2,1}: 1, SizeSpec{3,3,3}: 1}},
+		"new in middle":   X{SizeSpec{2,2,1}, 3, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{2,2,1}: 3, SizeSpec{3,3,3}: 1}},
+		"new largest":     X{SizeSpec{4,4,4}, 1, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1, SizeSpec{4,4,4}: 1}},
+		"new smallest":    X{SizeSpec{1,1,0}, 2, map[SizeSpec]int{SizeSpec{1,1,0}: 2, SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1}},
+		"nothing":         X{SizeSpec{4,4,4}, 0, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 1})
+			expected := NewInventory(v.expected)
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			if _, err := inv.DepositPackage(pkg); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if _, err := expected.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			ids := inv.AddLockers(v.size, v.count)
+			if len(ids) != v.count {
+				t.Fatalf("Expected %d new lockers, got %v", v.count, ids)
+			}
+			for _, id := range ids {
+				index, ok := inv.LockersById[id]
+				if !ok || inv.Control[inv.Lockers[index].SizeId].Size != v.size.Normalize() {
+					t.Errorf("New locker %s missing or the wrong size", id)
+				}
+			}
+			if eq, explanation := CompareInventories(t, inv, expected); !eq {
+				t.Errorf("Unexpected inventory: %s", explanation)
+			}
+			if pkg.StoredIn != &inv.Lockers[inv.LockersByPackageId["a"]] {
+				t.Errorf("Stored package's back pointer is stale")
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_RemoveLocker(t *testing.T) {
+	type X struct {
+		id LockerID
+		out_of_service bool
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"swap remove":    X{"1", false, false},
+		"larger size":    X{"4", false, false},
+		"unoccupied":     X{"5", false, false},
+		"out of service": X{"4", true, false},
+		"occupied":       X{"2", false, true},
+		"door group":     X{"3", false, true},
+		"unknown":        X{"9", false, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2", "3"},
+				SizeSpec{2,2,2}: []LockerID{"4", "5"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			pkg := &Package{Id: "a", Size: SizeSpec{1,1,1}}
+			store_in(t, inv, "2", pkg)
+			if err := inv.AddDoorGroup("door", []LockerID{"3"}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if v.out_of_service {
+				inv.TakeSizeOutOfService(SizeSpec{2,2,2})
+			}
+			before := inv.clone()
+
+			err = inv.RemoveLocker(v.id)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if v.is_error {
+				if eq, explanation := CompareInventories(t, inv, before); !eq {
+					t.Errorf("Failed call changed the inventory: %s", explanation)
+				}
+				return
+			}
+
+			if _, ok := inv.LockersById[v.id]; ok || len(inv.Lockers) != 4 {
+				t.Errorf("Locker was not removed")
+			}
+			if pkg.StoredIn != &inv.Lockers[inv.LockersByPackageId["a"]] || pkg.StoredIn.Id != "2" {
+				t.Errorf("Stored package was not kept track of")
+			}
+			if ok, msg := ValidateInventory(t, inv); !ok {
+				t.Errorf("Invalid inventory: %s", msg)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_ResizeLocker(t *testing.T) {
+	type X struct {
+		id LockerID
+		size SizeSpec
+		expected map[SizeSpec]int
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"grow":         X{"1", SizeSpec{3,3,1}, map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{4,1,1}: 2, SizeSpec{3,3,1}: 2}, false},
+		"shrink":       X{"3", SizeSpec{1,1,1}, map[SizeSpec]int{SizeSpec{1,1,1}: 3, SizeSpec{4,1,1}: 1, SizeSpec{3,3,1}: 1}, false},
+		"sideways":     X{"3", SizeSpec{1,3,3}, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{4,1,1}: 1, SizeSpec{3,3,1}: 2}, false},
+		"same size":    X{"1", SizeSpec{1,1,1}, map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{4,1,1}: 2, SizeSpec{3,3,1}: 1}, false},
+		"unknown size": X{"1", SizeSpec{2,2,2}, nil, true},
+		"occupied":     X{"2", SizeSpec{3,3,1}, nil, true},
+		"unknown":      X{"9", SizeSpec{3,3,1}, nil, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2"},
+				SizeSpec{4,1,1}: []LockerID{"3", "4"},
+				SizeSpec{3,3,1}: []LockerID{"5"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "2", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+			before := inv.clone()
+
+			err = inv.ResizeLocker(v.id, v.size)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			expected := before
+			if !v.is_error {
+				expected = NewInventory(v.expected)
+				store_in(t, expected, expected.Lockers[expected.Control[expected.Sizes[SizeSpec{1,1,1}]].Lockers[0]].Id, &Package{Id: "a", Size: SizeSpec{1,1,1}})
+				if size := inv.Control[inv.Lockers[inv.LockersById[v.id]].SizeId].Size; size != v.size.Normalize() {
+					t.Errorf("Locker has size %v, expected %v", size, v.size.Normalize())
+				}
+			}
+			if eq, explanation := CompareInventories(t, inv, expected); !eq {
+				t.Errorf("Unexpected inventory: %s", explanation)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
+			}
+		})
+	}
+
+	// lockers which are not available move without changing capacity.
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1"}, SizeSpec{2,2,2}: []LockerID{"2"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.TakeSizeOutOfService(SizeSpec{1,1,1})
+	if err := inv.ResizeLocker("1", SizeSpec{2,2,2}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if vc := inv.Control[inv.Sizes[SizeSpec{2,2,2}]].VirtualCapacity; vc != 1 {
+		t.Errorf("Unexpected capacity: %d", vc)
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Invariants violated: %s", err.Error())
+	}
+}
+
+func Test_Inventory_ResizeLocker_Alarms(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"1"},
+		SizeSpec{2,2,2}: []LockerID{"2"},
+		SizeSpec{3,3,3}: []LockerID{"3"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	small, big := inv.Sizes[SizeSpec{1,1,1}], inv.Sizes[SizeSpec{3,3,3}]
+
+	var alarms []LockerSize
+	inv.OnCapacityAlarm = func(size LockerSize, raised bool, capacity int) {
+		alarms = append(alarms, size)
+	}
+	inv.SetAlarm(small, 2, 3)
+	inv.SetAlarm(big, 1, 1)
+	if len(alarms) != 1 || alarms[0] != big {
+		t.Fatalf("Unexpected alarms before resizing: %v", alarms)
+	}
+
+	// the small size counts the locker before and after, so it never drops to 2.
+	alarms = nil
+	if err := inv.ResizeLocker("2", SizeSpec{3,3,3}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(alarms) != 1 || alarms[0] != big || inv.AlarmRaised(big) {
+		t.Errorf("Unexpected alarms: got %v, expected only the big size's to clear", alarms)
+	}
+	if vc := inv.Control[small].VirtualCapacity; vc != 3 {
+		t.Errorf("Unexpected capacity: %d", vc)
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Invariants violated: %s", err.Error())
+	}
+}
+
+func Test_Inventory_SplitLocker(t *testing.T) {
+	type X struct {
+		id LockerID
+		sizes []SizeSpec
+		ids []LockerID
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"ok":           X{"7", []SizeSpec{SizeSpec{5,5,2}, SizeSpec{3,5,5}}, []LockerID{"7.1", "7.2"}, false},
+		"known sizes":  X{"7", []SizeSpec{SizeSpec{1,1,1}, SizeSpec{1,1,5}}, []LockerID{"7.1", "7.2"}, false},
+		"unknown":      X{"9", []SizeSpec{SizeSpec{1,1,1}}, nil, true},
+		"occupied":     X{"locker", []SizeSpec{SizeSpec{1,1,1}}, nil, true},
+		"unavailable":  X{"8", []SizeSpec{SizeSpec{1,1,1}}, nil, true},
+		"too big":      X{"7", []SizeSpec{SizeSpec{1,1,1}, SizeSpec{6,1,1}}, nil, true},
+		"no sizes":     X{"7", nil, nil, true},
+		"duplicate id": X{"1", []SizeSpec{SizeSpec{1,1,1}}, nil, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := cplx_pkg(t)
+			// an earlier split of locker "1" would have been named like this
+			inv.LockersById["1.1"] = inv.LockersById["2"]
+			delete(inv.LockersById, "2")
+			inv.Lockers[inv.LockersById["1.1"]].Id = "1.1"
+			before := len(inv.Lockers)
+
+			ids, err := inv.SplitLocker(v.id, v.sizes)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if !reflect.DeepEqual(ids, v.ids) {
+				t.Errorf("Expected IDs %v, got %v", v.ids, ids)
+			}
+			if err != nil {
+				if len(inv.Lockers) != before {
+					t.Errorf("Failed split changed the inventory")
+				}
+				return
+			}
+
+			if len(inv.Lockers) != before - 1 + len(v.sizes) {
+				t.Errorf("Wrong locker count %d", len(inv.Lockers))
+			}
+			if _, ok := inv.LockersById[v.id]; ok {
+				t.Errorf("Split locker is still present")
+			}
+			for i, id := range ids {
+				l, ok := inv.Resolve(must_ref(t, inv, id))
+				if !ok || inv.Control[l.SizeId].Size != v.sizes[i].Normalize() || inv.availablePosition(inv.LockersById[id]) < 0 {
+					t.Errorf("New locker %s is wrong: %+v", id, l)
+				}
+			}
+			if ok, reason := ValidateInventory(t, inv); !ok {
+				t.Errorf("Invalid inventory: %s", reason)
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+			if _, err := inv.RetrievePackageById("abc"); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func must_ref(t *testing.T, inv *Inventory, id LockerID) LockerRef {
+	t.Helper()
+
+	ref, ok := inv.Ref(id)
+	if !ok {
+		t.Fatalf("Locker %s not known", id)
+	}
+	return ref
+}
+
+func Test_Inventory_SplitLocker_MovesLast(t *testing.T) {
+	inv := NewInventoryWithIDGen(map[SizeSpec]int{SizeSpec{2,2,2}: 3}, SequentialIDGen("L"))
+	store_in(t, inv, "L-3", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+	ref := must_ref(t, inv, "L-3")
+
+	if _, err := inv.SplitLocker("L-1", []SizeSpec{SizeSpec{2,2,1}, SizeSpec{2,2,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if l, ok := inv.Resolve(ref); !ok || l.Id != "L-3" {
+		t.Errorf("Reference to the moved locker no longer resolves")
+	}
+	if locker_id, err := inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{2,2,2}}); err != nil || locker_id != "L-2" {
+		t.Errorf("Unexpected deposit result: %s %v", locker_id, err)
+	}
+	if pkg, err := inv.RetrievePackageById("a"); err != nil || pkg.StoredIn != nil {
+		t.Errorf("Unexpected retrieval result: %v %v", pkg, err)
+	}
+}
+
+func Test_Inventory_TakeSizeOutOfService(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+	inv.Clock = ticking_clock()
+	capacity := func(expected map[LockerSize]int) {
+		t.Helper()
+		for size_id, c := range expected {
+			if inv.Control[size_id].VirtualCapacity != c {
+				t.Errorf("Size %d: expected capacity %d, got %d", size_id, c, inv.Control[size_id].VirtualCapacity)
+			}
+		}
+	}
+
+	// two empty lockers of the size, and one occupied
+	affected, err := inv.TakeSizeOutOfService(SizeSpec{1,1,5})
+	if err != nil || affected != 2 {
+		t.Fatalf("Unexpected result: %d %v", affected, err)
+	}
+	capacity(map[LockerSize]int{100: 5, 200: 1, 300: 3, 400: 1})
+	if inv.Lockers[inv.LockersById["locker"]].OutOfService {
+		t.Errorf("Occupied locker taken out of service")
+	}
+
+	locker_id, err := inv.DepositPackage(&Package{Id: "long", Size: SizeSpec{5,1,1}})
+	if err != nil || inv.Lockers[inv.LockersById[locker_id]].SizeId != 400 {
+		t.Errorf("Expected deposit into the larger size, got %s %v", locker_id, err)
+	}
+	capacity(map[LockerSize]int{100: 4, 200: 0, 300: 2, 400: 0})
+
+	// the occupied locker was not taken out of service, so it is reused once emptied
+	if _, err := inv.RetrievePackageById("abc"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{100: 5, 200: 1, 300: 2, 400: 0})
+
+	affected, err = inv.RestoreSize(SizeSpec{5,1,1})
+	if err != nil || affected != 2 {
+		t.Fatalf("Unexpected result: %d %v", affected, err)
+	}
+	capacity(map[LockerSize]int{100: 7, 200: 3, 300: 2, 400: 0})
+	if affected, _ := inv.RestoreSize(SizeSpec{5,1,1}); affected != 0 {
+		t.Errorf("Expected nothing to restore, got %d", affected)
+	}
+
+	if _, err := inv.TakeSizeOutOfService(SizeSpec{9,9,9}); err == nil {
+		t.Errorf("Expected an error for an unknown size")
+	}
+	if _, err := inv.RestoreSize(SizeSpec{9,9,9}); err == nil {
+		t.Errorf("Expected an error for an unknown size")
+	}
+}
+
+func Test_Inventory_SetLockerStatus(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+		SizeSpec{2,2,2}: []LockerID{"m1"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	small, medium := inv.Sizes[SizeSpec{1,1,1}], inv.Sizes[SizeSpec{2,2,2}]
+	capacity := func(expected map[LockerSize]int) {
+		t.Helper()
+		for size_id, c := range expected {
+			if inv.Control[size_id].VirtualCapacity != c {
+				t.Errorf("Size %d: expected capacity %d, got %d", size_id, c, inv.Control[size_id].VirtualCapacity)
+			}
+		}
+		if err := inv.CheckInvariants(); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	// an empty locker stops being available at once
+	if err := inv.SetLockerStatus("m1", true); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 2, medium: 0})
+	if _, err := inv.GetMostSuitableLockerSize(SizeSpec{2,2,2}); err != ErrNoLockerFits {
+		t.Errorf("Expected ErrNoLockerFits, got %v", err)
+	}
+	if err := inv.SetLockerStatus("m1", true); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 2, medium: 0})
+
+	// an occupied locker keeps its package, but is not reused
+	locker_id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.SetLockerStatus(locker_id, true); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 1, medium: 0})
+	if _, err := inv.RetrievePackageById("a"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 1, medium: 0})
+	other_id, err := inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{1,1,1}})
+	if err != nil || other_id == locker_id {
+		t.Errorf("Expected deposit into another locker, got %s %v", other_id, err)
+	}
+	capacity(map[LockerSize]int{small: 0, medium: 0})
+
+	// returning lockers to service makes the empty ones available again
+	if err := inv.SetLockerStatus(locker_id, false); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 1, medium: 0})
+	if err := inv.SetLockerStatus(other_id, true); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.SetLockerStatus(other_id, false); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 1, medium: 0})
+	if err := inv.SetLockerStatus("m1", false); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	capacity(map[LockerSize]int{small: 2, medium: 1})
+
+	if err := inv.SetLockerStatus("nope", true); err != ErrUnknownLockerID {
+		t.Errorf("Expected ErrUnknownLockerID, got %v", err)
+	}
+}
+
+func Test_Inventory_ReconfigureLocker(t *testing.T) {
+	type X struct {
+		id LockerID
+		target SizeSpec
+		size SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"known size":    X{"3", SizeSpec{3,3,1}, SizeSpec{3,3,1}, false},
+		"new size":      X{"3", SizeSpec{1,2,4}, SizeSpec{4,2,1}, false},
+		"same size":     X{"3", SizeSpec{5,1,1}, SizeSpec{5,1,1}, false},
+		"not allowed":   X{"3", SizeSpec{5,5,5}, SizeSpec{5,1,1}, true},
+		"occupied":      X{"locker", SizeSpec{3,3,1}, SizeSpec{5,1,1}, true},
+		"not available": X{"8", SizeSpec{3,3,1}, SizeSpec{5,5,5}, true},
+		"unknown":       X{"9", SizeSpec{3,3,1}, SizeSpec{}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := cplx_pkg(t)
+			configurable := []SizeSpec{SizeSpec{5,1,1}, SizeSpec{1,3,3}, SizeSpec{4,2,1}}
+			for _, id := range []LockerID{"3", "locker", "8"} {
+				inv.Lockers[inv.LockersById[id]].Configurable = configurable
+			}
+
+			err := inv.ReconfigureLocker(v.id, v.target)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			index, ok := inv.LockersById[v.id]
+			if !ok { return }
+
+			if size := inv.Control[inv.Lockers[index].SizeId].Size; size != v.size {
+				t.Errorf("Expected size %v, got %v", v.size, size)
+			}
+			if ok, reason := ValidateInventory(t, inv); !ok {
+				t.Errorf("Invalid inventory: %s", reason)
+			}
+			if err == nil && inv.availablePosition(index) < 0 {
+				t.Errorf("Reconfigured locker is not available")
+			}
+		})
+	}
+
+	// capacity follows the locker to its new size
+	inv, _ := cplx_pkg(t)
+	inv.Lockers[inv.LockersById["3"]].Configurable = []SizeSpec{SizeSpec{3,3,1}}
+	if err := inv.ReconfigureLocker("3", SizeSpec{3,3,1}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if inv.Control[200].VirtualCapacity != 2 || inv.Control[300].VirtualCapacity != 4 {
+		t.Errorf("Wrong capacity: %d and %d", inv.Control[200].VirtualCapacity, inv.Control[300].VirtualCapacity)
+	}
+}
@@ -0,0 +1,154 @@
+package lockers
+
+import (
+	"errors"
+	"time"
+)
+
+// the occupancy of a single locker, as saved by SaveOccupancy.
+type lockerOccupancy struct {
+	id LockerID
+	size_id LockerSize
+
+	contents *Package
+	stacked []*Package
+	volume_used int64
+	last_cleaned, last_emptied, last_filled time.Time
+	tightest_offered LockerSize
+	out_of_service bool
+	last_retrieved *Package
+}
+
+// A saved record of which packages are in which lockers of an inventory, and which
+// lockers are available. See Inventory.SaveOccupancy.
+type OccupancyState struct {
+	fingerprint string
+	lockers []lockerOccupancy
+	free map[LockerSize][]int
+	deposits map[LockerSize]int
+	held map[DoorGroupID]bool
+	by_package map[PackageID]int
+	by_key map[string]PackageID
+}
+
+// Saves the occupancy of the inventory: which packages are in which lockers, which
+// lockers are available, and the state that goes with them, such as when each locker
+// was last filled and emptied. Use RestoreOccupancy to go back to it, such as after
+// trying out a sequence of deposits. This is much cheaper than cloning the inventory,
+// but only works for as long as the inventory keeps the same lockers and sizes.
+// Packages are saved by reference, so they should not be modified in the meantime.
+func (inv *Inventory) SaveOccupancy() OccupancyState {
+	state := OccupancyState{
+		fingerprint: inv.ConfigFingerprint(),
+		lockers: make([]lockerOccupancy, len(inv.Lockers)),
+		free: make(map[LockerSize][]int, len(inv.Control)),
+		deposits: make(map[LockerSize]int, len(inv.Control)),
+		held: make(map[DoorGroupID]bool, len(inv.doorGroups)),
+		by_package: make(map[PackageID]int, len(inv.LockersByPackageId)),
+		by_key: make(map[string]PackageID, len(inv.packagesByKey)),
+	}
+
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		state.lockers[i] = lockerOccupancy{
+			id: l.Id,
+			size_id: l.SizeId,
+			contents: l.Contents,
+			stacked: append([]*Package(nil), l.Stacked...),
+			volume_used: l.VolumeUsed,
+			last_cleaned: l.LastCleaned,
+			last_emptied: l.LastEmptied,
+			last_filled: l.LastFilled,
+			tightest_offered: l.TightestOffered,
+			out_of_service: l.OutOfService,
+			last_retrieved: l.lastRetrieved,
+		}
+	}
+	for size_id, ctrl := range inv.Control {
+		state.free[size_id] = append([]int(nil), ctrl.Lockers...)
+		state.deposits[size_id] = ctrl.Deposits
+	}
+	for id, g := range inv.doorGroups {
+		state.held[id] = g.held
+	}
+	for k, v := range inv.LockersByPackageId {
+		state.by_package[k] = v
+	}
+	for k, v := range inv.packagesByKey {
+		state.by_key[k] = v
+	}
+	return state
+}
+
+// Returns the inventory to an occupancy saved with SaveOccupancy. Packages deposited
+// since then are dropped from the inventory, and packages retrieved since then are
+// put back where they were. Returns an error without changing anything if the
+// inventory's lockers, sizes or door groups have changed since the state was saved.
+func (inv *Inventory) RestoreOccupancy(state OccupancyState) error {
+	if state.fingerprint != inv.ConfigFingerprint() || len(state.lockers) != len(inv.Lockers) || len(state.held) != len(inv.doorGroups) {
+		return errors.New("Inventory has changed since occupancy was saved")
+	}
+	for i := range inv.Lockers {
+		if state.lockers[i].id != inv.Lockers[i].Id || state.lockers[i].size_id != inv.Lockers[i].SizeId {
+			return errors.New("Inventory has changed since occupancy was saved")
+		}
+	}
+	for id := range state.held {
+		if _, ok := inv.doorGroups[id]; !ok {
+			return errors.New("Inventory has changed since occupancy was saved")
+		}
+	}
+
+	// packages stored now may not be stored in the saved state.
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents != nil {
+			l.Contents.StoredIn = nil
+		}
+		for _, p := range l.Stacked {
+			p.StoredIn = nil
+		}
+	}
+
+	for i, saved := range state.lockers {
+		l := &inv.Lockers[i]
+		l.Contents = saved.contents
+		l.Stacked = append([]*Package(nil), saved.stacked...)
+		if len(l.Stacked) == 0 {
+			l.Stacked = nil
+		}
+		l.VolumeUsed = saved.volume_used
+		l.LastCleaned, l.LastEmptied, l.LastFilled = saved.last_cleaned, saved.last_emptied, saved.last_filled
+		l.TightestOffered = saved.tightest_offered
+		l.OutOfService = saved.out_of_service
+		l.lastRetrieved = saved.last_retrieved
+	}
+	inv.retrievedFrom = make(map[PackageID]LockerID)
+	for i := range inv.Lockers {
+		if l := &inv.Lockers[i]; l.lastRetrieved != nil {
+			inv.retrievedFrom[l.lastRetrieved.Id] = l.Id
+		}
+	}
+	for size_id, ctrl := range inv.Control {
+		ctrl.Lockers = append([]int(nil), state.free[size_id]...)
+		ctrl.Deposits = state.deposits[size_id]
+	}
+	for id, held := range state.held {
+		inv.doorGroups[id].held = held
+	}
+
+	inv.LockersByPackageId = make(map[PackageID]int, len(state.by_package))
+	for k, v := range state.by_package {
+		inv.LockersByPackageId[k] = v
+	}
+	inv.packagesByKey = make(map[string]PackageID, len(state.by_key))
+	inv.keysByPackage = make(map[PackageID]string, len(state.by_key))
+	for k, v := range state.by_key {
+		inv.packagesByKey[k] = v
+		inv.keysByPackage[v] = k
+	}
+
+	inv.RepairBackPointers()
+	inv.ResetVirtualCapacityFromFreeLists()
+	return nil
+}
@@ -0,0 +1,94 @@
+package lockers
+
+import (
+	"testing"
+)
+
+func Test_Inventory_RestoreOccupancy(t *testing.T) {
+	type X struct {
+		change func(t *testing.T, inv *Inventory)
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"nothing":       X{func(t *testing.T, inv *Inventory) {}, false},
+		"deposits": X{func(t *testing.T, inv *Inventory) {
+			for _, id := range []PackageID{"c", "d"} {
+				if _, err := inv.DepositPackage(&Package{Id: id, Size: SizeSpec{1,1,1}}); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+		}, false},
+		"retrievals": X{func(t *testing.T, inv *Inventory) {
+			for _, id := range []PackageID{"a", "s"} {
+				if _, err := inv.RetrievePackageById(id); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+		}, false},
+		"moves": X{func(t *testing.T, inv *Inventory) {
+			if err := inv.MovePackageToLocker("a", "4"); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, false},
+		"split locker": X{func(t *testing.T, inv *Inventory) {
+			if _, err := inv.SplitLocker("4", []SizeSpec{SizeSpec{1,1,1}, SizeSpec{1,1,1}}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, true},
+		"new door group": X{func(t *testing.T, inv *Inventory) {
+			if err := inv.AddDoorGroup("door", []LockerID{"3", "4"}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1", "2", "3"},
+				SizeSpec{2,2,2}: []LockerID{"4", "5"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			inv.Clock = ticking_clock()
+			a, b := &Package{Id: "a", Size: SizeSpec{1,1,1}}, &Package{Id: "b", Size: SizeSpec{2,2,1}}
+			store_in(t, inv, "1", a)
+			store_in(t, inv, "5", b)
+			if err := inv.StackPackage("5", &Package{Id: "s", Size: SizeSpec{1,1,1}}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			state := inv.SaveOccupancy()
+			expected := inv.clone()
+			v.change(t, inv)
+			changed := inv.clone()
+
+			err = inv.RestoreOccupancy(state)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if v.is_error {
+				expected = changed
+			}
+			if eq, explanation := CompareInventories(t, inv, expected); !eq {
+				t.Errorf("Unexpected inventory after restore: %s", explanation)
+			}
+			for id, index := range expected.LockersByPackageId {
+				if inv.LockersByPackageId[id] != index {
+					t.Errorf("Package %s in locker %d, expected %d", id, inv.LockersByPackageId[id], index)
+				}
+			}
+			if len(inv.LockersByPackageId) != len(expected.LockersByPackageId) {
+				t.Errorf("Unexpected packages: %v", inv.LockersByPackageId)
+			}
+			if !v.is_error && (a.StoredIn != &inv.Lockers[inv.LockersById["1"]] || b.StoredIn != &inv.Lockers[inv.LockersById["5"]]) {
+				t.Errorf("Package back pointers were not restored")
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Invariants violated: %s", err.Error())
+			}
+		})
+	}
+}
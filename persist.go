@@ -0,0 +1,266 @@
+package lockers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// the form in which MarshalJSON saves an inventory. Unlike InventoryDTO, it is meant
+// to be read back by this package only, so it includes settings and operational state
+// as well as lockers and packages, and may change along with the package. Nothing
+// derived, such as indices, the containment graph or capacities, is saved.
+type persistedInventory struct {
+	Sizes []persistedSize `json:"sizes"`
+	Lockers []persistedLocker `json:"lockers"`
+	HeldDoorGroups []DoorGroupID `json:"held_door_groups,omitempty"`
+
+	TransitiveCapacity bool `json:"transitive_capacity"`
+	PackingFactor float64 `json:"packing_factor,omitempty"`
+	MoveIfStored bool `json:"move_if_stored,omitempty"`
+	MaxOccupancy float64 `json:"max_occupancy,omitempty"`
+}
+
+// a size of locker, as saved by MarshalJSON.
+type persistedSize struct {
+	SizeDTO
+
+	// the IDs of the available lockers of the size, in allocation order.
+	Available []LockerID `json:"available"`
+
+	MinReserve int `json:"min_reserve,omitempty"`
+	PriorityBias int `json:"priority_bias,omitempty"`
+	MaxWeight int `json:"max_weight,omitempty"`
+	Deposits int `json:"deposits,omitempty"`
+	Inactive bool `json:"inactive,omitempty"`
+}
+
+// a package, as saved by MarshalJSON.
+type persistedPackage struct {
+	PackageDTO
+	PreferTight bool `json:"prefer_tight,omitempty"`
+	NoRotate bool `json:"no_rotate,omitempty"`
+	Weight int `json:"weight,omitempty"`
+}
+
+// a locker and the packages in it, as saved by MarshalJSON.
+type persistedLocker struct {
+	Id LockerID `json:"id"`
+	Size SizeDTO `json:"size"`
+
+	// the locker's contents followed by any stacked packages.
+	Packages []persistedPackage `json:"packages,omitempty"`
+
+	Purpose LockerPurpose `json:"purpose,omitempty"`
+	Zone string `json:"zone,omitempty"`
+	DoorGroup DoorGroupID `json:"door_group,omitempty"`
+	OutOfService bool `json:"out_of_service,omitempty"`
+	Configurable []SizeDTO `json:"configurable,omitempty"`
+	TightestOffered *SizeDTO `json:"tightest_offered,omitempty"`
+
+	LastCleaned time.Time `json:"last_cleaned"`
+	LastEmptied time.Time `json:"last_emptied"`
+	LastFilled time.Time `json:"last_filled"`
+}
+
+func persistPackage(p *Package) persistedPackage {
+	return persistedPackage{PackageDTO{Id: p.Id, Size: sizeDTO(p.Size), Margin: p.Margin}, p.PreferTight, p.NoRotate, p.Weight}
+}
+
+// Saves the inventory as JSON, so that it can be loaded again with UnmarshalJSON,
+// such as across a restart. Lockers, sizes, stored packages, settings and the state
+// of each locker are saved, in a stable order. Indices, the containment graph and
+// capacities are not, since they are rebuilt on loading. Hooks such as the Logger,
+// Clock and OnDeposit cannot be saved, and neither can the Strategy or locker ID
+// generator, capacity alarms, reservations, idempotency keys or rejection history. For a representation
+// to hand to other programs, see ToDTO.
+func (inv *Inventory) MarshalJSON() ([]byte, error) {
+	p := persistedInventory{
+		Sizes: make([]persistedSize, 0, len(inv.Control)),
+		Lockers: make([]persistedLocker, 0, len(inv.Lockers)),
+
+		TransitiveCapacity: inv.TransitiveCapacity,
+		PackingFactor: inv.PackingFactor,
+		MoveIfStored: inv.MoveIfStored,
+		MaxOccupancy: inv.MaxOccupancy,
+	}
+
+	// sizes are saved in the same order as they are in a DTO, smallest first, but in
+	// the orientation they were given in, so that lockers keep it (see
+	// LockerControlSpec.Oriented).
+	for _, s := range inv.ToDTO().Sizes {
+		ctrl := inv.Control[inv.Sizes[s.spec()]]
+		x := persistedSize{
+			SizeDTO: sizeDTO(ctrl.installed()),
+			Available: make([]LockerID, 0, len(ctrl.Lockers)),
+			MinReserve: ctrl.MinReserve,
+			PriorityBias: ctrl.PriorityBias,
+			MaxWeight: ctrl.MaxWeight,
+			Deposits: ctrl.Deposits,
+			Inactive: ctrl.Inactive,
+		}
+		for _, index := range ctrl.Lockers {
+			x.Available = append(x.Available, inv.Lockers[index].Id)
+		}
+		p.Sizes = append(p.Sizes, x)
+	}
+
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		x := persistedLocker{
+			Id: l.Id,
+			Size: sizeDTO(inv.Control[l.SizeId].Size),
+			Purpose: l.Purpose,
+			Zone: l.Zone,
+			DoorGroup: l.DoorGroup,
+			OutOfService: l.OutOfService,
+			LastCleaned: l.LastCleaned,
+			LastEmptied: l.LastEmptied,
+			LastFilled: l.LastFilled,
+		}
+		if l.Contents != nil {
+			x.Packages = append(x.Packages, persistPackage(l.Contents))
+		}
+		for _, pkg := range l.Stacked {
+			x.Packages = append(x.Packages, persistPackage(pkg))
+		}
+		for _, size := range l.Configurable {
+			x.Configurable = append(x.Configurable, sizeDTO(size))
+		}
+		if ctrl, ok := inv.Control[l.TightestOffered]; ok {
+			size := sizeDTO(ctrl.Size)
+			x.TightestOffered = &size
+		}
+		p.Lockers = append(p.Lockers, x)
+	}
+
+	for i := range inv.Lockers {
+		id := inv.Lockers[i].DoorGroup
+		if g, ok := inv.doorGroups[id]; ok && g.held && g.members[0] == i {
+			p.HeldDoorGroups = append(p.HeldDoorGroups, id)
+		}
+	}
+
+	return json.Marshal(p)
+}
+
+// Loads an inventory saved with MarshalJSON, replacing the current contents of inv.
+// Indices, the containment graph and capacities are rebuilt from the saved lockers
+// rather than trusted, and the result is checked with CheckInvariants. The hooks,
+// Strategy and locker ID generator of inv are kept. Returns an error, leaving inv
+// unchanged, if the data is not valid.
+func (inv *Inventory) UnmarshalJSON(data []byte) error {
+	var p persistedInventory
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+
+	loaded := newInventory(len(p.Sizes), len(p.Lockers))
+	loaded.TransitiveCapacity = p.TransitiveCapacity
+	loaded.PackingFactor = p.PackingFactor
+	loaded.MoveIfStored = p.MoveIfStored
+	loaded.MaxOccupancy = p.MaxOccupancy
+
+	for _, s := range p.Sizes {
+		if _, ok := loaded.Sizes[s.spec().Normalize()]; ok {
+			return errors.New("Duplicate locker size")
+		}
+		ctrl := loaded.Control[loaded.addSize(s.spec(), len(s.Available))]
+		ctrl.MinReserve = s.MinReserve
+		ctrl.PriorityBias = s.PriorityBias
+		ctrl.MaxWeight = s.MaxWeight
+		ctrl.Deposits = s.Deposits
+		ctrl.Inactive = s.Inactive
+	}
+
+	for _, l := range p.Lockers {
+		size_id, ok := loaded.Sizes[l.Size.spec().Normalize()]
+		if !ok {
+			return ErrUnknownLockerSize
+		}
+		if _, ok := loaded.LockersById[l.Id]; ok {
+			return errors.New("Duplicate locker ID")
+		}
+		index := loaded.addLocker(size_id, l.Id)
+		x := &loaded.Lockers[index]
+		x.Purpose = l.Purpose
+		x.Zone = l.Zone
+		x.OutOfService = l.OutOfService
+		x.LastCleaned, x.LastEmptied, x.LastFilled = l.LastCleaned, l.LastEmptied, l.LastFilled
+		for _, size := range l.Configurable {
+			x.Configurable = append(x.Configurable, size.spec())
+		}
+		if l.TightestOffered != nil {
+			if x.TightestOffered, ok = loaded.Sizes[l.TightestOffered.spec().Normalize()]; !ok {
+				return ErrUnknownLockerSize
+			}
+		}
+
+		for i, saved := range l.Packages {
+			if _, ok := loaded.LockersByPackageId[saved.Id]; ok {
+				return ErrDuplicatePackageID
+			}
+			pkg := &Package{Id: saved.Id, Size: saved.Size.spec(), Margin: saved.Margin, PreferTight: saved.PreferTight, NoRotate: saved.NoRotate, Weight: saved.Weight}
+			if !loaded.Control[size_id].Size.Contains(pkg.Size.Normalize()) {
+				return errors.New("Package does not fit in locker")
+			}
+			if i == 0 {
+				x.Put(pkg)
+			} else {
+				x.Stacked = append(x.Stacked, pkg)
+				x.VolumeUsed += pkg.Size.Normalize().Volume()
+			}
+			loaded.LockersByPackageId[pkg.Id] = index
+		}
+
+		if l.DoorGroup != "" {
+			if loaded.doorGroups == nil {
+				loaded.doorGroups = make(map[DoorGroupID]*doorGroup)
+			}
+			g, ok := loaded.doorGroups[l.DoorGroup]
+			if !ok {
+				g = &doorGroup{}
+				loaded.doorGroups[l.DoorGroup] = g
+			}
+			g.members = append(g.members, index)
+			x.DoorGroup = l.DoorGroup
+		}
+	}
+
+	for _, id := range p.HeldDoorGroups {
+		g, ok := loaded.doorGroups[id]
+		if !ok {
+			return errors.New("Door group ID not known")
+		}
+		g.held = true
+	}
+
+	// addLocker made every locker available; replace that with the saved lists.
+	available := make(map[LockerID]bool, len(p.Lockers))
+	for _, s := range p.Sizes {
+		size_id := loaded.Sizes[s.spec().Normalize()]
+		ctrl := loaded.Control[size_id]
+		ctrl.Lockers = ctrl.Lockers[:0]
+		for _, id := range s.Available {
+			index, ok := loaded.LockersById[id]
+			if !ok || loaded.Lockers[index].SizeId != size_id || available[id] {
+				return errors.New("Available locker not known")
+			}
+			available[id] = true
+			ctrl.Lockers = append(ctrl.Lockers, index)
+		}
+	}
+
+	loaded.RepairBackPointers()
+	loaded.linkSizes()
+	loaded.ResetVirtualCapacityFromFreeLists()
+	if err := loaded.CheckInvariants(); err != nil {
+		return err
+	}
+
+	loaded.Logger, loaded.Clock, loaded.OnCapacityAlarm = inv.Logger, inv.Clock, inv.OnCapacityAlarm
+	loaded.OnDeposit, loaded.OnRetrieve = inv.OnDeposit, inv.OnRetrieve
+	loaded.Strategy, loaded.idGen = inv.Strategy, inv.idGen
+	*inv = *loaded
+	return nil
+}
@@ -0,0 +1,153 @@
+package lockers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Inventory_MarshalJSON_Basic(t *testing.T) {
+	data, err := json.Marshal(basic(t))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	var inv Inventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if eq, explanation := CompareInventories(t, &inv, basic(t)); !eq {
+		t.Errorf("Round trip changed the inventory: %s", explanation)
+	}
+	if ok, msg := ValidateInventory(t, &inv); !ok {
+		t.Errorf("Invalid inventory: %s", msg)
+	}
+}
+
+func Test_Inventory_MarshalJSON_State(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1", "s2", "s3"},
+		SizeSpec{2,2,2}: []LockerID{"b1", "b2", "g1", "g2"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.Clock = ticking_clock()
+	inv.PackingFactor = 1.5
+	inv.MaxOccupancy = 0.95
+	inv.SetMinReserve(inv.Sizes[SizeSpec{1,1,1}], 1)
+	inv.SetSizePriorityBias(inv.Sizes[SizeSpec{2,2,2}], -1)
+	inv.SetLockerPurpose("s1", PurposeReturn)
+	inv.Lockers[inv.LockersById["b1"]].Zone = "north"
+	inv.Lockers[inv.LockersById["b2"]].Configurable = []SizeSpec{SizeSpec{2,2,2}, SizeSpec{1,1,1}}
+	if err := inv.AddDoorGroup("door", []LockerID{"g2", "g1"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for _, id := range []PackageID{"a", "b"} {
+		if _, err := inv.DepositPackage(&Package{Id: id, Size: SizeSpec{1,1,1}, Margin: 1}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	if err := inv.StackPackage("b1", &Package{Id: "c", Size: SizeSpec{1,1,1}, PreferTight: true}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.StackPackage("b1", &Package{Id: "d", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.DepositPackage(&Package{Id: "e", Size: SizeSpec{1,1,1}})
+	inv.RetrievePackageById("e")
+	inv.TakeSizeOutOfService(SizeSpec{1,1,1})
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	loaded := &Inventory{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if eq, explanation := CompareInventories(t, loaded, inv); !eq {
+		t.Errorf("Round trip changed the inventory: %s", explanation)
+	}
+	again, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("Round trip was not stable:\n%s\n%s", data, again)
+	}
+
+	held := loaded.doorGroups["door"]
+	if held == nil || len(held.members) != 2 || held.held != inv.doorGroups["door"].held {
+		t.Errorf("Door group was not restored: %+v", held)
+	}
+	for _, id := range []PackageID{"a", "b", "c", "d"} {
+		if loaded.Lockers[loaded.LockersByPackageId[id]].Id != inv.Lockers[inv.LockersByPackageId[id]].Id {
+			t.Errorf("Package %s was not restored to its locker", id)
+		}
+	}
+	if l := loaded.Lockers[loaded.LockersById["b1"]]; l.Contents == nil || !l.Contents.PreferTight || len(l.Stacked) != 1 || l.VolumeUsed != 2 {
+		t.Errorf("Stacked packages were not restored: %+v", l)
+	}
+	if !loaded.Lockers[loaded.LockersById["s3"]].OutOfService || loaded.Lockers[loaded.LockersById["s1"]].Purpose != PurposeReturn {
+		t.Errorf("Locker settings were not restored")
+	}
+}
+
+func Test_Inventory_MarshalJSON_Orientation(t *testing.T) {
+	data, err := json.Marshal(NewInventory(map[SizeSpec]int{SizeSpec{1,1,3}: 1}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	loaded := &Inventory{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if _, err := loaded.DepositPackage(&Package{Id: "a", Size: SizeSpec{3,1,1}, NoRotate: true}); err != ErrNoLockerFits {
+		t.Errorf("Expected error %v, got %v", ErrNoLockerFits, err)
+	}
+	if _, err := loaded.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,3}, NoRotate: true}); err != nil {
+		t.Errorf("Upright locker lost its orientation: %s", err.Error())
+	}
+}
+
+func Test_Inventory_UnmarshalJSON_Errors(t *testing.T) {
+	valid, err := json.Marshal(NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	tests := map[string]string{
+		"not json":          `{`,
+		"duplicate size":    `{"sizes":[{"length":1,"width":1,"height":1,"available":[]},{"length":1,"width":1,"height":1,"available":[]}],"lockers":[]}`,
+		"unknown size":      `{"sizes":[],"lockers":[{"id":"1","size":{"length":1,"width":1,"height":1}}]}`,
+		"duplicate locker":  `{"sizes":[{"length":1,"width":1,"height":1,"available":[]}],"lockers":[{"id":"1","size":{"length":1,"width":1,"height":1}},{"id":"1","size":{"length":1,"width":1,"height":1}}]}`,
+		"unknown available": `{"sizes":[{"length":1,"width":1,"height":1,"available":["2"]}],"lockers":[{"id":"1","size":{"length":1,"width":1,"height":1}}]}`,
+		"occupied available": `{"sizes":[{"length":1,"width":1,"height":1,"available":["1"]}],"lockers":[{"id":"1","size":{"length":1,"width":1,"height":1},"packages":[{"id":"a","size":{"length":1,"width":1,"height":1}}]}]}`,
+		"too big":           `{"sizes":[{"length":1,"width":1,"height":1,"available":[]}],"lockers":[{"id":"1","size":{"length":1,"width":1,"height":1},"packages":[{"id":"a","size":{"length":2,"width":1,"height":1}}]}]}`,
+		"unknown group":     `{"sizes":[],"lockers":[],"held_door_groups":["door"]}`,
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := &Inventory{}
+			if err := json.Unmarshal(valid, inv); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if err := json.Unmarshal([]byte(v), inv); err == nil {
+				t.Errorf("Expected error")
+			}
+			if len(inv.Lockers) != 1 || !strings.Contains(string(valid), string(inv.Lockers[0].Id)) {
+				t.Errorf("Failed load changed the inventory")
+			}
+		})
+	}
+
+	// the clock and logger of the destination are kept.
+	inv := &Inventory{Clock: func() time.Time { return time.Time{} }}
+	if err := json.Unmarshal(valid, inv); err != nil || inv.Clock == nil {
+		t.Errorf("Load did not keep the clock: %v", err)
+	}
+}
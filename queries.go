@@ -0,0 +1,747 @@
+package lockers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// Counts of the lockers in some group, split by whether they are available. As in
+// Report and CapacitySnapshot, a locker is free if it could take a deposit now, so an
+// empty locker which is out of service or held by its door group counts as occupied.
+type LockerCounts struct {
+	Total, Free, Occupied int
+}
+
+// adds a single locker to a set of counts.
+func (c *LockerCounts) add(free bool) {
+	c.Total += 1
+	if free {
+		c.Free += 1
+	} else {
+		c.Occupied += 1
+	}
+}
+
+// A summary of a bank of lockers: overall counts, counts for each size of locker
+// within the bank, and the number of requested IDs which did not match any locker.
+type BankStats struct {
+	LockerCounts
+
+	BySize map[LockerSize]LockerCounts
+	Unknown int
+}
+
+// Summarizes a specific set of lockers, such as one physical wall of them.
+// Unknown IDs are skipped and counted in BankStats.Unknown rather than returned
+// as an error, so that a stale bank layout still produces a useful report.
+// IDs which appear more than once are only counted once.
+func (inv *Inventory) BankSummary(ids []LockerID) BankStats {
+	stats := BankStats{
+		BySize: make(map[LockerSize]LockerCounts),
+	}
+
+	available := make(map[int]bool)
+	for _, ctrl := range inv.Control {
+		for _, index := range ctrl.Lockers {
+			available[index] = true
+		}
+	}
+
+	seen := make(map[LockerID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] { continue }
+		seen[id] = true
+
+		index, ok := inv.LockersById[id]
+		if !ok {
+			stats.Unknown += 1
+			continue
+		}
+
+		l := &inv.Lockers[index]
+		stats.add(available[index])
+		by_size := stats.BySize[l.SizeId]
+		by_size.add(available[index])
+		stats.BySize[l.SizeId] = by_size
+	}
+
+	return stats
+}
+
+// Reports the size classes which the selection strategy would currently never choose,
+// sorted by size id, to help prune a bloated catalog. A size is reported if some larger
+// size which contains it has available lockers and comes Before it: every package which
+// fits the smaller size then also fits the larger one, which would always win. This
+// depends on current capacities, so it is only a snapshot. With transitive capacity a
+// size can never have less capacity than a size containing it, so sizes are usually
+// only reported when capacity is direct (see Inventory.TransitiveCapacity).
+func (inv *Inventory) RedundantSizes() []LockerSize {
+	var redundant []LockerSize
+	for _, size_id := range inv.sizeIds() {
+		for _, other_id := range inv.Control[size_id].SmallerThan {
+			if inv.Control[other_id].Full() { continue }
+			if other_id.Before(size_id, inv) {
+				redundant = append(redundant, size_id)
+				break
+			}
+		}
+	}
+
+	return redundant
+}
+
+// counts the lockers of each size, whether available or not.
+func (inv *Inventory) lockerCounts() map[LockerSize]int {
+	counts := make(map[LockerSize]int, len(inv.Control))
+	for i := range inv.Lockers {
+		counts[inv.Lockers[i].SizeId] += 1
+	}
+	return counts
+}
+
+// Reports the size classes which have no lockers at all, sorted by size id. Such a size
+// can never take a deposit, but is still linked with the sizes around it, so it only
+// adds to the cost of every capacity adjustment. Sizes which have lockers, but none
+// available at the moment, are not reported.
+func (inv *Inventory) UnusableSizes() []LockerSize {
+	counts := inv.lockerCounts()
+
+	var unusable []LockerSize
+	for _, size_id := range inv.sizeIds() {
+		if counts[size_id] == 0 {
+			unusable = append(unusable, size_id)
+		}
+	}
+	return unusable
+}
+
+// Returns how many packages each size of locker has taken per locker over the lifetime
+// of the inventory (see LockerControlSpec.Deposits), a measure of how hard each size
+// is worked. Sizes with high turnover are candidates for more lockers. Sizes with no
+// lockers are left out, rather than reported with a turnover of 0 or infinity.
+func (inv *Inventory) TurnoverBySize() map[LockerSize]float64 {
+	counts := inv.lockerCounts()
+	turnover := make(map[LockerSize]float64, len(counts))
+	for size_id, count := range counts {
+		if count == 0 { continue }
+		turnover[size_id] = float64(inv.Control[size_id].Deposits) / float64(count)
+	}
+	return turnover
+}
+
+// Reports, for each size of package in demand, how many more packages of that size are
+// expected than there are lockers available to take them, or 0 if there is room for all
+// of them. Room for a package is every available locker of an active size which can
+// contain it, whether or not TransitiveCapacity is on; the package sizes need not be
+// sizes of locker. Each size is considered on its own, so lockers which could hold
+// several of the demanded sizes count towards all of them, and meeting every demand
+// at once may need more than the sum suggests.
+func (inv *Inventory) Deficit(demand map[SizeSpec]int) map[SizeSpec]int {
+	deficit := make(map[SizeSpec]int, len(demand))
+	for size, count := range demand {
+		normalized := size.Normalize()
+		available := 0
+		for locker_size, size_id := range inv.Sizes {
+			ctrl := inv.Control[size_id]
+			if ctrl.Inactive || !locker_size.Contains(normalized) { continue }
+			available += len(ctrl.Lockers)
+		}
+
+		if count > available {
+			deficit[size] = count - available
+		} else {
+			deficit[size] = 0
+		}
+	}
+	return deficit
+}
+
+// Checks, in O(1), whether any locker of exactly the given size class is available,
+// without considering larger sizes which could also hold a package of that size.
+// Returns false if the size is not in the catalog.
+func (inv *Inventory) HasFreeExact(size SizeSpec) bool {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return false
+	}
+	return !inv.Control[size_id].Full() && !inv.Control[size_id].Inactive
+}
+
+// Finds the locker a package is stored in, without retrieving it. Returns the locker's
+// ID and true, or "" and false if the package is not stored in the inventory.
+func (inv *Inventory) GetPackageLocation(id PackageID) (LockerID, bool) {
+	locker_index, ok := inv.LockersByPackageId[id]
+	if !ok {
+		return "", false
+	}
+	return inv.Lockers[locker_index].Id, true
+}
+
+// Counts the available lockers of every size class which can contain a package of the
+// given size, whether or not they are the tightest fit for it. Unlike VirtualCapacity,
+// which is kept per size class, this works for any package size. Inactive sizes count
+// as having no available lockers.
+func (inv *Inventory) AvailableCapacityFor(size SizeSpec) int {
+	size = size.Normalize()
+
+	count := 0
+	for s, size_id := range inv.Sizes {
+		if !s.Contains(size) { continue }
+		count += inv.CountAvailableLockers(size_id)
+	}
+	return count
+}
+
+// Counts the available lockers of exactly one size class, or 0 if the size is not known
+// or is inactive.
+func (inv *Inventory) CountAvailableLockers(size_id LockerSize) int {
+	ctrl, ok := inv.Control[size_id]
+	if !ok || ctrl.Inactive || ctrl.Full() {
+		return 0
+	}
+	return len(ctrl.Lockers)
+}
+
+// Sets the heaviest load a locker of a size is rated for. See
+// LockerControlSpec.MaxWeight. Packages already stored are not checked against it.
+func (inv *Inventory) SetMaxWeight(size_id LockerSize, max int) error {
+	ctrl, ok := inv.Control[size_id]
+	if !ok {
+		return ErrUnknownLockerSize
+	}
+	if max < 0 {
+		return errors.New("Weight limit must not be negative")
+	}
+
+	ctrl.MaxWeight = max
+	return nil
+}
+
+// Sets the number of available lockers of a size which should be kept in reserve.
+func (inv *Inventory) SetMinReserve(size_id LockerSize, reserve int) error {
+	ctrl, ok := inv.Control[size_id]
+	if !ok {
+		return ErrUnknownLockerSize
+	}
+	if reserve < 0 {
+		return errors.New("Reserve must not be negative")
+	}
+
+	ctrl.MinReserve = reserve
+	return nil
+}
+
+// Returns how many more packages a size can take before its available lockers drop
+// to its MinReserve, or 0 if it is already at or below it. With no reserve this is
+// just the number of available lockers. Unknown sizes have no headroom.
+func (inv *Inventory) Headroom(size_id LockerSize) int {
+	ctrl, ok := inv.Control[size_id]
+	if !ok || ctrl.Inactive {
+		return 0
+	}
+
+	headroom := len(ctrl.Lockers) - ctrl.MinReserve
+	if headroom < 0 {
+		return 0
+	}
+	return headroom
+}
+
+// returns the volume of an occupied locker which is not taken up by its packages,
+// including any stacked on top of its contents.
+func (inv *Inventory) wastedVolumeAt(locker_index int) int64 {
+	l := &inv.Lockers[locker_index]
+	waste := inv.Control[l.SizeId].Size.Volume() - l.VolumeUsed
+	if waste < 0 {
+		return 0
+	}
+	return waste
+}
+
+// Returns the total volume of occupied lockers which is not taken up by the packages
+// in them, a measure of space wasted by placing packages in oversized lockers.
+func (inv *Inventory) WastedVolume() int64 {
+	var total int64
+	for i := range inv.Lockers {
+		if inv.Lockers[i].Contents != nil {
+			total += inv.wastedVolumeAt(i)
+		}
+	}
+	return total
+}
+
+// Returns the volume of the locker holding a package which is not taken up by it, as
+// counted by WastedVolume, or ErrUnknownPackageID if the package is not stored. For a
+// package stacked with others, this is the waste of their shared locker.
+func (inv *Inventory) WastedVolumeFor(id PackageID) (int64, error) {
+	index, ok := inv.LockersByPackageId[id]
+	if !ok {
+		return 0, ErrUnknownPackageID
+	}
+	return inv.wastedVolumeAt(index), nil
+}
+
+// Returns the n stored packages whose lockers waste the most volume on them (see
+// WastedVolume), most wasteful first, or all of them if there are fewer than n.
+// Packages with equal waste are ordered by ID. Stacked packages share their locker's
+// space, so only lockers holding a single package are considered.
+func (inv *Inventory) TopWaste(n int) []PackageID {
+	if n <= 0 {
+		return nil
+	}
+
+	type entry struct {
+		id PackageID
+		waste int64
+	}
+	var entries []entry
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil || len(l.Stacked) != 0 { continue }
+		entries = append(entries, entry{l.Contents.Id, inv.wastedVolumeAt(i)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].waste != entries[j].waste {
+			return entries[i].waste > entries[j].waste
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	if len(entries) < n {
+		n = len(entries)
+	}
+	var ids []PackageID
+	for _, e := range entries[:n] {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// Returns the fraction of stored packages which are in a larger size of locker than
+// the tightest size in the catalog which could hold them (see BinPackage), from 0 if
+// every package is in its tightest size, to 1 if none are. An inventory with no
+// packages scores 0.
+func (inv *Inventory) FragmentationScore() float64 {
+	stored, misplaced := 0, 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		stored += 1
+		if tightest_id, err := inv.BinPackage(l.Contents.RequiredSize()); err == nil && tightest_id != l.SizeId {
+			misplaced += 1
+		}
+	}
+
+	if stored == 0 {
+		return 0
+	}
+	return float64(misplaced) / float64(stored)
+}
+
+// Counts stored packages by their normalized size, whatever size of locker holds them,
+// so packages of the same size in any orientation are counted together. Stacked
+// packages are counted along with the contents of their lockers.
+func (inv *Inventory) PackageSizeHistogram() map[SizeSpec]int {
+	histogram := make(map[SizeSpec]int)
+	inv.EachPackage(func(pkg *Package, locker LockerID) bool {
+		histogram[pkg.Size.Normalize()] += 1
+		return true
+	})
+	return histogram
+}
+
+// Counts stored packages by how long they have been in their lockers as of now (see
+// Locker.LastFilled). buckets holds the upper bound of each bucket in strictly
+// ascending order, and a package whose dwell time is less than buckets[i] but not
+// less than buckets[i-1] is counted in bucket i. The result has one more element than
+// buckets, the last counting packages at least as old as the final bound. For example,
+// buckets of 1h and 24h give counts for under an hour, one to 24 hours, and longer.
+// Packages stacked into a locker are counted too, dated by when the locker was filled,
+// as in OldestPackages. Returns nil if the buckets are not in strictly ascending order.
+func (inv *Inventory) AgeHistogram(now time.Time, buckets []time.Duration) []int {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i - 1] {
+			return nil
+		}
+	}
+
+	counts := make([]int, len(buckets) + 1)
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		dwell := now.Sub(l.LastFilled)
+		bucket := sort.Search(len(buckets), func(j int) bool { return dwell < buckets[j] })
+		counts[bucket] += 1 + len(l.Stacked)
+	}
+	return counts
+}
+
+// Returns the k packages which have been stored the longest (see Locker.LastFilled),
+// oldest first, or all of them if there are fewer than k, such as to choose which to
+// clear out to free up room. Packages stacked into a locker are dated by when the
+// locker was filled, so they may be listed as older than they are. Packages stored at
+// the same time are ordered by ID.
+func (inv *Inventory) OldestPackages(k int) []PackageID {
+	if k <= 0 {
+		return nil
+	}
+
+	type entry struct {
+		id PackageID
+		filled time.Time
+	}
+	var entries []entry
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			entries = append(entries, entry{p.Id, l.LastFilled})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].filled.Equal(entries[j].filled) {
+			return entries[i].filled.Before(entries[j].filled)
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	if len(entries) < k {
+		k = len(entries)
+	}
+	var ids []PackageID
+	for _, e := range entries[:k] {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// Returns the next size down from current which can still hold a package of the given
+// size: of the sizes which fit inside current (its BiggerThan edges) and contain the
+// package, the largest one. Moving an oversized package one step at a time this way
+// frees up the bigger lockers first. Returns false if current is unknown, or no size
+// smaller than it can hold the package.
+func (inv *Inventory) NextTighterSize(current LockerSize, pkg SizeSpec) (LockerSize, bool) {
+	ctrl, ok := inv.Control[current]
+	if !ok {
+		return LockerSize(0), false
+	}
+	pkg = pkg.Normalize()
+
+	var best_id LockerSize
+	var best SizeSpec
+	for _, size_id := range ctrl.BiggerThan {
+		s := inv.Control[size_id].Size
+		if !s.Contains(pkg) { continue }
+		if best_id == LockerSize(0) || best.tighterThan(s) {
+			best_id, best = size_id, s
+		}
+	}
+	return best_id, best_id != LockerSize(0)
+}
+
+// Returns the IDs of stored packages which went into a bigger locker than they needed:
+// when each was deposited, a tighter size than the one chosen had a usable locker (see
+// Locker.TightestOffered). This measures how often the selection strategy gives up
+// space in exchange for keeping scarce sizes free. Packages which have been moved are
+// judged by the locker they are in now, and those stacked into a locker have no
+// record and are not counted. The result is sorted.
+func (inv *Inventory) OverprovisionedPackages() []PackageID {
+	var ids []PackageID
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil || l.TightestOffered == LockerSize(0) { continue }
+
+		offered, ok := inv.Control[l.TightestOffered]
+		if ok && offered.Size.tighterThan(inv.Control[l.SizeId].Size) {
+			ids = append(ids, l.Contents.Id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Returns the IDs of a size's available lockers in the order AllocateLocker would hand
+// them out, which is the reverse of the size's list of available lockers. Deposits
+// follow the same order, but skip lockers they cannot use, such as dirty ones or ones
+// set aside for the other direction. This is a debugging aid for working out which
+// locker a sequence of deposits will reach. Returns nil if the size is not known.
+func (inv *Inventory) AllocationOrder(size LockerSize) []LockerID {
+	ctrl, ok := inv.Control[size]
+	if !ok {
+		return nil
+	}
+
+	ids := make([]LockerID, 0, len(ctrl.Lockers))
+	for i := len(ctrl.Lockers) - 1; i >= 0; i-- {
+		ids = append(ids, inv.Lockers[ctrl.Lockers[i]].Id)
+	}
+	return ids
+}
+
+// Returns where a size's VirtualCapacity comes from: for the size itself and, if
+// capacity is transitive, every larger size which can contain it (its SmallerThan
+// edges), how many available lockers each contributes. Sizes which contribute none
+// at the moment, such as full or inactive ones, are included with a count of zero.
+// The counts add up to the size's VirtualCapacity. Returns nil if the size is not known.
+func (inv *Inventory) CapacityBreakdown(size LockerSize) map[LockerSize]int {
+	ctrl, ok := inv.Control[size]
+	if !ok {
+		return nil
+	}
+
+	free := func(ctrl *LockerControlSpec) int {
+		if ctrl.Inactive {
+			return 0
+		}
+		return len(ctrl.Lockers)
+	}
+
+	breakdown := map[LockerSize]int{size: free(ctrl)}
+	if !inv.TransitiveCapacity {
+		return breakdown
+	}
+	for _, other_id := range ctrl.SmallerThan {
+		breakdown[other_id] = free(inv.Control[other_id])
+	}
+	return breakdown
+}
+
+// Returns the IDs of stored packages which could not physically be in the locker they
+// are recorded in, because the locker's size does not contain them. Deposits never do
+// this, so any result points to corrupted or badly hand-edited state. This checks
+// only fit; see CheckInvariants for the inventory's bookkeeping. The result is sorted.
+func (inv *Inventory) VerifyPlacements() []PackageID {
+	var ids []PackageID
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		size := inv.Control[l.SizeId].Size
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			if !size.Contains(p.Size.Normalize()) {
+				ids = append(ids, p.Id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Returns the IDs of the packages in LockersByPackageId whose entries are wrong: they
+// point at a locker which does not exist, or which does not hold the package, either
+// as its contents or stacked. Such entries can only come from a bug or a bad manual
+// edit. Nothing is repaired, so that the problem can be reported first. The IDs are
+// sorted. See also CheckInvariants.
+func (inv *Inventory) OrphanedPackageIds() []PackageID {
+	var ids []PackageID
+	for id, index := range inv.LockersByPackageId {
+		if index < 0 || index >= len(inv.Lockers) || !inv.Lockers[index].holds(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Returns one page of the IDs of the packages in the inventory, skipping the first
+// offset of them and returning at most limit, along with the total number of packages.
+// Packages are ordered by the index of their lockers, and a locker's stacked packages
+// follow its contents, so pages are stable as long as the inventory does not change.
+// An offset beyond the end, or a limit which is not positive, gives an empty page, and
+// a negative offset is treated as 0.
+func (inv *Inventory) PackagesPage(offset, limit int) ([]PackageID, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	var ids []PackageID
+	total := 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			if total >= offset && total - offset < limit {
+				ids = append(ids, p.Id)
+			}
+			total += 1
+		}
+	}
+	return ids, total
+}
+
+// Calls fn with each package stored in the inventory and the ID of its locker, in the
+// order of PackagesPage, until fn returns false. Packages are gathered before fn is
+// first called, so fn sees the inventory as it was, even if it changes the inventory.
+// Packages found in lockers but not known to the inventory (see OrphanedPackageIds)
+// are skipped.
+func (inv *Inventory) EachPackage(fn func(pkg *Package, locker LockerID) bool) {
+	type stored struct {
+		pkg *Package
+		locker LockerID
+	}
+
+	var all []stored
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		for _, p := range append([]*Package{l.Contents}, l.Stacked...) {
+			if index, ok := inv.LockersByPackageId[p.Id]; ok && index == i {
+				all = append(all, stored{p, l.Id})
+			}
+		}
+	}
+
+	for _, x := range all {
+		if !fn(x.pkg, x.locker) {
+			return
+		}
+	}
+}
+
+// Returns the packages in lockers of the given size, such as to empty them all, in the
+// order of PackagesPage. Stacked packages are included along with the contents of
+// their lockers. An unknown size, or one with no occupied lockers, gives no packages.
+func (inv *Inventory) PackagesInSize(size_id LockerSize) []*Package {
+	var pkgs []*Package
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.SizeId != size_id || l.Contents == nil { continue }
+		pkgs = append(pkgs, l.Contents)
+		pkgs = append(pkgs, l.Stacked...)
+	}
+	return pkgs
+}
+
+// Returns the size with the smallest volume which has at least one available locker,
+// and true, or false if every size is full. Sizes with the same volume are chosen
+// between by the lowest LockerSize, so the result is deterministic. Inactive sizes
+// are skipped, since they take no deposits.
+func (inv *Inventory) SmallestFreeSize() (LockerSize, bool) {
+	var best_id LockerSize
+	for size_id, ctrl := range inv.Control {
+		if ctrl.Full() || ctrl.Inactive { continue }
+		if best_id == LockerSize(0) {
+			best_id = size_id
+			continue
+		}
+
+		c := ctrl.Size.compareVolume(inv.Control[best_id].Size)
+		if c < 0 || (c == 0 && size_id < best_id) {
+			best_id = size_id
+		}
+	}
+	return best_id, best_id != LockerSize(0)
+}
+
+// A consistent view of an inventory's capacity, taken all at once. See
+// Inventory.CapacitySnapshot.
+type CapacitySnapshot struct {
+	// the number of lockers, and how many of them are available.
+	Total, Free int
+
+	// for each size, how many of its lockers are available, and its VirtualCapacity.
+	// The lockers of an inactive size take no deposits, so none of them are counted as
+	// available, here or in Free.
+	FreeBySize map[LockerSize]int
+	VirtualCapacityBySize map[LockerSize]int
+}
+
+// Captures the inventory's capacity in a single pass, so that a display built from it
+// is consistent even if the inventory changes while it is being read. The snapshot
+// shares nothing with the inventory, and later changes to either do not affect the
+// other.
+func (inv *Inventory) CapacitySnapshot() CapacitySnapshot {
+	snap := CapacitySnapshot{
+		Total: len(inv.Lockers),
+		FreeBySize: make(map[LockerSize]int, len(inv.Control)),
+		VirtualCapacityBySize: make(map[LockerSize]int, len(inv.Control)),
+	}
+	for size_id, ctrl := range inv.Control {
+		snap.VirtualCapacityBySize[size_id] = ctrl.VirtualCapacity
+		if ctrl.Inactive {
+			snap.FreeBySize[size_id] = 0
+			continue
+		}
+		snap.Free += len(ctrl.Lockers)
+		snap.FreeBySize[size_id] = len(ctrl.Lockers)
+	}
+	return snap
+}
+
+// Returns a fingerprint of the inventory's size catalog: which sizes it has, and how
+// many lockers of each. Everything else, such as locker IDs, occupancy and settings,
+// is ignored, so two inventories created from equivalent NewInventory input always
+// share a fingerprint, and a change of catalog almost certainly changes it. This makes
+// it a cheap way to detect drift from a saved configuration. The fingerprint is the
+// 64 bit FNV-1a hash of each size's Hash and locker count, as little endian 64 bit
+// integers, taken from smallest size to largest, in hexadecimal. It is stable across
+// runs, processes and platforms.
+func (inv *Inventory) ConfigFingerprint() string {
+	counts := inv.lockerCounts()
+
+	ids := inv.sizeIds()
+	sort.Slice(ids, func(i, j int) bool { return inv.Control[ids[i]].Size.tighterThan(inv.Control[ids[j]].Size) })
+
+	h := fnv.New64a()
+	var buf [16]byte
+	for _, size_id := range ids {
+		binary.LittleEndian.PutUint64(buf[0:8], inv.Control[size_id].Size.Hash())
+		binary.LittleEndian.PutUint64(buf[8:16], uint64(counts[size_id]))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Occupancy figures for one size of locker. See Inventory.Report.
+type LockerStats struct {
+	Size SizeSpec
+
+	// the number of lockers of this size, and how many of them are not available.
+	Total, Occupied, Available int
+
+	// the fraction of lockers which are occupied, from 0 to 1, or 0 if there are no
+	// lockers of this size.
+	Utilization float64
+}
+
+// Reports the occupancy of every size of locker, including sizes with no lockers.
+// Any locker which is not available counts as occupied, so Occupied is always Total
+// less Available.
+func (inv *Inventory) Report() map[LockerSize]LockerStats {
+	counts := inv.lockerCounts()
+	report := make(map[LockerSize]LockerStats, len(inv.Control))
+	for size_id, ctrl := range inv.Control {
+		stats := LockerStats{
+			Size: ctrl.Size,
+			Total: counts[size_id],
+			Available: len(ctrl.Lockers),
+		}
+		stats.Occupied = stats.Total - stats.Available
+		if stats.Total != 0 {
+			stats.Utilization = float64(stats.Occupied) / float64(stats.Total)
+		}
+		report[size_id] = stats
+	}
+	return report
+}
+
+// Returns the fraction of all lockers which are occupied, as Report, from 0 to 1, or 0
+// if the inventory has no lockers.
+func (inv *Inventory) OverallUtilization() float64 {
+	if len(inv.Lockers) == 0 {
+		return 0
+	}
+	free := 0
+	for _, ctrl := range inv.Control {
+		free += len(ctrl.Lockers)
+	}
+	return float64(len(inv.Lockers) - free) / float64(len(inv.Lockers))
+}
@@ -0,0 +1,1018 @@
+package lockers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Inventory_BankSummary(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+
+	type X struct {
+		ids []LockerID
+		result BankStats
+	}
+
+	tests := map[string]X{
+		"empty": X{nil, BankStats{BySize: map[LockerSize]LockerCounts{}}},
+		"one-size": X{[]LockerID{"1", "2"}, BankStats{
+			LockerCounts: LockerCounts{2, 2, 0},
+			BySize: map[LockerSize]LockerCounts{100: LockerCounts{2, 2, 0}},
+		}},
+		"mixed": X{[]LockerID{"1", "3", "locker", "7"}, BankStats{
+			LockerCounts: LockerCounts{4, 3, 1},
+			BySize: map[LockerSize]LockerCounts{
+				100: LockerCounts{1, 1, 0},
+				200: LockerCounts{2, 1, 1},
+				400: LockerCounts{1, 1, 0},
+			},
+		}},
+		"unknown-and-repeated": X{[]LockerID{"1", "nope", "1", "also-nope"}, BankStats{
+			LockerCounts: LockerCounts{1, 1, 0},
+			BySize: map[LockerSize]LockerCounts{100: LockerCounts{1, 1, 0}},
+			Unknown: 2,
+		}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			out := inv.BankSummary(v.ids)
+			if out.LockerCounts != v.result.LockerCounts || out.Unknown != v.result.Unknown {
+				t.Errorf("Wrong summary: expected %+v, got %+v", v.result, out)
+			}
+			if len(out.BySize) != len(v.result.BySize) {
+				t.Errorf("Wrong per-size summary: expected %+v, got %+v", v.result.BySize, out.BySize)
+			}
+			for size_id, counts := range v.result.BySize {
+				if out.BySize[size_id] != counts {
+					t.Errorf("Wrong summary for size %d: expected %+v, got %+v", size_id, counts, out.BySize[size_id])
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_BankSummary_Unavailable(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{1,1,1}: []LockerID{"1", "2", "3", "4"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.SetLockerStatus("1", true); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.AddDoorGroup("door", []LockerID{"2", "3"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.DepositIntoLocker("2", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// only locker 4 is available: 1 is out of service, and 3 is held with 2.
+	ids := []LockerID{"1", "2", "3", "4"}
+	expected := LockerCounts{4, 1, 3}
+	out := inv.BankSummary(ids)
+	if out.LockerCounts != expected || out.BySize[inv.Sizes[SizeSpec{1,1,1}]] != expected {
+		t.Errorf("Wrong summary: expected %+v, got %+v", expected, out)
+	}
+
+	snap, report := inv.CapacitySnapshot(), inv.Report()[inv.Sizes[SizeSpec{1,1,1}]]
+	if snap.Free != out.Free || report.Available != out.Free || report.Occupied != out.Occupied {
+		t.Errorf("Free lockers counted differently: summary %+v, snapshot %+v, report %+v", out.LockerCounts, snap, report)
+	}
+}
+
+func Test_Inventory_RedundantSizes(t *testing.T) {
+	inv1, inv2, inv3 := cplx(t), cplx(t), cplx(t)
+	inv2.SetTransitiveCapacity(false)
+
+	// inv3 has direct capacity and no available {5,1,1} lockers
+	inv3.Control[200].Lockers = nil
+	inv3.SetTransitiveCapacity(false)
+
+	type X struct {
+		inv *Inventory
+		answer []LockerSize
+	}
+
+	tests := map[string]X{
+		"transitive": X{inv1, nil},
+		"direct":     X{inv2, []LockerSize{100}},
+		// {1,1,1} is no longer beaten, but now {5,5,5} beats {5,1,1}
+		"direct-container-full": X{inv3, []LockerSize{200}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			out := v.inv.RedundantSizes()
+			if len(out) != len(v.answer) {
+				t.Errorf("Wrong answer: expected %v, got %v", v.answer, out)
+				return
+			}
+			for i := range out {
+				if out[i] != v.answer[i] {
+					t.Errorf("Wrong answer: expected %v, got %v", v.answer, out)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_UnusableSizes(t *testing.T) {
+	empty := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 0, SizeSpec{3,3,3}: 0})
+	full := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 0})
+	full.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+
+	type X struct {
+		inv *Inventory
+		answer []LockerSize
+	}
+
+	tests := map[string]X{
+		"none":           X{basic(t), nil},
+		"empty sizes":    X{empty, []LockerSize{empty.Sizes[SizeSpec{2,2,2}], empty.Sizes[SizeSpec{3,3,3}]}},
+		"full is usable": X{full, []LockerSize{full.Sizes[SizeSpec{2,2,2}]}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if out := v.inv.UnusableSizes(); !reflect.DeepEqual(out, v.answer) {
+				t.Errorf("Wrong answer: expected %v, got %v", v.answer, out)
+			}
+		})
+	}
+}
+
+func Test_Inventory_TurnoverBySize(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,3}: 0})
+	inv.Clock = ticking_clock()
+	small, big, empty := inv.Sizes[SizeSpec{1,1,1}], inv.Sizes[SizeSpec{2,2,2}], inv.Sizes[SizeSpec{3,3,3}]
+
+	for i, id := range []PackageID{"a", "b", "c"} {
+		if _, err := inv.DepositPackageAllowDirty(&Package{Id: id, Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if i == 0 {
+			inv.RetrievePackageById(id)
+		}
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "d", Size: SizeSpec{2,2,2}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	turnover := inv.TurnoverBySize()
+	expected := map[LockerSize]float64{small: 1.5, big: 1}
+	if !reflect.DeepEqual(turnover, expected) {
+		t.Errorf("Unexpected turnover: got %v, expected %v", turnover, expected)
+	}
+	if _, ok := turnover[empty]; ok {
+		t.Errorf("Size with no lockers was reported")
+	}
+}
+
+func Test_Inventory_Deficit(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 1, SizeSpec{3,3,1}: 1})
+	inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+
+	demand := map[SizeSpec]int{
+		SizeSpec{1,1,1}: 5,
+		SizeSpec{2,2,1}: 1,
+		SizeSpec{1,2,2}: 4,
+		SizeSpec{3,3,3}: 2,
+		SizeSpec{3,3,1}: 0,
+	}
+	expected := map[SizeSpec]int{
+		SizeSpec{1,1,1}: 2,
+		SizeSpec{2,2,1}: 0,
+		SizeSpec{1,2,2}: 2,
+		SizeSpec{3,3,3}: 2,
+		SizeSpec{3,3,1}: 0,
+	}
+	if deficit := inv.Deficit(demand); !reflect.DeepEqual(deficit, expected) {
+		t.Errorf("Expected %v, got %v", expected, deficit)
+	}
+
+	inv.DeactivateSize(SizeSpec{3,3,1})
+	if deficit := inv.Deficit(map[SizeSpec]int{SizeSpec{2,2,1}: 2}); deficit[SizeSpec{2,2,1}] != 1 {
+		t.Errorf("Inactive size counted towards capacity: %v", deficit)
+	}
+}
+
+func Test_Inventory_HasFreeExact(t *testing.T) {
+	inv := basic(t)
+
+	type X struct {
+		size SizeSpec
+		answer bool
+	}
+
+	tests := map[string]X{
+		"small":        X{SizeSpec{1,1,1}, true},
+		"medium":       X{SizeSpec{2,2,2}, true},
+		"full":         X{SizeSpec{4,4,4}, false},
+		"unknown":      X{SizeSpec{2,1,1}, false},
+		"denormalized": X{SizeSpec{-3,3,3}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if inv.HasFreeExact(v.size) != v.answer {
+				t.Errorf("HasFreeExact %v (%t, expected %t)", v.size, !v.answer, v.answer)
+			}
+		})
+	}
+}
+
+func Test_Inventory_GetPackageLocation(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1"},
+		SizeSpec{2,2,2}: []LockerID{"b1"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "b1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+	inv.StackPackage("b1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+	before := inv.clone()
+
+	type X struct {
+		locker_id LockerID
+		ok bool
+	}
+
+	tests := map[PackageID]X{
+		"a": X{"b1", true},
+		"b": X{"b1", true},
+		"c": X{"", false},
+	}
+
+	for k, v := range tests {
+		t.Run(string(k), func(t *testing.T) {
+			locker_id, ok := inv.GetPackageLocation(k)
+			if locker_id != v.locker_id || ok != v.ok {
+				t.Errorf("Expected %q %t, got %q %t", v.locker_id, v.ok, locker_id, ok)
+			}
+		})
+	}
+
+	if eq, explanation := CompareInventories(t, inv, before); !eq {
+		t.Errorf("Lookup changed the inventory: %s", explanation)
+	}
+}
+
+func Test_Inventory_AvailableCapacityFor(t *testing.T) {
+	inv := basic(t)
+	inv.DeactivateSize(SizeSpec{3,3,3})
+
+	type X struct {
+		size SizeSpec
+		count int
+	}
+
+	tests := map[string]X{
+		"small":        X{SizeSpec{1,1,1}, 4},
+		"between":      X{SizeSpec{2,1,1}, 2},
+		"medium":       X{SizeSpec{2,2,2}, 2},
+		"large":        X{SizeSpec{3,3,3}, 0},
+		"too big":      X{SizeSpec{5,5,5}, 0},
+		"denormalized": X{SizeSpec{-2,1,2}, 2},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if count := inv.AvailableCapacityFor(v.size); count != v.count {
+				t.Errorf("AvailableCapacityFor %v: expected %d, got %d", v.size, v.count, count)
+			}
+		})
+	}
+}
+
+func Test_Inventory_CountAvailableLockers(t *testing.T) {
+	inv := basic(t)
+	inv.DeactivateSize(SizeSpec{3,3,3})
+
+	tests := map[LockerSize]int{
+		100: 2,
+		200: 2,
+		300: 0,
+		400: 0,
+		500: 0,
+	}
+
+	for k, v := range tests {
+		if count := inv.CountAvailableLockers(k); count != v {
+			t.Errorf("CountAvailableLockers %d: expected %d, got %d", k, v, count)
+		}
+	}
+}
+
+func Test_Inventory_Headroom(t *testing.T) {
+	type X struct {
+		size_id LockerSize
+		reserve int
+		headroom int
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"no-reserve":    X{200, 0, 3, false},
+		"some-reserve":  X{200, 2, 1, false},
+		"at-reserve":    X{200, 3, 0, false},
+		"below-reserve": X{200, 5, 0, false},
+		"negative":      X{200, -1, 3, true},
+		"unknown":       X{500, 1, 0, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			err := inv.SetMinReserve(v.size_id, v.reserve)
+			if (err != nil) != v.is_error {
+				t.Errorf("Unexpected error result: %v", err)
+			}
+
+			if h := inv.Headroom(v.size_id); h != v.headroom {
+				t.Errorf("Wrong headroom: expected %d, got %d", v.headroom, h)
+			}
+		})
+	}
+}
+
+func Test_Inventory_AgeHistogram(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	inv := basic(t)
+	var clock time.Time
+	inv.Clock = func() time.Time { return clock }
+
+	for i, offset := range []time.Duration{0, 20 * time.Hour, 29 * time.Hour, 30 * time.Hour + 30 * time.Minute} {
+		clock = start.Add(offset)
+		pkg := &Package{Id: PackageID(string(rune('a' + i))), Size: SizeSpec{1,1,1}}
+		if _, err := inv.DepositPackage(pkg); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	// stacked packages are dated by their locker, so this one is as old as d.
+	if err := inv.StackPackage(inv.Lockers[inv.LockersByPackageId["d"]].Id, &Package{Id: "e", Size: SizeSpec{0,0,0}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	now := start.Add(31 * time.Hour)
+
+	type X struct {
+		buckets []time.Duration
+		counts []int
+	}
+
+	tests := map[string]X{
+		"no buckets":    X{nil, []int{5}},
+		"hour and day":  X{[]time.Duration{time.Hour, 24 * time.Hour}, []int{2, 2, 1}},
+		"on boundary":   X{[]time.Duration{2 * time.Hour}, []int{2, 3}},
+		"all overflow":  X{[]time.Duration{time.Minute}, []int{0, 5}},
+		"descending":    X{[]time.Duration{24 * time.Hour, time.Hour}, nil},
+		"repeated":      X{[]time.Duration{time.Hour, time.Hour}, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if counts := inv.AgeHistogram(now, v.buckets); !reflect.DeepEqual(counts, v.counts) {
+				t.Errorf("Wrong counts: expected %v, got %v", v.counts, counts)
+			}
+		})
+	}
+}
+
+func Test_Inventory_OldestPackages(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{2,2,2}: []LockerID{"1", "2", "3", "4"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.Clock = ticking_clock()
+	for _, id := range []PackageID{"c", "a", "d"} {
+		if _, err := inv.DepositPackage(&Package{Id: id, Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	if err := inv.StackPackage(inv.Lockers[inv.LockersByPackageId["a"]].Id, &Package{Id: "b", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	type X struct {
+		k int
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"none":     X{0, nil},
+		"oldest":   X{1, []PackageID{"c"}},
+		"stacked":  X{3, []PackageID{"c", "a", "b"}},
+		"too many": X{10, []PackageID{"c", "a", "b", "d"}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if ids := inv.OldestPackages(v.k); !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_Inventory_NextTighterSize(t *testing.T) {
+	type X struct {
+		current LockerSize
+		pkg SizeSpec
+		size_id LockerSize
+		ok bool
+	}
+
+	tests := map[string]X{
+		"one step":         X{400, SizeSpec{1,1,1}, 300, true},
+		"skips too small":  X{400, SizeSpec{2,2,1}, 300, true},
+		"long package":     X{400, SizeSpec{5,1,1}, 200, true},
+		"already tightest": X{200, SizeSpec{5,1,1}, 0, false},
+		"smallest size":    X{100, SizeSpec{1,1,1}, 0, false},
+		"nothing fits":     X{400, SizeSpec{4,4,4}, 0, false},
+		"unknown":          X{500, SizeSpec{1,1,1}, 0, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			size_id, ok := inv.NextTighterSize(v.current, v.pkg)
+			if size_id != v.size_id || ok != v.ok {
+				t.Errorf("Expected %d %t, got %d %t", v.size_id, v.ok, size_id, ok)
+			}
+		})
+	}
+}
+
+func Test_Inventory_OverprovisionedPackages(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{
+		SizeSpec{1,1,1}: 1,
+		SizeSpec{2,2,2}: 3,
+	})
+	// with direct capacity, the plentiful big size is preferred for small packages
+	inv.SetTransitiveCapacity(false)
+
+	type X struct {
+		id PackageID
+		size SizeSpec
+		expected []PackageID
+	}
+
+	steps := []X{
+		X{"a", SizeSpec{1,1,1}, []PackageID{"a"}},
+		X{"b", SizeSpec{2,2,2}, []PackageID{"a"}},
+		X{"c", SizeSpec{1,1,1}, []PackageID{"a"}},
+		// the small size is full, so the big one was the tightest on offer
+		X{"d", SizeSpec{1,1,1}, []PackageID{"a"}},
+	}
+
+	for i, step := range steps {
+		if _, err := inv.DepositPackage(&Package{Id: step.id, Size: step.size}); err != nil {
+			t.Fatalf("Step %d: unexpected error: %s", i, err.Error())
+		}
+		if ids := inv.OverprovisionedPackages(); !reflect.DeepEqual(ids, step.expected) {
+			t.Errorf("Step %d: expected %v, got %v", i, step.expected, ids)
+		}
+	}
+
+	inv.RetrievePackageById("a")
+	if ids := inv.OverprovisionedPackages(); len(ids) != 0 {
+		t.Errorf("Expected none after retrieval, got %v", ids)
+	}
+}
+
+func Test_Inventory_AllocationOrder(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+
+	type X struct {
+		size_id LockerSize
+		expected []LockerID
+	}
+
+	tests := map[string]X{
+		"full order":  X{100, []LockerID{"2", "1"}},
+		"after taken": X{200, []LockerID{"4", "3"}},
+		"unknown":     X{500, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			order := inv.AllocationOrder(v.size_id)
+			if !reflect.DeepEqual(order, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, order)
+			}
+		})
+	}
+
+	// the order is the one AllocateLocker actually follows
+	for _, id := range inv.AllocationOrder(300) {
+		index, err := inv.AllocateLocker(300)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if got := inv.Lockers[index].Id; got != id {
+			t.Errorf("Expected locker %s to be allocated, got %s", id, got)
+		}
+	}
+}
+
+func Test_Inventory_CapacityBreakdown(t *testing.T) {
+	type X struct {
+		size_id LockerSize
+		transitive bool
+		expected map[LockerSize]int
+	}
+
+	tests := map[string]X{
+		"smallest": X{100, true, map[LockerSize]int{100: 2, 200: 2, 300: 2, 400: 1}},
+		"long":     X{200, true, map[LockerSize]int{200: 2, 400: 1}},
+		"largest":  X{400, true, map[LockerSize]int{400: 1}},
+		"direct":   X{100, false, map[LockerSize]int{100: 2}},
+		"unknown":  X{500, true, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, _ := cplx_pkg(t)
+			if !v.transitive {
+				inv.SetTransitiveCapacity(false)
+			}
+
+			breakdown := inv.CapacityBreakdown(v.size_id)
+			if !reflect.DeepEqual(breakdown, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, breakdown)
+			}
+
+			total := 0
+			for _, count := range breakdown {
+				total += count
+			}
+			if breakdown != nil && total != inv.Control[v.size_id].VirtualCapacity {
+				t.Errorf("Breakdown adds up to %d, but capacity is %d", total, inv.Control[v.size_id].VirtualCapacity)
+			}
+		})
+	}
+}
+
+func Test_Inventory_TopWaste(t *testing.T) {
+	type X struct {
+		n int
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"none":     X{0, nil},
+		"worst":    X{1, []PackageID{"c"}},
+		"ties":     X{3, []PackageID{"c", "a", "d"}},
+		"too many": X{10, []PackageID{"c", "a", "d", "b"}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"1"},
+				SizeSpec{2,2,2}: []LockerID{"2", "3", "4", "5"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+			store_in(t, inv, "2", &Package{Id: "d", Size: SizeSpec{2,1,1}})
+			store_in(t, inv, "3", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+			store_in(t, inv, "4", &Package{Id: "a", Size: SizeSpec{1,2,1}})
+			store_in(t, inv, "5", &Package{Id: "s", Size: SizeSpec{1,1,1}})
+			inv.Lockers[inv.LockersById["5"]].Stacked = []*Package{&Package{Id: "t", Size: SizeSpec{1,1,1}}}
+
+			if ids := inv.TopWaste(v.n); !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_Inventory_VerifyPlacements(t *testing.T) {
+	type X struct {
+		size SizeSpec
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"fits":         X{SizeSpec{1,1,1}, nil},
+		"fits exactly": X{SizeSpec{1,1,5}, nil},
+		"too wide":     X{SizeSpec{2,2,1}, []PackageID{"abc"}},
+		"too long":     X{SizeSpec{6,1,1}, []PackageID{"abc"}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			pkg.Size = v.size
+			if ids := inv.VerifyPlacements(); !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_Inventory_OrphanedPackageIds(t *testing.T) {
+	type X struct {
+		edit func(inv *Inventory, pkg *Package)
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"consistent":     X{func(inv *Inventory, pkg *Package) {}, nil},
+		"emptied":        X{func(inv *Inventory, pkg *Package) { pkg.StoredIn.Contents = nil }, []PackageID{"abc"}},
+		"replaced":       X{func(inv *Inventory, pkg *Package) { pkg.StoredIn.Contents = &Package{Id: "other"} }, []PackageID{"abc"}},
+		"stacked":        X{func(inv *Inventory, pkg *Package) {
+			pkg.StoredIn.Stacked = append(pkg.StoredIn.Stacked, &Package{Id: "top"})
+			inv.LockersByPackageId["top"] = inv.LockersByPackageId["abc"]
+		}, nil},
+		"wrong locker":   X{func(inv *Inventory, pkg *Package) { inv.LockersByPackageId["abc"] = 0 }, []PackageID{"abc"}},
+		"out of range":   X{func(inv *Inventory, pkg *Package) { inv.LockersByPackageId["abc"] = len(inv.Lockers) }, []PackageID{"abc"}},
+		"several":        X{func(inv *Inventory, pkg *Package) {
+			inv.LockersByPackageId["b"] = -1
+			inv.LockersByPackageId["a"] = 0
+		}, []PackageID{"a", "b"}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, pkg := cplx_pkg(t)
+			v.edit(inv, pkg)
+			if ids := inv.OrphanedPackageIds(); !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_Inventory_PackagesPage(t *testing.T) {
+	type X struct {
+		offset, limit int
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"first page":  X{0, 2, []PackageID{"a", "b"}},
+		"middle page": X{1, 2, []PackageID{"b", "c"}},
+		"last page":   X{2, 5, []PackageID{"c", "d"}},
+		"everything":  X{0, 10, []PackageID{"a", "b", "c", "d"}},
+		"at end":      X{4, 2, nil},
+		"beyond end":  X{9, 2, nil},
+		"no limit":    X{0, 0, nil},
+		"last item":   X{3, 1, []PackageID{"d"}},
+		"negative":    X{-1, 2, []PackageID{"a", "b"}},
+		"more negative": X{-2, 3, []PackageID{"a", "b", "c"}},
+		"negative limit": X{0, -1, nil},
+		"all negative": X{-3, -1, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{2,2,2}: []LockerID{"1", "2", "3"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			store_in(t, inv, "1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+			store_in(t, inv, "3", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+			for _, x := range []struct{ id LockerID; pkg PackageID }{{"1", "b"}, {"3", "d"}} {
+				if err := inv.StackPackage(x.id, &Package{Id: x.pkg, Size: SizeSpec{1,1,1}}); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+
+			ids, total := inv.PackagesPage(v.offset, v.limit)
+			if !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+			if total != 4 {
+				t.Errorf("Unexpected total: %d", total)
+			}
+		})
+	}
+}
+
+func Test_Inventory_SmallestFreeSize(t *testing.T) {
+	type X struct {
+		full []LockerSize
+		size_id LockerSize
+		ok bool
+	}
+
+	tests := map[string]X{
+		"smallest free":  X{nil, 100, true},
+		"smallest full":  X{[]LockerSize{100}, 200, true},
+		"skips to large": X{[]LockerSize{100, 200, 300}, 400, true},
+		"all full":       X{[]LockerSize{100, 200, 300, 400}, 0, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			for _, size_id := range v.full {
+				inv.Control[size_id].Lockers = nil
+			}
+			size_id, ok := inv.SmallestFreeSize()
+			if size_id != v.size_id || ok != v.ok {
+				t.Errorf("Expected %d %t, got %d %t", v.size_id, v.ok, size_id, ok)
+			}
+		})
+	}
+
+	// same volume, so the lower id wins
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{4,1,1}: 1, SizeSpec{2,2,1}: 1})
+	size_id, _ := inv.SmallestFreeSize()
+	for other := range inv.Control {
+		if other < size_id {
+			t.Errorf("Expected the lowest size id, got %d", size_id)
+		}
+	}
+
+	// the larger volume is too big for an int64, but is still not the smallest
+	inv = NewInventory(map[SizeSpec]int{SizeSpec{2097151,2097151,2097151}: 1, SizeSpec{2097152,2097152,2097152}: 1})
+	size_id, _ = inv.SmallestFreeSize()
+	if inv.Control[size_id].Size != (SizeSpec{2097151,2097151,2097151}) {
+		t.Errorf("Expected the smaller huge size, got %v", inv.Control[size_id].Size)
+	}
+}
+
+func Test_Inventory_CapacitySnapshot(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+	snap := inv.CapacitySnapshot()
+
+	expected := CapacitySnapshot{
+		Total: 9,
+		Free: 7,
+		FreeBySize: map[LockerSize]int{100: 2, 200: 2, 300: 2, 400: 1},
+		VirtualCapacityBySize: map[LockerSize]int{100: 7, 200: 3, 300: 3, 400: 1},
+	}
+	if !reflect.DeepEqual(snap, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, snap)
+	}
+
+	// later changes do not show up in the snapshot
+	inv.AllocateLocker(100)
+	if !reflect.DeepEqual(snap, expected) {
+		t.Errorf("Snapshot changed along with the inventory: %+v", snap)
+	}
+
+	// an inactive size's lockers are not available, as for HasFreeExact
+	if err := inv.DeactivateSize(SizeSpec{3,3,1}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	snap = inv.CapacitySnapshot()
+	if snap.Free != 4 || snap.FreeBySize[300] != 0 || snap.Total != 9 {
+		t.Errorf("Inactive size counted as free: %+v", snap)
+	}
+	if inv.HasFreeExact(SizeSpec{3,3,1}) {
+		t.Errorf("Expected no free locker of an inactive size")
+	}
+}
+
+func Test_Inventory_ConfigFingerprint(t *testing.T) {
+	config := map[SizeSpec]int{SizeSpec{1,1,1}: 3, SizeSpec{2,2,2}: 2}
+	a := NewInventory(config)
+	fingerprint := a.ConfigFingerprint()
+	if len(fingerprint) != 16 {
+		t.Errorf("Unexpected fingerprint %q", fingerprint)
+	}
+
+	type X struct {
+		inv *Inventory
+		same bool
+	}
+
+	occupied := NewInventory(config)
+	occupied.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+
+	tests := map[string]X{
+		"same config":   X{NewInventory(config), true},
+		"occupied":      X{occupied, true},
+		"other ids":     X{NewInventoryWithIDGen(config, SequentialIDGen("L")), true},
+		"other count":   X{NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 3}), false},
+		"other size":    X{NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 3, SizeSpec{2,2,3}: 2}), false},
+		"extra size":    X{NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 3, SizeSpec{2,2,2}: 2, SizeSpec{3,3,3}: 0}), false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if same := v.inv.ConfigFingerprint() == fingerprint; same != v.same {
+				t.Errorf("Expected same fingerprint: %t, got %s and %s", v.same, v.inv.ConfigFingerprint(), fingerprint)
+			}
+		})
+	}
+}
+
+func Test_Inventory_Report(t *testing.T) {
+	inv, _ := cplx_pkg(t)
+	before := inv.DeepCopy()
+
+	expected := map[LockerSize]LockerStats{
+		100: LockerStats{Size: SizeSpec{1,1,1}, Total: 2, Occupied: 0, Available: 2, Utilization: 0},
+		200: LockerStats{Size: SizeSpec{5,1,1}, Total: 3, Occupied: 1, Available: 2, Utilization: 1.0 / 3},
+		300: LockerStats{Size: SizeSpec{3,3,1}, Total: 2, Occupied: 0, Available: 2, Utilization: 0},
+		400: LockerStats{Size: SizeSpec{5,5,5}, Total: 2, Occupied: 1, Available: 1, Utilization: 0.5},
+	}
+	if report := inv.Report(); !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, report)
+	}
+	if u := inv.OverallUtilization(); u != 2.0 / 9 {
+		t.Errorf("Expected overall utilization %v, got %v", 2.0 / 9, u)
+	}
+	if eq, explanation := CompareInventories(t, inv, before); !eq {
+		t.Errorf("Report changed the inventory: %s", explanation)
+	}
+
+	empty := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 0})
+	if stats := empty.Report()[empty.Sizes[SizeSpec{1,1,1}]]; stats.Total != 0 || stats.Utilization != 0 {
+		t.Errorf("Unexpected stats for a size with no lockers: %+v", stats)
+	}
+	if u := empty.OverallUtilization(); u != 0 {
+		t.Errorf("Expected 0 utilization with no lockers, got %v", u)
+	}
+}
+
+func Test_Inventory_WastedVolumeFor(t *testing.T) {
+	type X struct {
+		id PackageID
+		expected int64
+		err error
+	}
+
+	tests := map[string]X{
+		"exact fit": X{"b", 0, nil},
+		"oversized": X{"c", 7, nil},
+		"partial":   X{"d", 6, nil},
+		"rotated":   X{"e", 0, nil},
+		"stacked":   X{"f", 6, nil},
+		"on top":    X{"g", 6, nil},
+		"unknown":   X{"z", 0, ErrUnknownPackageID},
+	}
+
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"1"},
+		SizeSpec{2,2,2}: []LockerID{"2", "3", "5"},
+		SizeSpec{1,2,3}: []LockerID{"4"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "1", &Package{Id: "b", Size: SizeSpec{1,1,1}})
+	store_in(t, inv, "2", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+	store_in(t, inv, "3", &Package{Id: "d", Size: SizeSpec{2,1,1}})
+	store_in(t, inv, "4", &Package{Id: "e", Size: SizeSpec{3,2,1}})
+	for _, id := range []PackageID{"f", "g"} {
+		if err := inv.StackPackage("5", &Package{Id: id, Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			waste, err := inv.WastedVolumeFor(v.id)
+			if err != v.err {
+				t.Fatalf("Expected error %v, got %v", v.err, err)
+			}
+			if waste != v.expected {
+				t.Errorf("Expected %d, got %d", v.expected, waste)
+			}
+		})
+	}
+
+	if total := inv.WastedVolume(); total != 19 {
+		t.Errorf("Expected total waste of 19, got %d", total)
+	}
+}
+
+func Test_Inventory_EachPackage(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{2,2,2}: []LockerID{"1", "2", "3", "4"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+	store_in(t, inv, "3", &Package{Id: "c", Size: SizeSpec{1,1,1}})
+	if err := inv.StackPackage("1", &Package{Id: "b", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.Lockers[inv.LockersById["4"]].Contents = &Package{Id: "orphan", Size: SizeSpec{1,1,1}}
+	before := inv.DeepCopy()
+
+	type visit struct {
+		pkg PackageID
+		locker LockerID
+	}
+
+	type X struct {
+		stop_after int
+		expected []visit
+	}
+
+	tests := map[string]X{
+		"all":        X{0, []visit{visit{"a", "1"}, visit{"b", "1"}, visit{"c", "3"}}},
+		"stop early": X{2, []visit{visit{"a", "1"}, visit{"b", "1"}}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			var visits []visit
+			inv.EachPackage(func(pkg *Package, locker LockerID) bool {
+				visits = append(visits, visit{pkg.Id, locker})
+				return len(visits) != v.stop_after
+			})
+			if !reflect.DeepEqual(visits, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, visits)
+			}
+		})
+	}
+
+	if eq, explanation := CompareInventories(t, inv, before); !eq {
+		t.Errorf("EachPackage changed the inventory: %s", explanation)
+	}
+
+	// packages retrieved during the walk are still visited
+	var visited []PackageID
+	inv.EachPackage(func(pkg *Package, locker LockerID) bool {
+		visited = append(visited, pkg.Id)
+		inv.RetrievePackageById("c")
+		return true
+	})
+	if !reflect.DeepEqual(visited, []PackageID{"a", "b", "c"}) {
+		t.Errorf("Expected every package present at the start, got %v", visited)
+	}
+}
+
+func Test_Inventory_PackagesInSize(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{1,1,1}: []LockerID{"s1"},
+		SizeSpec{2,2,2}: []LockerID{"m1", "m2", "m3"},
+		SizeSpec{3,3,3}: []LockerID{"l1"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	store_in(t, inv, "s1", &Package{Id: "small", Size: SizeSpec{1,1,1}})
+	store_in(t, inv, "m1", &Package{Id: "a", Size: SizeSpec{1,1,1}})
+	store_in(t, inv, "m3", &Package{Id: "c", Size: SizeSpec{2,2,2}})
+	if err := inv.StackPackage("m1", &Package{Id: "b", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	type X struct {
+		size LockerSize
+		expected []PackageID
+	}
+
+	tests := map[string]X{
+		"small":   X{inv.Sizes[SizeSpec{1,1,1}], []PackageID{"small"}},
+		"medium":  X{inv.Sizes[SizeSpec{2,2,2}], []PackageID{"a", "b", "c"}},
+		"empty":   X{inv.Sizes[SizeSpec{3,3,3}], nil},
+		"unknown": X{LockerSize(12345), nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			var ids []PackageID
+			for _, p := range inv.PackagesInSize(v.size) {
+				ids = append(ids, p.Id)
+			}
+			if !reflect.DeepEqual(ids, v.expected) {
+				t.Errorf("Expected %v, got %v", v.expected, ids)
+			}
+		})
+	}
+}
+
+func Test_Inventory_PackageSizeHistogram(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{2,2,2}: 4, SizeSpec{4,4,4}: 2})
+	inv.PackingFactor = 2
+	for _, pkg := range []*Package{
+		&Package{Id: "a", Size: SizeSpec{1,1,1}},
+		&Package{Id: "b", Size: SizeSpec{1,2,1}},
+		&Package{Id: "c", Size: SizeSpec{2,1,1}},
+		&Package{Id: "d", Size: SizeSpec{3,3,3}},
+		&Package{Id: "e", Size: SizeSpec{1,1,1}},
+	} {
+		if _, err := inv.DepositPackage(pkg); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	if err := inv.StackPackage(inv.Lockers[inv.LockersByPackageId["a"]].Id, &Package{Id: "stacked", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := map[SizeSpec]int{SizeSpec{1,1,1}: 3, SizeSpec{2,1,1}: 2, SizeSpec{3,3,3}: 1}
+	if histogram := inv.PackageSizeHistogram(); !reflect.DeepEqual(histogram, expected) {
+		t.Errorf("Expected %v, got %v", expected, histogram)
+	}
+
+	empty := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1})
+	if histogram := empty.PackageSizeHistogram(); len(histogram) != 0 {
+		t.Errorf("Expected an empty histogram, got %v", histogram)
+	}
+}
@@ -0,0 +1,113 @@
+package lockers
+
+// A description of the locker a package needs, for SelectLocker. The zero value of
+// each field places no constraint, so a request only needs to set what matters to it.
+type LockerRequest struct {
+	// the size of the package, in any orientation unless NoRotate is set.
+	Size SizeSpec
+
+	// if true, the package may not be turned to fit, so Size is taken as given. See
+	// Package.NoRotate.
+	NoRotate bool
+
+	// padding to add to each of the package's dimensions. See Package.Margin.
+	Margin int
+
+	// the weight of the package. See Package.Weight.
+	Weight int
+
+	// the direction the package is travelling, which the locker's purpose must accept.
+	// PurposeBoth, the zero value, accepts every locker.
+	Direction LockerPurpose
+
+	// if not empty, only lockers in this zone are considered. See Locker.Zone.
+	Zone string
+
+	// if true, lockers which have not been cleaned since they were last emptied may
+	// be chosen. See Locker.Dirty.
+	AllowDirty bool
+
+	// lockers which may not be chosen. See Inventory.DepositPackageExcluding.
+	Exclude map[LockerID]bool
+
+	// chooses between the sizes which satisfy every other constraint. If nil, the
+	// inventory's Strategy is used.
+	Strategy SelectionStrategy
+}
+
+// an ErrNoLockerFits with an explanation of which constraint could not be met.
+type noLockerError struct {
+	reason string
+}
+
+func (e *noLockerError) Error() string {
+	return ErrNoLockerFits.Error() + ": " + e.reason
+}
+
+// makes errors.Is(err, ErrNoLockerFits) true.
+func (e *noLockerError) Is(target error) bool {
+	return target == ErrNoLockerFits
+}
+
+// Chooses the locker a package described by a request would be deposited into,
+// without depositing it, so that any combination of constraints can be asked for
+// with one call. Every constraint in the request is a hard requirement: a locker is
+// only considered if it is big enough for the package and its margin (without turning
+// it, if NoRotate is set), accepts its direction, is in the requested zone, is not
+// excluded and is clean (unless dirty lockers are allowed). No constraint takes
+// precedence over another, so when they conflict nothing matches. The strategy only
+// chooses between the sizes which remain, and cannot override any of them. When
+// nothing matches, the error satisfies errors.Is(err, ErrNoLockerFits) and says which
+// constraint ruled out the last lockers.
+func (inv *Inventory) SelectLocker(req LockerRequest) (LockerID, error) {
+	pkg := Package{Size: req.Size, Margin: req.Margin, Weight: req.Weight, NoRotate: req.NoRotate}
+	p := inv.packagePlacement(&pkg, req.Direction)
+	p.allow_dirty = req.AllowDirty
+	p.strategy = req.Strategy
+	p.zone = req.Zone
+	p.exclude = req.Exclude
+
+	size_id, err := inv.selectSize(p)
+	if err == ErrNoLockerFits {
+		return "", inv.whyNoLocker(p)
+	} else if err != nil {
+		return "", err
+	}
+
+	ctrl := inv.Control[size_id]
+	return inv.Lockers[ctrl.Lockers[inv.next(ctrl, p)]].Id, nil
+}
+
+// explains why no locker is usable for a placement, by relaxing its constraints one
+// at a time, from the most specific to the most fundamental.
+func (inv *Inventory) whyNoLocker(p placement) error {
+	relaxed := p
+	relaxed.exclude = nil
+	if _, err := inv.selectSize(relaxed); err == nil {
+		return &noLockerError{"every suitable locker is excluded"}
+	}
+	relaxed.allow_dirty = true
+	if _, err := inv.selectSize(relaxed); err == nil {
+		return &noLockerError{"every suitable locker is dirty"}
+	}
+	relaxed.zone = ""
+	if _, err := inv.selectSize(relaxed); err == nil {
+		return &noLockerError{"no suitable locker is available in zone " + p.zone}
+	}
+	relaxed.direction = PurposeBoth
+	if _, err := inv.selectSize(relaxed); err == nil {
+		return &noLockerError{"no suitable locker accepts the package's direction"}
+	}
+	too_heavy := false
+	for _, size_id := range inv.Sizes {
+		if !p.fits(inv.Control[size_id]) { continue }
+		if p.accepts(inv.Control[size_id]) {
+			return &noLockerError{"every locker big enough is in use"}
+		}
+		too_heavy = true
+	}
+	if too_heavy {
+		return &noLockerError{"the package is too heavy for every size of locker big enough"}
+	}
+	return &noLockerError{"the package is too big for every size of locker"}
+}
@@ -0,0 +1,105 @@
+package lockers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Inventory_SelectLocker(t *testing.T) {
+	type X struct {
+		req LockerRequest
+		dirty, occupied []LockerID
+		expected LockerID
+		reason string
+	}
+
+	tests := map[string]X{
+		"any":            X{LockerRequest{Size: SizeSpec{1,1,1}}, nil, nil, "s2", ""},
+		"direction":      X{LockerRequest{Size: SizeSpec{1,1,1}, Direction: PurposeDeposit}, nil, nil, "s1", ""},
+		"zone":           X{LockerRequest{Size: SizeSpec{1,1,1}, Zone: "north"}, nil, nil, "s1", ""},
+		"margin":         X{LockerRequest{Size: SizeSpec{1,1,1}, Margin: 1}, nil, nil, "b1", ""},
+		"strategy":       X{LockerRequest{Size: SizeSpec{1,1,1}, Zone: "north", Strategy: func(c []LockerSize, inv IControlSpec) LockerSize { return c[0] }}, nil, nil, "b1", ""},
+		"dirty":          X{LockerRequest{Size: SizeSpec{1,1,1}, Zone: "south"}, []LockerID{"s2"}, nil, "", "dirty"},
+		"allow dirty":    X{LockerRequest{Size: SizeSpec{1,1,1}, Zone: "south", AllowDirty: true}, []LockerID{"s2"}, nil, "s2", ""},
+		"conflict":       X{LockerRequest{Size: SizeSpec{1,1,1}, Zone: "south", Direction: PurposeDeposit}, nil, nil, "", "zone south"},
+		"wrong purpose":  X{LockerRequest{Size: SizeSpec{1,1,1}, Direction: PurposeReturn}, nil, []LockerID{"s2", "b1"}, "", "direction"},
+		"in use":         X{LockerRequest{Size: SizeSpec{2,2,2}}, nil, []LockerID{"b1"}, "", "in use"},
+		"excluded":       X{LockerRequest{Size: SizeSpec{2,2,2}, Exclude: map[LockerID]bool{"b1": true}}, nil, nil, "", "excluded"},
+		"too big":        X{LockerRequest{Size: SizeSpec{3,3,3}}, nil, nil, "", "too big"},
+		"heavy":          X{LockerRequest{Size: SizeSpec{1,1,1}, Weight: 5}, nil, nil, "s2", ""},
+		"too heavy":      X{LockerRequest{Size: SizeSpec{1,1,1}, Weight: 6}, nil, nil, "", "too heavy"},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"s1", "s2"},
+				SizeSpec{2,2,2}: []LockerID{"b1"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			for id, zone := range map[LockerID]string{"s1": "north", "s2": "south", "b1": "north"} {
+				inv.Lockers[inv.LockersById[id]].Zone = zone
+			}
+			for _, ctrl := range inv.Control {
+				ctrl.MaxWeight = 5
+			}
+			inv.Lockers[inv.LockersById["s1"]].Purpose = PurposeDeposit
+			inv.Lockers[inv.LockersById["s2"]].Purpose = PurposeReturn
+			for _, id := range v.dirty {
+				inv.Lockers[inv.LockersById[id]].LastEmptied = time.Now()
+			}
+			for _, id := range v.occupied {
+				store_in(t, inv, id, &Package{Id: PackageID(id), Size: SizeSpec{1,1,1}})
+			}
+
+			id, err := inv.SelectLocker(v.req)
+			if v.reason == "" {
+				if err != nil || id != v.expected {
+					t.Errorf("Unexpected result: got %q (%v), expected %q", id, err, v.expected)
+				}
+				return
+			}
+			if !errors.Is(err, ErrNoSuitableLocker) || !strings.Contains(err.Error(), v.reason) {
+				t.Errorf("Unexpected error: got %v, expected a reason mentioning %q", err, v.reason)
+			}
+			if len(inv.LockersByPackageId) != len(v.occupied) {
+				t.Errorf("Selection changed the inventory")
+			}
+		})
+	}
+}
+
+func Test_Inventory_SelectLocker_NoRotate(t *testing.T) {
+	type X struct {
+		req LockerRequest
+		expected LockerID
+	}
+
+	tests := map[string]X{
+		"rotated":     X{LockerRequest{Size: SizeSpec{1,2,1}}, "l1"},
+		"no rotate":   X{LockerRequest{Size: SizeSpec{1,2,1}, NoRotate: true}, ""},
+		"as given":    X{LockerRequest{Size: SizeSpec{2,1,1}, NoRotate: true}, "l1"},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{2,1,1}: []LockerID{"l1"}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			id, err := inv.SelectLocker(v.req)
+			if v.expected == "" {
+				if !errors.Is(err, ErrNoLockerFits) {
+					t.Errorf("Unexpected result: got %q (%v), expected ErrNoLockerFits", id, err)
+				}
+			} else if err != nil || id != v.expected {
+				t.Errorf("Unexpected result: got %q (%v), expected %q", id, err, v.expected)
+			}
+		})
+	}
+}
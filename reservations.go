@@ -0,0 +1,144 @@
+package lockers
+
+import (
+	"errors"
+	"time"
+)
+
+// identifies a reservation made with Inventory.ReserveWindow. The zero token is never
+// returned for a reservation.
+type ReservationToken uint64
+
+// a number of lockers of one size held back during a window of time.
+type reservation struct {
+	size SizeSpec
+	count int
+	from, to time.Time
+}
+
+// returns true if the reservation applies at the given time. Windows include their
+// start but not their end, so back to back windows never overlap.
+func (r *reservation) activeAt(now time.Time) bool {
+	return !now.Before(r.from) && now.Before(r.to)
+}
+
+// Reserves count lockers of exactly the given size for the window of time from, up to
+// but not including to, such as for a regular delivery expected at that time. For a
+// recurring delivery, reserve each occurrence separately. While the reservation is
+// active, that many of the size's available lockers are held back: DepositPackage,
+// CanFit and every other way of placing a package pass them over, except DepositReserved
+// with this token, and they are subtracted from the size's AvailableAt. VirtualCapacity
+// does not change with time, so it still counts them. Returns a token with which the
+// reservation can be used or cancelled.
+func (inv *Inventory) ReserveWindow(size SizeSpec, count int, from, to time.Time) (ReservationToken, error) {
+	size = size.Normalize()
+	if _, ok := inv.Sizes[size]; !ok {
+		return 0, ErrUnknownLockerSize
+	}
+	if count <= 0 {
+		return 0, errors.New("Reservation count must be positive")
+	}
+	if !to.After(from) {
+		return 0, errors.New("Reservation window is empty")
+	}
+
+	if inv.reservations == nil {
+		inv.reservations = make(map[ReservationToken]*reservation)
+	}
+	inv.lastReservation += 1
+	token := inv.lastReservation
+	inv.reservations[token] = &reservation{size: size, count: count, from: from, to: to}
+	return token, nil
+}
+
+// places a package as DepositPackage, but into one of the lockers held by the given
+// reservation, which must be active. Each deposit uses up one of the lockers the
+// reservation holds, and the reservation ends when none are left. Returns
+// ErrNoLockerFits if no locker of the reserved size can take the package.
+func (inv *Inventory) DepositReserved(token ReservationToken, pkg *Package) (LockerID, error) {
+	r, ok := inv.reservations[token]
+	if !ok {
+		return "", errors.New("Reservation not known")
+	}
+	if !r.activeAt(inv.now()) {
+		return "", errors.New("Reservation is not active")
+	}
+
+	size_id := inv.Sizes[r.size]
+	p := inv.packagePlacement(pkg, PurposeDeposit)
+	p.reservation = token
+	if !p.accepts(inv.Control[size_id]) || inv.next(inv.Control[size_id], p) < 0 {
+		return "", ErrNoLockerFits
+	}
+	p.strategy = func(candidates []LockerSize, ctrl IControlSpec) LockerSize {
+		return size_id
+	}
+
+	locker_id, err := inv.deposit(pkg, p)
+	if err != nil {
+		return "", err
+	}
+	r.count -= 1
+	if r.count == 0 {
+		delete(inv.reservations, token)
+	}
+	return locker_id, nil
+}
+
+// returns the number of a size's available lockers which a placement must pass over
+// because active reservations other than its own hold them.
+func (inv *Inventory) heldBack(ctrl *LockerControlSpec, p placement) int {
+	if len(inv.reservations) == 0 {
+		return 0
+	}
+	now := inv.now()
+	held := 0
+	for token, r := range inv.reservations {
+		if token != p.reservation && r.size == ctrl.Size && r.activeAt(now) {
+			held += r.count
+		}
+	}
+	return held
+}
+
+// Cancels a reservation made with ReserveWindow.
+func (inv *Inventory) CancelReservation(token ReservationToken) error {
+	if _, ok := inv.reservations[token]; !ok {
+		return errors.New("Reservation not known")
+	}
+	delete(inv.reservations, token)
+	return nil
+}
+
+// Returns the number of lockers of exactly the given size held by reservations which
+// are active at the given time.
+func (inv *Inventory) ReservedAt(size SizeSpec, now time.Time) int {
+	size = size.Normalize()
+	reserved := 0
+	for _, r := range inv.reservations {
+		if r.size == size && r.activeAt(now) {
+			reserved += r.count
+		}
+	}
+	return reserved
+}
+
+// Returns the number of available lockers of exactly the given size at the given time,
+// once the lockers held by active reservations (see ReserveWindow) are set aside, or 0
+// if reservations hold more than are available. Returns 0 for inactive or unknown sizes.
+func (inv *Inventory) AvailableAt(size SizeSpec, now time.Time) int {
+	size_id, ok := inv.Sizes[size.Normalize()]
+	if !ok {
+		return 0
+	}
+	ctrl := inv.Control[size_id]
+	if ctrl.Inactive {
+		return 0
+	}
+
+	available := len(ctrl.Lockers) - inv.ReservedAt(size, now)
+	if available < 0 {
+		return 0
+	}
+	return available
+}
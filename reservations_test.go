@@ -0,0 +1,145 @@
+package lockers
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Inventory_ReserveWindow(t *testing.T) {
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	type X struct {
+		size SizeSpec
+		count int
+		from, to time.Time
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"ok":           X{SizeSpec{2,2,1}, 2, start, end, false},
+		"denormalized": X{SizeSpec{1,2,2}, 1, start, end, false},
+		"unknown size": X{SizeSpec{9,9,9}, 1, start, end, true},
+		"no lockers":   X{SizeSpec{2,2,1}, 0, start, end, true},
+		"negative":     X{SizeSpec{2,2,1}, -1, start, end, true},
+		"empty window": X{SizeSpec{2,2,1}, 1, start, start, true},
+		"backwards":    X{SizeSpec{2,2,1}, 1, end, start, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,1}: 3})
+			token, err := inv.ReserveWindow(v.size, v.count, v.from, v.to)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if !v.is_error && token == 0 {
+				t.Errorf("Got the zero token")
+			}
+			if v.is_error && len(inv.reservations) != 0 {
+				t.Errorf("Failed call made a reservation")
+			}
+		})
+	}
+}
+
+func Test_Inventory_AvailableAt(t *testing.T) {
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 3})
+	if _, err := inv.ReserveWindow(SizeSpec{2,2,2}, 2, start, end); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	big, err := inv.ReserveWindow(SizeSpec{2,2,2}, 5, end, end.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	type X struct {
+		size SizeSpec
+		now time.Time
+		expected int
+	}
+
+	tests := map[string]X{
+		"before window":  X{SizeSpec{2,2,2}, start.Add(-time.Minute), 3},
+		"window start":   X{SizeSpec{2,2,2}, start, 1},
+		"during window":  X{SizeSpec{2,2,2}, start.Add(time.Hour), 1},
+		"overbooked":     X{SizeSpec{2,2,2}, end, 0},
+		"after windows":  X{SizeSpec{2,2,2}, end.Add(time.Hour), 3},
+		"other size":     X{SizeSpec{1,1,1}, start, 2},
+		"unknown size":   X{SizeSpec{9,9,9}, start, 0},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if n := inv.AvailableAt(v.size, v.now); n != v.expected {
+				t.Errorf("Unexpected availability: got %d, expected %d", n, v.expected)
+			}
+		})
+	}
+
+	if err := inv.CancelReservation(big); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if n := inv.AvailableAt(SizeSpec{2,2,2}, end); n != 3 {
+		t.Errorf("Cancelled reservation still counted: got %d", n)
+	}
+	if err := inv.CancelReservation(big); err == nil {
+		t.Errorf("Expected error cancelling a cancelled reservation")
+	}
+	if inv.Control[inv.Sizes[SizeSpec{2,2,2}]].VirtualCapacity != 3 {
+		t.Errorf("Reservations changed real capacity")
+	}
+}
+
+func Test_Inventory_ReserveWindow_HoldsLockers(t *testing.T) {
+	start := time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	now := start
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,1}: 1})
+	inv.Clock = func() time.Time { return now }
+	token, err := inv.ReserveWindow(SizeSpec{1,1,1}, 2, start, end)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	small := inv.Sizes[SizeSpec{1,1,1}]
+
+	locker_id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if inv.Lockers[inv.LockersById[locker_id]].SizeId == small {
+		t.Errorf("Deposit used a reserved locker")
+	}
+	if inv.CanFit(SizeSpec{1,1,1}) {
+		t.Errorf("CanFit counted reserved lockers")
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{1,1,1}}); err != ErrNoLockerFits {
+		t.Errorf("Unexpected error: got %v, expected %v", err, ErrNoLockerFits)
+	}
+
+	locker_id, err = inv.DepositReserved(token, &Package{Id: "b", Size: SizeSpec{1,1,1}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if inv.Lockers[inv.LockersById[locker_id]].SizeId != small {
+		t.Errorf("Reserved deposit did not use a reserved locker")
+	}
+	if n := inv.ReservedAt(SizeSpec{1,1,1}, now); n != 1 {
+		t.Errorf("Unexpected reserved count: got %d, expected 1", n)
+	}
+	if _, err := inv.DepositReserved(token, &Package{Id: "c", Size: SizeSpec{2,2,1}}); err != ErrNoLockerFits {
+		t.Errorf("Unexpected error: got %v, expected %v", err, ErrNoLockerFits)
+	}
+
+	now = end
+	if _, err := inv.DepositReserved(token, &Package{Id: "c", Size: SizeSpec{1,1,1}}); err == nil {
+		t.Errorf("Expected error depositing into an inactive reservation")
+	}
+	if _, err := inv.DepositPackage(&Package{Id: "c", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Errorf("Unexpected error after the window: %s", err.Error())
+	}
+}
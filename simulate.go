@@ -0,0 +1,167 @@
+package lockers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// generates an ID for a hypothetical package.
+func newPackageId() PackageID {
+	return PackageID(uuid.NewString())
+}
+
+// Deposits generated packages until at least the given fraction of the inventory's
+// lockers are occupied, for benchmarking under load without hand-written fixtures.
+// Size classes with available lockers are visited round-robin in size id order, and
+// each visit deposits a new package with a generated ID and the size sizeOf returns
+// for that class; the package is placed wherever DepositPackage would put it. Stops
+// early if a whole round places nothing. Returns the number of packages placed.
+func (inv *Inventory) FillToOccupancy(fraction float64, sizeOf func(LockerSize) SizeSpec) int {
+	target := int(fraction * float64(len(inv.Lockers)))
+	if float64(target) < fraction * float64(len(inv.Lockers)) {
+		target += 1
+	}
+
+	occupied := 0
+	for i := range inv.Lockers {
+		if inv.Lockers[i].Contents != nil {
+			occupied += 1
+		}
+	}
+
+	placed := 0
+	for progress := true; progress && occupied < target; {
+		progress = false
+		for _, size_id := range inv.sizeIds() {
+			if occupied >= target { break }
+			if inv.Control[size_id].Full() { continue }
+
+			pkg := &Package{
+				Id: newPackageId(),
+				Size: sizeOf(size_id),
+			}
+			if _, err := inv.DepositPackage(pkg); err != nil { continue }
+
+			placed += 1
+			occupied += 1
+			progress = true
+		}
+	}
+
+	return placed
+}
+
+// Predicts which size of locker a package would go into if the hypothetical packages
+// were deposited first, in order, such as to show a customer the likely locker size
+// given the queue ahead of them. The hypothetical deposits are made against a copy, so
+// the inventory is not changed. Hypothetical packages which would be rejected are
+// skipped, as they would not take up room. Returns an error if the package itself
+// would not fit anywhere after the hypothetical deposits.
+func (inv *Inventory) BestSizeAfter(hypothetical []SizeSpec, pkg SizeSpec) (LockerSize, error) {
+	c := inv.clone()
+	for _, size := range hypothetical {
+		c.DepositPackage(&Package{
+			Id: newPackageId(),
+			Size: size,
+		})
+	}
+
+	return c.GetMostSuitableLockerSize(pkg.Normalize())
+}
+
+// A single planned move of a package from one locker to another.
+type Relocation struct {
+	Package PackageID
+	From, To LockerID
+}
+
+// Plans a sequence of moves which would defragment the inventory, by moving packages
+// out of lockers larger than they need and into smaller available ones. Nothing is
+// moved; the moves are worked out against a copy of the inventory, and must be applied
+// in order because later moves may use lockers freed by earlier ones.
+// The plan is greedy: packages wasting the most volume are considered first, and each
+// is moved at most once, into the tightest size class which has a usable locker and is
+// smaller than its current one. Emptied lockers need cleaning before they can be used
+// again, so they are not reused within a plan, and a single pass suffices. Packages in lockers set aside for
+// returns only move into lockers which accept returns, and other packages are treated
+// as outbound deposits.
+func (inv *Inventory) RelocationPlan() []Relocation {
+	return inv.clone().relocate()
+}
+
+// makes the moves described by RelocationPlan, and returns them.
+func (c *Inventory) relocate() []Relocation {
+	occupied := make([]int, 0, len(c.LockersByPackageId))
+	for i := range c.Lockers {
+		// bulk lockers are left alone, since moving one package out of a stack
+		// frees nothing.
+		if c.Lockers[i].Contents != nil && len(c.Lockers[i].Stacked) == 0 {
+			occupied = append(occupied, i)
+		}
+	}
+	sort.SliceStable(occupied, func(i, j int) bool { return c.wastedVolumeAt(occupied[i]) > c.wastedVolumeAt(occupied[j]) })
+
+	var plan []Relocation
+	for _, index := range occupied {
+		l := &c.Lockers[index]
+
+		direction := PurposeDeposit
+		if l.Purpose != PurposeBoth {
+			direction = l.Purpose
+		}
+		p := c.packagePlacement(l.Contents, direction)
+		size_id, ok := c.tightestFor(p)
+		if !ok || !c.Control[size_id].Size.tighterThan(c.Control[l.SizeId].Size) { continue }
+
+		pkg_id := l.Contents.Id
+		dst := c.moveTo(index, size_id, c.next(c.Control[size_id], p))
+		plan = append(plan, Relocation{
+			Package: pkg_id,
+			From: c.Lockers[index].Id,
+			To: c.Lockers[dst].Id,
+		})
+	}
+
+	return plan
+}
+
+// Estimates how long it will be until a locker which can hold a package of the given
+// size becomes available, for telling customers when to come back. Returns 0 if one is
+// available already, and a negative duration if no estimate can be made, because no
+// occupied locker could hold the package or retrievalsPerHour is not positive.
+//
+// The model is deliberately simple: packages are retrieved from the whole inventory at
+// the given rate, each retrieval is equally likely to empty any occupied locker, and
+// nothing else is deposited meanwhile. If a fraction f of the occupied lockers could
+// hold the package, about 1/f retrievals are needed, taking 1/(f * retrievalsPerHour)
+// hours. How long packages have been stored is not taken into account, so the estimate
+// is the same at any time now; now is accepted so that a model which does can be
+// substituted without changing callers.
+func (inv *Inventory) EstimateAvailability(size SizeSpec, retrievalsPerHour float64, now time.Time) time.Duration {
+	p := inv.placementFor(size.Normalize(), PurposeDeposit)
+	for _, ctrl := range inv.Control {
+		if ctrl.Size.Contains(p.size) && inv.next(ctrl, p) >= 0 {
+			return 0
+		}
+	}
+
+	suitable, occupied := 0, 0
+	for i := range inv.Lockers {
+		l := &inv.Lockers[i]
+		if l.Contents == nil { continue }
+
+		occupied += 1
+		ctrl := inv.Control[l.SizeId]
+		if ctrl.Size.Contains(p.size) && !ctrl.Inactive && !l.OutOfService {
+			suitable += 1
+		}
+	}
+
+	if suitable == 0 || retrievalsPerHour <= 0 {
+		return -1
+	}
+	hours := float64(occupied) / float64(suitable) / retrievalsPerHour
+	return time.Duration(hours * float64(time.Hour))
+}
@@ -0,0 +1,208 @@
+package lockers
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Inventory_FillToOccupancy(t *testing.T) {
+	own_size := func(inv *Inventory) func(LockerSize) SizeSpec {
+		return func(size_id LockerSize) SizeSpec { return inv.Control[size_id].Size }
+	}
+
+	type X struct {
+		fraction float64
+		too_big bool
+		placed int
+	}
+
+	tests := map[string]X{
+		"none":        X{0, false, 0},
+		"half":        X{0.5, false, 5},
+		"rounds-up":   X{0.4, false, 4},
+		"full":        X{1, false, 9},
+		"overfull":    X{2, false, 9},
+		"nothing-fits": X{1, true, 0},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{5,1,1}: 3, SizeSpec{3,3,1}: 2, SizeSpec{5,5,5}: 2})
+			size_of := own_size(inv)
+			if v.too_big {
+				size_of = func(LockerSize) SizeSpec { return SizeSpec{9,9,9} }
+			}
+
+			placed := inv.FillToOccupancy(v.fraction, size_of)
+			if placed != v.placed {
+				t.Errorf("Wrong number of packages placed: expected %d, got %d", v.placed, placed)
+			}
+			if len(inv.LockersByPackageId) != v.placed {
+				t.Errorf("Wrong number of packages stored: expected %d, got %d", v.placed, len(inv.LockersByPackageId))
+			}
+
+			valid, explanation := ValidateInventory(t, inv)
+			if !valid {
+				t.Errorf("Invalid or malformed inventory:\n%+v\n%s", inv, explanation)
+			}
+		})
+	}
+}
+
+func Test_Inventory_BestSizeAfter(t *testing.T) {
+	type X struct {
+		hypothetical []SizeSpec
+		size SizeSpec
+		answer SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"no-queue":        X{nil, SizeSpec{1,1,1}, SizeSpec{1,1,1}, false},
+		"small-gone":      X{[]SizeSpec{{1,1,1}, {1,1,1}}, SizeSpec{1,1,1}, SizeSpec{5,1,1}, false},
+		"rejects-skipped": X{[]SizeSpec{{9,9,9}, {1,1,1}}, SizeSpec{1,1,1}, SizeSpec{1,1,1}, false},
+		"denormalized":    X{[]SizeSpec{{1,1,1}, {1,1,1}}, SizeSpec{1,4,1}, SizeSpec{5,1,1}, false},
+		"all-gone":        X{[]SizeSpec{{5,5,5}, {2,2,1}, {2,2,1}}, SizeSpec{2,2,1}, SizeSpec{}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			before := cplx(t)
+
+			out, err := inv.BestSizeAfter(v.hypothetical, v.size)
+			eq, explain := CompareInventories(t, inv, before)
+			if !eq {
+				t.Errorf("Inventory was modified:\n%s", explain)
+			}
+
+			if err != nil && v.is_error {
+				return
+			} else if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			} else if v.is_error {
+				t.Errorf("Expected error, but got %v instead", out)
+				return
+			}
+
+			if out != inv.Sizes[v.answer] {
+				t.Errorf("Wrong answer: expected %v, got %v", v.answer, inv.Control[out].Size)
+			}
+		})
+	}
+}
+
+func Test_Inventory_RelocationPlan(t *testing.T) {
+	type X struct {
+		stored map[LockerID]*Package
+		plan []Relocation
+	}
+
+	tests := map[string]X{
+		"empty": X{nil, nil},
+		"tight": X{map[LockerID]*Package{"2": &Package{Id: "a", Size: SizeSpec{1,1,1}}}, nil},
+		"oversized": X{map[LockerID]*Package{
+			"5": &Package{Id: "a", Size: SizeSpec{1,1,3}},
+			"7": &Package{Id: "b", Size: SizeSpec{1,1,1}},
+		}, []Relocation{
+			Relocation{"b", "7", "2"},
+			Relocation{"a", "5", "4.5"},
+		}},
+		"no-room": X{map[LockerID]*Package{
+			"1": &Package{Id: "a", Size: SizeSpec{1,1,1}},
+			"2": &Package{Id: "b", Size: SizeSpec{1,1,1}},
+			"7": &Package{Id: "c", Size: SizeSpec{2,2,2}},
+		}, nil},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, before := cplx(t), cplx(t)
+			for id, pkg := range v.stored {
+				copied := *pkg
+				store_in(t, inv, id, pkg)
+				store_in(t, before, id, &copied)
+			}
+
+			plan := inv.RelocationPlan()
+			if len(plan) != len(v.plan) {
+				t.Fatalf("Wrong plan: expected %v, got %v", v.plan, plan)
+			}
+			for i := range plan {
+				if plan[i] != v.plan[i] {
+					t.Errorf("Wrong plan: expected %v, got %v", v.plan, plan)
+				}
+			}
+
+			eq, explain := CompareInventories(t, inv, before)
+			if !eq {
+				t.Errorf("Inventory was modified:\n%s", explain)
+			}
+		})
+	}
+}
+
+func Test_Inventory_EstimateAvailability(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type X struct {
+		size SizeSpec
+		rate float64
+		expected time.Duration
+	}
+
+	// two small and two big lockers, all occupied
+	tests := map[string]X{
+		"any locker": X{SizeSpec{1,1,1}, 2, 30 * time.Minute},
+		"big only":   X{SizeSpec{2,2,2}, 2, time.Hour},
+		"slow":       X{SizeSpec{2,2,2}, 0.5, 4 * time.Hour},
+		"never fits": X{SizeSpec{3,3,3}, 2, -1},
+		"no rate":    X{SizeSpec{1,1,1}, 0, -1},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := NewInventoryWithIDGen(map[SizeSpec]int{SizeSpec{1,1,1}: 2, SizeSpec{2,2,2}: 2}, SequentialIDGen("L"))
+			for _, l := range []LockerID{"L-1", "L-2", "L-3", "L-4"} {
+				store_in(t, inv, l, &Package{Id: PackageID(l), Size: SizeSpec{1,1,1}})
+			}
+			if wait := inv.EstimateAvailability(v.size, v.rate, now); wait != v.expected {
+				t.Errorf("Expected %v, got %v", v.expected, wait)
+			}
+		})
+	}
+
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 1})
+	if wait := inv.EstimateAvailability(SizeSpec{1,1,1}, 1, now); wait != 0 {
+		t.Errorf("Expected no wait with a free locker, got %v", wait)
+	}
+}
+
+func Test_Inventory_Simulations_Quiet(t *testing.T) {
+	inv := NewInventory(map[SizeSpec]int{SizeSpec{1,1,1}: 1, SizeSpec{2,2,2}: 2})
+	inv.Clock = ticking_clock()
+	if _, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{1,1,1}, Margin: 0}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.SetAlarm(inv.Sizes[SizeSpec{2,2,2}], 1, 2)
+
+	logger := &RecordingLogger{}
+	inv.Logger = logger
+	hooks := 0
+	inv.OnDeposit = func(pkg *Package, locker LockerID) { hooks += 1 }
+	inv.OnRetrieve = func(pkg *Package, locker LockerID) { hooks += 1 }
+	inv.OnCapacityAlarm = func(size LockerSize, raised bool, capacity int) { hooks += 1 }
+
+	inv.BestSizeAfter([]SizeSpec{SizeSpec{1,1,1}, SizeSpec{2,2,2}, SizeSpec{2,2,2}}, SizeSpec{1,1,1})
+	EvaluateStrategy(inv, []SizeSpec{SizeSpec{2,2,2}, SizeSpec{2,2,2}, SizeSpec{1,1,1}}, ScarcityStrategy)
+	inv.RelocationPlan()
+	inv.DeepCopy().DepositPackage(&Package{Id: "b", Size: SizeSpec{2,2,2}})
+
+	if len(logger.Lines) != 0 {
+		t.Errorf("Hypothetical changes were logged:\n%v", logger.Lines)
+	}
+	if hooks != 0 {
+		t.Errorf("Hypothetical changes were reported to %d hooks", hooks)
+	}
+}
@@ -0,0 +1,106 @@
+package lockers
+
+import (
+	"errors"
+	"math"
+)
+
+// Stacks a package into a specific locker, alongside any packages already in it, for
+// bulk lockers holding many small items. The package must fit in the locker, and the
+// total volume of the packages in it, multiplied by the inventory's PackingFactor,
+// must not exceed the locker's volume. A locker with packages stacked in it counts as
+// a single allocated locker no matter how many it holds, so VirtualCapacity does not
+// reflect the room left in it; use RemainingVolume for that. Stacked packages are
+// retrieved like any other, and the locker is emptied once the last one is taken out.
+//
+// Returns an error without changing anything if the locker or package is a problem,
+// such as an unknown locker, a duplicate package ID, a locker which is out of service
+// or empty and not available, or a package which does not fit. Stacking into an empty
+// locker takes a locker like a deposit does, so it returns ErrFacilityAtCapacity
+// if that would put the inventory beyond its MaxOccupancy.
+func (inv *Inventory) StackPackage(id LockerID, pkg *Package) error {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return ErrUnknownLockerID
+	}
+	if _, ok := inv.LockersByPackageId[pkg.Id]; ok {
+		return ErrDuplicatePackageID
+	}
+	if pkg.StoredIn != nil {
+		return ErrPackageAlreadyStored
+	}
+
+	l := &inv.Lockers[index]
+	volume := pkg.Size.Normalize().Volume()
+	if !inv.packagePlacement(pkg, PurposeBoth).fits(inv.Control[l.SizeId]) {
+		return errors.New("Package does not fit in locker")
+	}
+	if inv.remainingVolume(index) < volume {
+		return errors.New("Not enough room in locker")
+	}
+	if !inv.Control[l.SizeId].carries(l.weight() + pkg.Weight) {
+		return errors.New("Package is too heavy for locker")
+	}
+	if inv.Control[l.SizeId].Inactive || l.OutOfService {
+		return errors.New("Locker is not available")
+	}
+
+	if l.Contents == nil {
+		position := inv.availablePosition(index)
+		if position < 0 {
+			return errors.New("Locker is not available")
+		}
+		if inv.atMaxOccupancy() {
+			return ErrFacilityAtCapacity
+		}
+		inv.allocateAt(l.SizeId, position)
+		inv.forgetRetrieved(pkg, index)
+		l.Put(pkg)
+		l.LastFilled = inv.now()
+		l.TightestOffered = LockerSize(0)
+		inv.holdGroup(index)
+	} else {
+		inv.forgetRetrieved(pkg, index)
+		l.Stacked = append(l.Stacked, pkg)
+		l.VolumeUsed += volume
+		pkg.StoredIn = l
+	}
+
+	inv.Control[l.SizeId].Deposits += 1
+	inv.LockersByPackageId[pkg.Id] = index
+	inv.logf("lockers: stacked package %s into locker %s", pkg.Id, l.Id)
+	if inv.OnDeposit != nil {
+		inv.OnDeposit(pkg, l.Id)
+	}
+	return nil
+}
+
+// Returns how much more package volume could be stacked into a locker (see
+// StackPackage), taking the inventory's PackingFactor into account, or an error if
+// the locker ID is not known.
+func (inv *Inventory) RemainingVolume(id LockerID) (int64, error) {
+	index, ok := inv.LockersById[id]
+	if !ok {
+		return 0, ErrUnknownLockerID
+	}
+	return inv.remainingVolume(index), nil
+}
+
+func (inv *Inventory) remainingVolume(locker_index int) int64 {
+	l := &inv.Lockers[locker_index]
+	factor := inv.PackingFactor
+	if factor <= 0 {
+		factor = 1
+	}
+
+	// a huge locker with a small factor may have more room than an int64 holds
+	room := float64(inv.Control[l.SizeId].Size.Volume()) / factor
+	if room >= math.MaxInt64 {
+		return math.MaxInt64 - l.VolumeUsed
+	}
+	remaining := int64(room) - l.VolumeUsed
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,206 @@
+package lockers
+
+import (
+	"math"
+	"testing"
+)
+
+// two lockers with room for 8 units of volume, of which up to 4 can be used once the
+// packing factor is taken into account.
+func bulk_fixture(t *testing.T) *Inventory {
+	t.Helper()
+
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+		SizeSpec{2,2,2}: []LockerID{"bulk", "other"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.PackingFactor = 2
+	inv.Clock = ticking_clock()
+	return inv
+}
+
+func Test_Inventory_StackPackage(t *testing.T) {
+	type X struct {
+		locker LockerID
+		pkg *Package
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"ok":             X{"bulk", &Package{Id: "b", Size: SizeSpec{1,1,2}}, false},
+		"too much":       X{"bulk", &Package{Id: "b", Size: SizeSpec{2,2,1}}, true},
+		"does not fit":   X{"bulk", &Package{Id: "b", Size: SizeSpec{3,1,1}}, true},
+		"duplicate":      X{"bulk", &Package{Id: "a", Size: SizeSpec{1,1,1}}, true},
+		"unknown locker": X{"nope", &Package{Id: "b", Size: SizeSpec{1,1,1}}, true},
+		"empty locker":   X{"other", &Package{Id: "b", Size: SizeSpec{1,1,1}}, false},
+		"light enough":   X{"bulk", &Package{Id: "b", Size: SizeSpec{1,1,1}, Weight: 4}, false},
+		"too heavy":      X{"bulk", &Package{Id: "b", Size: SizeSpec{1,1,1}, Weight: 5}, true},
+		"heavy alone":    X{"other", &Package{Id: "b", Size: SizeSpec{1,1,1}, Weight: 10}, false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := bulk_fixture(t)
+			inv.SetMaxWeight(inv.Sizes[SizeSpec{2,2,2}], 10)
+			if err := inv.StackPackage("bulk", &Package{Id: "a", Size: SizeSpec{1,1,1}, Weight: 6}); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			err := inv.StackPackage(v.locker, v.pkg)
+			if (err != nil) != v.is_error {
+				t.Errorf("Unexpected error result: %v", err)
+			}
+			if err == nil && inv.Lockers[inv.LockersByPackageId[v.pkg.Id]].Id != v.locker {
+				t.Errorf("Package indexed to the wrong locker")
+			}
+			if err := inv.CheckInvariants(); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func Test_Inventory_StackPackage_OutOfService(t *testing.T) {
+	inv := bulk_fixture(t)
+	if err := inv.StackPackage("bulk", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for _, id := range []LockerID{"bulk", "other"} {
+		if err := inv.SetLockerStatus(id, true); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	for _, id := range []LockerID{"bulk", "other"} {
+		if err := inv.StackPackage(id, &Package{Id: "b", Size: SizeSpec{1,1,1}}); err == nil || err.Error() != "Locker is not available" {
+			t.Errorf("Locker %s: expected the locker to be unavailable, got %v", id, err)
+		}
+	}
+	if _, ok := inv.LockersByPackageId["b"]; ok {
+		t.Errorf("Package stacked into an out of service locker")
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func Test_Inventory_StackPackage_MaxOccupancy(t *testing.T) {
+	inv := bulk_fixture(t)
+	if err := inv.StackPackage("bulk", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.MaxOccupancy = 0.5
+
+	// the bulk locker is already taken, so stacking into it is still allowed
+	if err := inv.StackPackage("bulk", &Package{Id: "b", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if err := inv.StackPackage("other", &Package{Id: "c", Size: SizeSpec{1,1,1}}); err != ErrFacilityAtCapacity {
+		t.Errorf("Expected ErrFacilityAtCapacity, got %v", err)
+	}
+	if _, ok := inv.LockersByPackageId["c"]; ok {
+		t.Errorf("Rejected package was stacked")
+	}
+	if err := inv.CheckInvariants(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func Test_Inventory_StackPackage_WastedVolume(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{10,10,10}: []LockerID{"bulk"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for _, id := range []PackageID{"a", "b"} {
+		if err := inv.StackPackage("bulk", &Package{Id: id, Size: SizeSpec{5,5,5}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	if waste := inv.WastedVolume(); waste != 750 {
+		t.Errorf("Expected waste of 750, got %d", waste)
+	}
+}
+
+func Test_Inventory_StackPackage_Retrieve(t *testing.T) {
+	inv := bulk_fixture(t)
+	size_id := inv.Sizes[SizeSpec{2,2,2}]
+
+	for _, id := range []PackageID{"a", "b", "c", "d"} {
+		if err := inv.StackPackage("bulk", &Package{Id: id, Size: SizeSpec{1,1,1}}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	if err := inv.StackPackage("bulk", &Package{Id: "e", Size: SizeSpec{1,1,1}}); err == nil {
+		t.Errorf("Expected an error stacking into a full locker")
+	}
+	if inv.Control[size_id].VirtualCapacity != 1 {
+		t.Errorf("Bulk locker counted more than once: capacity %d", inv.Control[size_id].VirtualCapacity)
+	}
+
+	type X struct {
+		by_locker bool
+		id PackageID
+		remaining int64
+	}
+
+	// retrievals by locker take the package on top of the stack
+	steps := []X{
+		X{false, "b", 1},
+		X{true, "d", 2},
+		X{false, "a", 3},
+		X{true, "c", 4},
+	}
+
+	for i, step := range steps {
+		var pkg *Package
+		var err error
+		if step.by_locker {
+			pkg, err = inv.RetrievePackageByLockerId("bulk")
+		} else {
+			pkg, err = inv.RetrievePackageById(step.id)
+		}
+		if err != nil {
+			t.Fatalf("Step %d: unexpected error: %s", i, err.Error())
+		}
+		if pkg.Id != step.id || pkg.StoredIn != nil {
+			t.Errorf("Step %d: wrong package: expected %s, got %+v", i, step.id, *pkg)
+		}
+		if remaining, _ := inv.RemainingVolume("bulk"); remaining != step.remaining {
+			t.Errorf("Step %d: wrong remaining volume: expected %d, got %d", i, step.remaining, remaining)
+		}
+		if err := inv.CheckInvariants(); err != nil {
+			t.Errorf("Step %d: unexpected error: %s", i, err.Error())
+		}
+	}
+
+	if inv.Control[size_id].VirtualCapacity != 2 {
+		t.Errorf("Emptied bulk locker not released: capacity %d", inv.Control[size_id].VirtualCapacity)
+	}
+	if !inv.Lockers[inv.LockersById["bulk"]].Dirty() {
+		t.Errorf("Emptied bulk locker is not dirty")
+	}
+	if _, err := inv.RemainingVolume("nope"); err == nil {
+		t.Errorf("Expected an error for an unknown locker")
+	}
+}
+
+func Test_Inventory_RemainingVolume_Huge(t *testing.T) {
+	inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{SizeSpec{2097152,2097152,2097152}: []LockerID{"huge"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	inv.PackingFactor = 0.5
+
+	if remaining, _ := inv.RemainingVolume("huge"); remaining != math.MaxInt64 {
+		t.Errorf("Expected the room to be clamped, got %d", remaining)
+	}
+	if err := inv.StackPackage("huge", &Package{Id: "a", Size: SizeSpec{1,1,1}}); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if waste := inv.WastedVolume(); waste != math.MaxInt64 - 1 {
+		t.Errorf("Expected the waste to be clamped, got %d", waste)
+	}
+}
@@ -0,0 +1,139 @@
+package lockers
+
+// A way of choosing which size of locker a package should go into. It is given the
+// ids of every size which can hold the package and has a usable available locker
+// (always at least one), and returns one of them. Candidates are ordered by the
+// clearance they leave around the package, most first, so a strategy which keeps the
+// first of equally good candidates prefers the least skewed fit. A strategy which
+// returns anything else, such as LockerSize(0), declines to choose: on its own that
+// fails the deposit, but in a chain (see ChainStrategy) the next strategy is asked.
+type SelectionStrategy func(candidates []LockerSize, inv IControlSpec) LockerSize
+
+// A way of choosing which size of locker a package should go into, for an inventory's
+// Strategy. Choose is given candidates and returns one of them as a SelectionStrategy
+// does. Every SelectionStrategy is a PlacementStrategy, so the built in strategies
+// (ScarcityStrategy, TightestFitStrategy and LargestFirstStrategy) can be used as
+// one, and so can any function of the right type converted to SelectionStrategy.
+type PlacementStrategy interface {
+	Choose(candidates []LockerSize, inv IControlSpec) LockerSize
+}
+
+// Chooses between candidates by calling the strategy. See PlacementStrategy.
+func (s SelectionStrategy) Choose(candidates []LockerSize, inv IControlSpec) LockerSize {
+	return s(candidates, inv)
+}
+
+// The default strategy, which chooses the candidate that comes first according to
+// LockerSize.Before: the one with the most selection capacity (virtual capacity plus
+// any PriorityBias), then the smallest volume.
+// See Inventory.GetMostSuitableLockerSize for the rationale.
+func ScarcityStrategy(candidates []LockerSize, inv IControlSpec) LockerSize {
+	chosen_id := candidates[0]
+	for _, id := range candidates[1:] {
+		if id.Before(chosen_id, inv) {
+			chosen_id = id
+		}
+	}
+	return chosen_id
+}
+
+// A strategy which packs as tightly as possible, choosing the candidate with the
+// smallest volume (ties are broken by comparing dimensions), regardless of how scarce
+// lockers of that size are.
+func TightestFitStrategy(candidates []LockerSize, inv IControlSpec) LockerSize {
+	chosen_id := candidates[0]
+	for _, id := range candidates[1:] {
+		if inv.ControlSpec(id).Size.tighterThan(inv.ControlSpec(chosen_id).Size) {
+			chosen_id = id
+		}
+	}
+	return chosen_id
+}
+
+// A strategy which preserves small lockers by choosing the candidate with the largest
+// volume (ties are broken by comparing dimensions, largest first), regardless of how
+// scarce lockers of that size are. This is the opposite of TightestFitStrategy.
+func LargestFirstStrategy(candidates []LockerSize, inv IControlSpec) LockerSize {
+	chosen_id := candidates[0]
+	for _, id := range candidates[1:] {
+		if inv.ControlSpec(chosen_id).Size.tighterThan(inv.ControlSpec(id).Size) {
+			chosen_id = id
+		}
+	}
+	return chosen_id
+}
+
+// Combines strategies into one which asks each of them in turn, and chooses what the
+// first to return one of the candidates chooses, so that a strategy for special cases
+// can decline and leave the rest to a more general one. If every strategy declines,
+// so does the chain. An empty chain is the same as ScarcityStrategy.
+func ChainStrategy(strategies ...SelectionStrategy) SelectionStrategy {
+	if len(strategies) == 0 {
+		return ScarcityStrategy
+	}
+
+	return func(candidates []LockerSize, inv IControlSpec) LockerSize {
+		for _, strategy := range strategies {
+			chosen_id := strategy(candidates, inv)
+			for _, id := range candidates {
+				if id == chosen_id {
+					return chosen_id
+				}
+			}
+		}
+		return LockerSize(0)
+	}
+}
+
+// places an outbound package into the inventory, as DepositPackage, except that sizes
+// are chosen by a chain of strategies (see ChainStrategy) rather than the default one.
+func (inv *Inventory) DepositPackageChain(pkg *Package, strategies ...SelectionStrategy) (LockerID, error) {
+	p := inv.packagePlacement(pkg, PurposeDeposit)
+	p.strategy = ChainStrategy(strategies...)
+	return inv.deposit(pkg, p)
+}
+
+// Sets the PriorityBias of a size, making the selection strategy treat it as having
+// bias more available lockers than it really does (or fewer, if bias is negative), to
+// temporarily steer deposits towards or away from it, such as to fill a size during a
+// promotion. Only selection is affected: a size with no usable locker is never chosen,
+// however large its bias. A bias of 0 removes it. Unknown sizes are ignored.
+func (inv *Inventory) SetSizePriorityBias(size LockerSize, bias int) {
+	if ctrl, ok := inv.Control[size]; ok {
+		ctrl.PriorityBias = bias
+	}
+}
+
+// The results of running a series of deposits with some strategy. See EvaluateStrategy.
+type StrategyReport struct {
+	// how many of the packages were placed, and how many were rejected.
+	Placed, Rejected int
+
+	// the inventory's WastedVolume and FragmentationScore after the deposits.
+	WastedVolume int64
+	Fragmentation float64
+}
+
+// Measures how well a strategy would handle a series of deposits, by making them, in
+// order, against a copy of the inventory using the given strategy to choose sizes.
+// The inventory itself is not changed. This allows strategies to be compared on the
+// same starting state and demand before switching between them.
+func EvaluateStrategy(inv *Inventory, demand []SizeSpec, strat SelectionStrategy) StrategyReport {
+	c := inv.clone()
+
+	var report StrategyReport
+	for _, size := range demand {
+		p := c.placementFor(size.Normalize(), PurposeDeposit)
+		p.strategy = strat
+		_, err := c.deposit(&Package{Id: newPackageId(), Size: size}, p)
+		if err != nil {
+			report.Rejected += 1
+		} else {
+			report.Placed += 1
+		}
+	}
+
+	report.WastedVolume = c.WastedVolume()
+	report.Fragmentation = c.FragmentationScore()
+	return report
+}
@@ -0,0 +1,335 @@
+package lockers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// two sizes which can both hold a 2x1x1 package but neither of which contains the
+// other: a plentiful square one, and a single tighter long one.
+func strategy_fixture(t testing.TB) *Inventory {
+	t.Helper()
+
+	return NewInventory(map[SizeSpec]int{
+		SizeSpec{2,2,1}: 3,
+		SizeSpec{3,1,1}: 1,
+	})
+}
+
+func Test_EvaluateStrategy(t *testing.T) {
+	type X struct {
+		strategy SelectionStrategy
+		demand []SizeSpec
+		expected StrategyReport
+	}
+
+	tests := map[string]X{
+		"no demand":    X{ScarcityStrategy, nil, StrategyReport{}},
+		"scarcity":     X{ScarcityStrategy, []SizeSpec{SizeSpec{2,1,1}}, StrategyReport{1, 0, 2, 1}},
+		"tightest fit": X{TightestFitStrategy, []SizeSpec{SizeSpec{2,1,1}}, StrategyReport{1, 0, 1, 0}},
+		"overflow":     X{TightestFitStrategy, []SizeSpec{SizeSpec{2,1,1}, SizeSpec{1,1,1}}, StrategyReport{2, 0, 4, 0.5}},
+		"nothing fits": X{TightestFitStrategy, []SizeSpec{SizeSpec{4,1,1}}, StrategyReport{0, 1, 0, 0}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := strategy_fixture(t)
+			report := EvaluateStrategy(inv, v.demand, v.strategy)
+			if report != v.expected {
+				t.Errorf("Unexpected report: got %+v, expected %+v", report, v.expected)
+			}
+			if len(inv.LockersByPackageId) != 0 {
+				t.Errorf("Inventory was modified by evaluation")
+			}
+		})
+	}
+}
+
+func Benchmark_EvaluateStrategy(b *testing.B) {
+	demand := make([]SizeSpec, 0, 64)
+	for i := 0; i < cap(demand); i++ {
+		demand = append(demand, SizeSpec{1 + i % 3, 1, 1})
+	}
+
+	strategies := map[string]SelectionStrategy{
+		"scarcity": ScarcityStrategy,
+		"tightest fit": TightestFitStrategy,
+	}
+
+	for k, strat := range strategies {
+		b.Run(k, func(b *testing.B) {
+			inv := strategy_fixture(b)
+			var report StrategyReport
+			for i := 0; i < b.N; i++ {
+				report = EvaluateStrategy(inv, demand, strat)
+			}
+			b.ReportMetric(float64(report.WastedVolume), "wasted")
+			b.ReportMetric(report.Fragmentation, "fragmentation")
+		})
+	}
+}
+
+func Test_Inventory_GetMostSuitableLockerSize_Clearance(t *testing.T) {
+	// both sizes have a volume of 24, the same capacity, and neither contains the other,
+	// so only the shape of the space left over tells them apart.
+	inv := NewInventory(map[SizeSpec]int{
+		SizeSpec{6,2,2}: 2,
+		SizeSpec{4,3,2}: 2,
+	})
+
+	type X struct {
+		pkg SizeSpec
+		expected SizeSpec
+	}
+
+	tests := map[string]X{
+		"more clearance":   X{SizeSpec{4,1,1}, SizeSpec{6,2,2}},
+		"less skewed":      X{SizeSpec{2,2,1}, SizeSpec{4,3,2}},
+		"tie goes tighter": X{SizeSpec{2,1,1}, SizeSpec{4,3,2}},
+		"only one fits":    X{SizeSpec{5,1,1}, SizeSpec{6,2,2}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				size_id, err := inv.GetMostSuitableLockerSize(v.pkg)
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+				if got := inv.Control[size_id].Size; got != v.expected {
+					t.Fatalf("Expected %v, got %v", v.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_Inventory_candidates_Clearance(t *testing.T) {
+	// in cplx, every package which fits both {5,1,1} and {3,3,1} is one deep, so it
+	// leaves no clearance in either and they tie, going to the tighter {5,1,1}. A
+	// {2,2,1} package only fits {3,3,1} and {5,5,5}, so it never has to choose
+	// between the two.
+	type X struct {
+		pkg SizeSpec
+		expected []LockerSize
+	}
+
+	tests := map[string]X{
+		"tie":          X{SizeSpec{2,1,1}, []LockerSize{400, 200, 300}},
+		"longest tie":  X{SizeSpec{3,1,1}, []LockerSize{400, 200, 300}},
+		"flat":         X{SizeSpec{2,2,1}, []LockerSize{400, 300}},
+		"long":         X{SizeSpec{4,1,1}, []LockerSize{400, 200}},
+		"smallest":     X{SizeSpec{1,1,1}, []LockerSize{400, 100, 200, 300}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := cplx(t)
+			for i := 0; i < 10; i++ {
+				got := inv.candidates(inv.placementFor(v.pkg.Normalize(), PurposeDeposit))
+				if !reflect.DeepEqual(got, v.expected) {
+					t.Fatalf("Expected %v, got %v", v.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_SizeSpec_clearance(t *testing.T) {
+	type X struct {
+		size, pkg SizeSpec
+		clearance int
+	}
+
+	tests := map[string]X{
+		"cplx long":  X{SizeSpec{5,1,1}, SizeSpec{2,1,1}, 0},
+		"cplx flat":  X{SizeSpec{3,3,1}, SizeSpec{2,1,1}, 0},
+		"cplx cube":  X{SizeSpec{5,5,5}, SizeSpec{2,2,1}, 3},
+		"normalizes": X{SizeSpec{4,2,2}, SizeSpec{1,1,2}, 1},
+		"too big":    X{SizeSpec{3,3,1}, SizeSpec{2,2,2}, -1},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			if c := v.size.clearance(v.pkg); c != v.clearance {
+				t.Errorf("Expected %d, got %d", v.clearance, c)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DepositPackage_PreferTight(t *testing.T) {
+	type X struct {
+		pkg Package
+		expected SizeSpec
+	}
+
+	tests := map[string]X{
+		"default":      X{Package{Id: "a", Size: SizeSpec{2,1,1}}, SizeSpec{2,2,1}},
+		"prefer tight": X{Package{Id: "a", Size: SizeSpec{2,1,1}, PreferTight: true}, SizeSpec{3,1,1}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := strategy_fixture(t)
+			pkg := v.pkg
+			id, err := inv.DepositPackage(&pkg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if size := inv.Control[inv.Lockers[inv.LockersById[id]].SizeId].Size; size != v.expected {
+				t.Errorf("Unexpected locker size: got %v, expected %v", size, v.expected)
+			}
+		})
+	}
+}
+
+func Test_Inventory_SetSizePriorityBias(t *testing.T) {
+	type X struct {
+		size SizeSpec
+		bias int
+		expected SizeSpec
+	}
+
+	tests := map[string]X{
+		"no bias":        X{SizeSpec{3,1,1}, 0, SizeSpec{2,2,1}},
+		"too small":      X{SizeSpec{3,1,1}, 1, SizeSpec{2,2,1}},
+		"boosted":        X{SizeSpec{3,1,1}, 3, SizeSpec{3,1,1}},
+		"penalized":      X{SizeSpec{2,2,1}, -3, SizeSpec{3,1,1}},
+		"unknown size":   X{SizeSpec{3,3,3}, 100, SizeSpec{2,2,1}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := strategy_fixture(t)
+			narrow := inv.ControlSpec(inv.Sizes[SizeSpec{3,1,1}])
+			inv.SetSizePriorityBias(inv.Sizes[v.size], v.bias)
+			if narrow.VirtualCapacity != 1 {
+				t.Errorf("Bias changed real capacity")
+			}
+
+			id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{2,1,1}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if size := inv.Control[inv.Lockers[inv.LockersById[id]].SizeId].Size; size != v.expected {
+				t.Errorf("Unexpected locker size: got %v, expected %v", size, v.expected)
+			}
+		})
+	}
+}
+
+func Test_Inventory_DepositPackageChain(t *testing.T) {
+	decline := func(candidates []LockerSize, inv IControlSpec) LockerSize { return LockerSize(0) }
+	narrow_only := func(candidates []LockerSize, inv IControlSpec) LockerSize {
+		for _, id := range candidates {
+			if inv.ControlSpec(id).Size == (SizeSpec{3,1,1}) {
+				return id
+			}
+		}
+		return LockerSize(0)
+	}
+
+	type X struct {
+		strategies []SelectionStrategy
+		fill bool
+		expected SizeSpec
+		is_error bool
+	}
+
+	tests := map[string]X{
+		"empty chain":    X{nil, false, SizeSpec{2,2,1}, false},
+		"first chooses":  X{[]SelectionStrategy{TightestFitStrategy, ScarcityStrategy}, false, SizeSpec{3,1,1}, false},
+		"falls through":  X{[]SelectionStrategy{decline, ScarcityStrategy}, false, SizeSpec{2,2,1}, false},
+		"special case":   X{[]SelectionStrategy{narrow_only, ScarcityStrategy}, false, SizeSpec{3,1,1}, false},
+		"special full":   X{[]SelectionStrategy{narrow_only, ScarcityStrategy}, true, SizeSpec{2,2,1}, false},
+		"all decline":    X{[]SelectionStrategy{decline, narrow_only}, true, SizeSpec{}, true},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := strategy_fixture(t)
+			if v.fill {
+				if _, err := inv.DepositPackageChain(&Package{Id: "x", Size: SizeSpec{3,1,1}}); err != nil {
+					t.Fatalf("Unexpected error: %s", err.Error())
+				}
+			}
+
+			id, err := inv.DepositPackageChain(&Package{Id: "a", Size: SizeSpec{2,1,1}}, v.strategies...)
+			if (err != nil) != v.is_error {
+				t.Fatalf("Unexpected error result: %v", err)
+			}
+			if err != nil {
+				if _, ok := inv.LockersByPackageId["a"]; ok {
+					t.Errorf("Failed deposit stored the package")
+				}
+				return
+			}
+			if size := inv.Control[inv.Lockers[inv.LockersById[id]].SizeId].Size; size != v.expected {
+				t.Errorf("Unexpected locker size: got %v, expected %v", size, v.expected)
+			}
+		})
+	}
+}
+
+// a PlacementStrategy which is not a SelectionStrategy, and counts its calls.
+type counting_strategy struct {
+	calls int
+}
+
+func (s *counting_strategy) Choose(candidates []LockerSize, inv IControlSpec) LockerSize {
+	s.calls += 1
+	return candidates[0]
+}
+
+func Test_Inventory_Strategy(t *testing.T) {
+	type X struct {
+		strategy PlacementStrategy
+		expected SizeSpec
+	}
+
+	tests := map[string]X{
+		"default":       X{nil, SizeSpec{2,2,1}},
+		"scarcity":      X{SelectionStrategy(ScarcityStrategy), SizeSpec{2,2,1}},
+		"tightest fit":  X{SelectionStrategy(TightestFitStrategy), SizeSpec{3,1,1}},
+		"largest first": X{SelectionStrategy(LargestFirstStrategy), SizeSpec{3,3,3}},
+		"custom":        X{&counting_strategy{}, SizeSpec{3,3,3}},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv := strategy_fixture(t)
+			inv.AddLockers(SizeSpec{3,3,3}, 1)
+			inv.Strategy = v.strategy
+
+			size_id, err := inv.GetMostSuitableLockerSize(SizeSpec{2,1,1})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if size := inv.Control[size_id].Size; size != v.expected {
+				t.Errorf("Unexpected size: got %v, expected %v", size, v.expected)
+			}
+
+			id, err := inv.DepositPackage(&Package{Id: "a", Size: SizeSpec{2,1,1}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if size := inv.Control[inv.Lockers[inv.LockersById[id]].SizeId].Size; size != v.expected {
+				t.Errorf("Unexpected locker size: got %v, expected %v", size, v.expected)
+			}
+			if c, ok := v.strategy.(*counting_strategy); ok && c.calls != 2 {
+				t.Errorf("Expected the strategy to be called twice, got %d", c.calls)
+			}
+
+			// a package's own preference still wins.
+			if v.expected == (SizeSpec{3,1,1}) { return }
+			id, err = inv.DepositPackage(&Package{Id: "b", Size: SizeSpec{2,1,1}, PreferTight: true})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if size := inv.Control[inv.Lockers[inv.LockersById[id]].SizeId].Size; size != (SizeSpec{3,1,1}) {
+				t.Errorf("PreferTight package went into %v", size)
+			}
+		})
+	}
+}
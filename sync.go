@@ -0,0 +1,113 @@
+package lockers
+
+import (
+	"sync"
+)
+
+// An Inventory which is safe to use from several goroutines at once. Operations which
+// change the inventory hold a write lock for their whole duration, so lockers, sizes
+// and the lookup maps are always updated together, and read-only queries hold a read
+// lock, so they can run alongside each other. Inventory itself does no locking, and
+// remains the better choice for single threaded use.
+//
+// The wrapped inventory must not be used directly while the SyncInventory is in use,
+// except through WithLock and WithRLock. The inventory's Logger, Clock and callbacks
+// are called with the lock held, so they must not call back into the SyncInventory.
+type SyncInventory struct {
+	mu sync.RWMutex
+	inv *Inventory
+}
+
+// Wraps an inventory for concurrent use.
+func NewSyncInventory(inv *Inventory) *SyncInventory {
+	return &SyncInventory{inv: inv}
+}
+
+// Calls f with the write lock held, for operations which SyncInventory does not wrap,
+// or for several operations which must happen together. f must not keep the inventory,
+// or anything in it, after it returns.
+func (s *SyncInventory) WithLock(f func(inv *Inventory)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.inv)
+}
+
+// Calls f with the read lock held, for read-only queries which SyncInventory does not
+// wrap. f must not change the inventory, nor keep it, or anything in it, after it
+// returns.
+func (s *SyncInventory) WithRLock(f func(inv *Inventory)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f(s.inv)
+}
+
+// See Inventory.DepositPackage.
+func (s *SyncInventory) DepositPackage(pkg *Package) (LockerID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.DepositPackage(pkg)
+}
+
+// See Inventory.RetrievePackage.
+func (s *SyncInventory) RetrievePackage(pkg *Package) (*Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.RetrievePackage(pkg)
+}
+
+// See Inventory.RetrievePackageById.
+func (s *SyncInventory) RetrievePackageById(id PackageID) (*Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.RetrievePackageById(id)
+}
+
+// See Inventory.RetrievePackageByLockerId.
+func (s *SyncInventory) RetrievePackageByLockerId(id LockerID) (*Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.RetrievePackageByLockerId(id)
+}
+
+// See Inventory.AllocateLocker.
+func (s *SyncInventory) AllocateLocker(size_id LockerSize) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.AllocateLocker(size_id)
+}
+
+// See Inventory.DeallocateLocker.
+func (s *SyncInventory) DeallocateLocker(locker_index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inv.DeallocateLocker(locker_index)
+}
+
+// See Inventory.AdjustVirtualCapacity.
+func (s *SyncInventory) AdjustVirtualCapacity(size_id LockerSize, by int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inv.AdjustVirtualCapacity(size_id, by)
+}
+
+// See Inventory.GetMostSuitableLockerSize.
+func (s *SyncInventory) GetMostSuitableLockerSize(package_size SizeSpec) (LockerSize, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inv.GetMostSuitableLockerSize(package_size)
+}
+
+// See Inventory.HasFreeExact.
+func (s *SyncInventory) HasFreeExact(size SizeSpec) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inv.HasFreeExact(size)
+}
+
+// See Inventory.CapacitySnapshot. The snapshot is consistent, since nothing can
+// change the inventory while it is taken.
+func (s *SyncInventory) CapacitySnapshot() CapacitySnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.inv.CapacitySnapshot()
+}
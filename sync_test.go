@@ -0,0 +1,59 @@
+package lockers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func Test_SyncInventory_Concurrent(t *testing.T) {
+	const workers, each = 8, 50
+
+	s := NewSyncInventory(NewInventory(map[SizeSpec]int{
+		SizeSpec{1,1,1}: workers * each,
+		SizeSpec{2,2,2}: workers,
+	}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2 * workers * each)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				pkg := &Package{Id: PackageID(fmt.Sprintf("%d-%d", w, i)), Size: SizeSpec{1,1,1}}
+				if _, err := s.DepositPackage(pkg); err != nil {
+					errs <- err
+					continue
+				}
+				if _, err := s.RetrievePackageById(pkg.Id); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				snapshot := s.CapacitySnapshot()
+				if snapshot.Free > snapshot.Total {
+					errs <- fmt.Errorf("inconsistent snapshot %+v", snapshot)
+				}
+				s.GetMostSuitableLockerSize(SizeSpec{1,1,1})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	s.WithRLock(func(inv *Inventory) {
+		if len(inv.LockersByPackageId) != 0 {
+			t.Errorf("Expected every package to be retrieved, %d remain", len(inv.LockersByPackageId))
+		}
+		if err := inv.CheckInvariants(); err != nil {
+			t.Errorf("Invariants violated: %s", err.Error())
+		}
+	})
+}
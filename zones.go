@@ -0,0 +1,50 @@
+package lockers
+
+import (
+	"sort"
+)
+
+// Suggests how to even out use of a size whose lockers are split across several
+// zones (see Locker.Zone): if some zone has lockers of the size but none of them are
+// available, and another zone does have some available, returns the full zone and
+// the zone with the most available lockers of the size, so that a package headed for
+// the full zone could be sent to the other instead. Ties are broken by zone name.
+// Lockers with no zone are ignored. This only makes a suggestion, and changes nothing.
+func (inv *Inventory) RebalanceHint(size LockerSize) (fromZone, toZone string, ok bool) {
+	ctrl, known := inv.Control[size]
+	if !known || ctrl.Inactive {
+		return "", "", false
+	}
+
+	free := make(map[string]int)
+	for i := range inv.Lockers {
+		if inv.Lockers[i].SizeId == size && inv.Lockers[i].Zone != "" {
+			free[inv.Lockers[i].Zone] += 0
+		}
+	}
+	for _, index := range ctrl.Lockers {
+		if zone := inv.Lockers[index].Zone; zone != "" {
+			free[zone] += 1
+		}
+	}
+
+	zones := make([]string, 0, len(free))
+	for zone := range free {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	for _, zone := range zones {
+		if free[zone] == 0 && fromZone == "" {
+			fromZone = zone
+		}
+		if free[zone] > 0 && (toZone == "" || free[zone] > free[toZone]) {
+			toZone = zone
+		}
+	}
+
+	if fromZone == "" || toZone == "" {
+		return "", "", false
+	}
+	return fromZone, toZone, true
+}
@@ -0,0 +1,49 @@
+package lockers
+
+import (
+	"testing"
+)
+
+func Test_Inventory_RebalanceHint(t *testing.T) {
+	type X struct {
+		zones map[LockerID]string
+		occupied []LockerID
+		from, to string
+		ok bool
+	}
+
+	tests := map[string]X{
+		"no zones":       X{nil, []LockerID{"a1", "a2"}, "", "", false},
+		"one zone":       X{map[LockerID]string{"a1": "a", "a2": "a"}, []LockerID{"a1", "a2"}, "", "", false},
+		"none full":      X{map[LockerID]string{"a1": "a", "b1": "b"}, nil, "", "", false},
+		"all full":       X{map[LockerID]string{"a1": "a", "b1": "b", "b2": "b", "c1": "c"}, []LockerID{"a1", "b1", "b2", "c1"}, "", "", false},
+		"rebalance":      X{map[LockerID]string{"a1": "a", "a2": "a", "b1": "b"}, []LockerID{"a1", "a2"}, "a", "b", true},
+		"most room":      X{map[LockerID]string{"a1": "a", "b1": "b", "c1": "c", "c2": "c"}, []LockerID{"a1", "b1"}, "a", "c", true},
+		"ties by name":   X{map[LockerID]string{"a1": "b", "a2": "a", "b1": "d", "b2": "c"}, []LockerID{"a1", "a2"}, "a", "c", true},
+		"unzoned":        X{map[LockerID]string{"a1": "a", "b1": "b"}, []LockerID{"a1", "b1"}, "", "", false},
+	}
+
+	for k, v := range tests {
+		t.Run(k, func(t *testing.T) {
+			inv, err := NewInventoryWithIDs(map[SizeSpec][]LockerID{
+				SizeSpec{1,1,1}: []LockerID{"a1", "a2", "b1", "b2", "c1", "c2"},
+				SizeSpec{2,2,2}: []LockerID{"big"},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			for id, zone := range v.zones {
+				inv.Lockers[inv.LockersById[id]].Zone = zone
+			}
+			inv.Lockers[inv.LockersById["big"]].Zone = "a"
+			for _, id := range v.occupied {
+				store_in(t, inv, id, &Package{Id: PackageID(id), Size: SizeSpec{1,1,1}})
+			}
+
+			from, to, ok := inv.RebalanceHint(inv.Sizes[SizeSpec{1,1,1}])
+			if from != v.from || to != v.to || ok != v.ok {
+				t.Errorf("Unexpected hint: got (%q, %q, %t), expected (%q, %q, %t)", from, to, ok, v.from, v.to, v.ok)
+			}
+		})
+	}
+}